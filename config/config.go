@@ -1,9 +1,111 @@
 package config
 
+import (
+	"os"
+	"strconv"
+)
+
 const (
 	// DataDir to ścieżka do katalogu z bazami danych
 	DataDir = "./data/collections"
 
+	// TrashDir to ścieżka do kosza z usuniętymi bazami i kolekcjami
+	TrashDir = "./data/trash"
+
+	// AuthFile to plik z kluczami API i ich uprawnieniami
+	AuthFile = "./data/auth.json"
+
+	// AuditFile to dziennik audytu operacji usuwających danych i administracyjnych
+	AuditFile = "./data/audit.log"
+
 	// Port na którym uruchomiony jest serwer
 	Port = "8080"
 )
+
+var (
+	// TrashRetentionDays to liczba dni, po których elementy kosza są usuwane na stałe (0 - nigdy)
+	TrashRetentionDays = envInt("BASEDB_TRASH_RETENTION_DAYS", 30)
+
+	// SegmentMaxBytes to docelowy rozmiar pliku segmentu w kolekcjach segmentowanych
+	SegmentMaxBytes = envInt("BASEDB_SEGMENT_MAX_BYTES", 4<<20)
+
+	// ChangeStreamHistory to liczba ostatnich zdarzeń zmian przechowywanych do wznawiania strumieni watch
+	ChangeStreamHistory = envInt("BASEDB_CHANGE_STREAM_HISTORY", 10000)
+
+	// WebhookWorkers to liczba równoległych wysyłek webhooków
+	WebhookWorkers = envInt("BASEDB_WEBHOOK_WORKERS", 4)
+
+	// WebhookMaxAttempts to liczba prób wysłania zdarzenia, po której trafia ono na listę nieudanych
+	WebhookMaxAttempts = envInt("BASEDB_WEBHOOK_MAX_ATTEMPTS", 6)
+
+	// WebhookRetryDelayMs to opóźnienie pierwszej ponownej próby w milisekundach, podwajane przy kolejnych
+	WebhookRetryDelayMs = envInt("BASEDB_WEBHOOK_RETRY_DELAY_MS", 1000)
+
+	// WebhookLogSize to liczba ostatnich wywołań webhooków kolekcji zapisywanych w dzienniku
+	WebhookLogSize = envInt("BASEDB_WEBHOOK_LOG_SIZE", 200)
+
+	// WebhookDeadLetterSize to liczba ostatnich nieudanych zdarzeń kolekcji przechowywanych do ponownego wysłania
+	WebhookDeadLetterSize = envInt("BASEDB_WEBHOOK_DEAD_LETTER_SIZE", 1000)
+
+	// WebhookLogFlushMs to odstęp w milisekundach, co który wpisy dziennika webhooków są zapisywane do pliku
+	WebhookLogFlushMs = envInt("BASEDB_WEBHOOK_LOG_FLUSH_MS", 1000)
+
+	// RateLimitRead to liczba żądań odczytu na sekundę dozwolona dla klucza API lub adresu IP (0 - bez limitu)
+	RateLimitRead = envInt("BASEDB_RATE_LIMIT_READ", 200)
+
+	// RateLimitWrite to liczba żądań zapisu na sekundę dozwolona dla klucza API lub adresu IP (0 - bez limitu)
+	RateLimitWrite = envInt("BASEDB_RATE_LIMIT_WRITE", 50)
+
+	// RateLimitAdmin to liczba poleceń administracyjnych na sekundę dozwolona dla klucza API lub adresu IP (0 - bez limitu)
+	RateLimitAdmin = envInt("BASEDB_RATE_LIMIT_ADMIN", 10)
+
+	// RateLimitBurstSeconds określa pojemność kubełka jako wielokrotność limitu na sekundę
+	RateLimitBurstSeconds = envInt("BASEDB_RATE_LIMIT_BURST_SECONDS", 2)
+
+	// MaxBodyBytes to maksymalny rozmiar ciała żądania JSON (nie dotyczy importu NDJSON i CSV)
+	MaxBodyBytes = envInt("BASEDB_MAX_BODY_BYTES", 16<<20)
+
+	// MaxRestoreBytes to maksymalny rozmiar archiwum przesyłanego do odtworzenia kopii zapasowej
+	MaxRestoreBytes = envInt("BASEDB_MAX_RESTORE_BYTES", 1<<30)
+
+	// MaxDocumentBytes to maksymalny rozmiar pojedynczego dokumentu w ciele żądania
+	MaxDocumentBytes = envInt("BASEDB_MAX_DOCUMENT_BYTES", 1<<20)
+
+	// MaxNestingDepth to maksymalna głębokość zagnieżdżenia obiektów i tablic JSON
+	MaxNestingDepth = envInt("BASEDB_MAX_NESTING_DEPTH", 64)
+
+	// MaxArrayLength to maksymalna liczba elementów tablicy JSON
+	MaxArrayLength = envInt("BASEDB_MAX_ARRAY_LENGTH", 100000)
+
+	// ProfileSlowMs to domyślny próg w milisekundach, powyżej którego zapytanie trafia do system.profile
+	ProfileSlowMs = envInt("BASEDB_PROFILE_SLOW_MS", 100)
+
+	// ProfileMaxEntries to liczba ostatnich wpisów przechowywanych w kolekcji system.profile bazy
+	ProfileMaxEntries = envInt("BASEDB_PROFILE_MAX_ENTRIES", 1000)
+
+	// CursorTimeoutSeconds to czas bezczynności, po którym kursor zapytania jest zamykany
+	CursorTimeoutSeconds = envInt("BASEDB_CURSOR_TIMEOUT_SECONDS", 600)
+
+	// MaxCursors to maksymalna liczba otwartych kursorów; po jej przekroczeniu zamykany jest najdawniej używany
+	MaxCursors = envInt("BASEDB_MAX_CURSORS", 1000)
+
+	// MasterKey to klucz główny szyfrowania danych (32 bajty w base64 lub hex); pusty wyłącza szyfrowanie
+	MasterKey = os.Getenv("BASEDB_MASTER_KEY")
+
+	// MasterKeyFile to plik z kluczem głównym, używany gdy MasterKey nie jest ustawiony
+	MasterKeyFile = os.Getenv("BASEDB_MASTER_KEY_FILE")
+
+	// PreviousMasterKey to poprzedni klucz główny, potrzebny do odczytu kluczy danych po jego zmianie
+	PreviousMasterKey = os.Getenv("BASEDB_PREVIOUS_MASTER_KEY")
+
+	// PreviousMasterKeyFile to plik z poprzednim kluczem głównym
+	PreviousMasterKeyFile = os.Getenv("BASEDB_PREVIOUS_MASTER_KEY_FILE")
+)
+
+// envInt odczytuje liczbę całkowitą ze zmiennej środowiskowej lub zwraca wartość domyślną
+func envInt(name string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return value
+	}
+	return defaultValue
+}
@@ -8,10 +8,15 @@ import (
 	"strings"
 
 	"BaseDB/config"
+	"BaseDB/utils"
 )
 
 // HandleAPI obsługuje wszystkie żądania do API
 func HandleAPI(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIKey(w, r) {
+		return
+	}
+
 	// Parsowanie ścieżki i parametrów
 	path := strings.TrimPrefix(r.URL.Path, "/api/database/")
 	segments := strings.Split(path, "/")
@@ -21,12 +26,22 @@ func HandleAPI(w http.ResponseWriter, r *http.Request) {
 	switch len(segments) {
 	case 1: // /api/{nameDB}?command=...
 		if segments[0] == "" {
+			if command != "" {
+				handleServerOperation(w, r, command)
+				return
+			}
 			listDatabases(w, r)
 			return
 		}
 		handleDatabaseOperation(w, r, segments[0], command)
 	case 2: // /api/{nameDB}/{nameCollection}?command=...
 		handleCollectionOperation(w, r, segments[0], segments[1], command)
+	case 3: // /api/{nameDB}/{nameCollection}/{id}
+		if segments[2] == "" {
+			handleCollectionOperation(w, r, segments[0], segments[1], command)
+			return
+		}
+		getDocuments(w, r, utils.GetCollectionPath(config.DataDir, segments[0], segments[1]), segments[2])
 	default:
 		http.Error(w, "Nieprawidłowa ścieżka", http.StatusBadRequest)
 	}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// auditEntry to wpis dziennika audytu. Każdy wpis zawiera skrót poprzedniego,
+// więc zmiana lub usunięcie wpisu w środku dziennika przerywa łańcuch.
+type auditEntry struct {
+	Seq        int64                  `json:"seq"`
+	Timestamp  string                 `json:"timestamp"`
+	User       string                 `json:"user"`
+	RemoteAddr string                 `json:"remote_addr"`
+	Action     string                 `json:"action"`
+	Database   string                 `json:"database,omitempty"`
+	Collection string                 `json:"collection,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	PrevHash   string                 `json:"prev_hash"`
+	Hash       string                 `json:"hash"`
+}
+
+// computeHash zwraca skrót wpisu liczony z jego treści bez pola hash
+func (e auditEntry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sealedAuditLine to wiersz dziennika audytu zaszyfrowany kluczem głównym o podanym id
+type sealedAuditLine struct {
+	Key    string `json:"key"`
+	Sealed string `json:"sealed"`
+}
+
+// auditLineAAD wiąże zaszyfrowane wiersze z dziennikiem audytu
+const auditLineAAD = "audit-log"
+
+var (
+	auditMu       sync.Mutex
+	auditLoaded   bool
+	auditLastSeq  int64
+	auditLastHash string
+)
+
+// recordAudit dopisuje do dziennika audytu operację wykonaną w ramach żądania.
+// Błąd zapisu nie cofa operacji, jest jednak odnotowywany w logu serwera.
+func recordAudit(r *http.Request, action, dbName, collName string, details map[string]interface{}) {
+	// Szczegóły przechodzą przez JSON, aby skrót był liczony z tej samej postaci (map
+	// z posortowanymi kluczami), którą verifyAuditChain odczyta z dziennika
+	if details != nil {
+		var normalized map[string]interface{}
+		if data, err := json.Marshal(details); err == nil && json.Unmarshal(data, &normalized) == nil {
+			details = normalized
+		}
+	}
+
+	entry := auditEntry{
+		Timestamp:  models.GetCurrentTimestamp(),
+		User:       requestUser(r),
+		RemoteAddr: r.RemoteAddr,
+		Action:     action,
+		Database:   dbName,
+		Collection: collName,
+		Details:    details,
+	}
+	if err := appendAudit(entry); err != nil {
+		slog.Error("nie można zapisać wpisu audytu", "action", action, "error", err)
+	}
+}
+
+// appendAudit nadaje wpisowi kolejny numer, wiąże go z poprzednim wpisem i zapisuje na końcu dziennika
+func appendAudit(entry auditEntry) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if !auditLoaded {
+		entries, err := readAuditEntries()
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			last := entries[len(entries)-1]
+			auditLastSeq, auditLastHash = last.Seq, last.Hash
+		}
+		auditLoaded = true
+	}
+
+	entry.Seq = auditLastSeq + 1
+	entry.PrevHash = auditLastHash
+	entry.Hash = entry.computeHash()
+
+	line, err := json.Marshal(entry)
+	if err == nil {
+		line, err = sealAuditLine(line)
+	}
+	if err != nil {
+		return err
+	}
+	if err := utils.EnsureDirectoryExists(filepath.Dir(config.AuditFile)); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(config.AuditFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	auditLastSeq, auditLastHash = entry.Seq, entry.Hash
+	return nil
+}
+
+// readAuditEntries odczytuje wszystkie wpisy dziennika audytu
+func readAuditEntries() ([]auditEntry, error) {
+	file, err := os.Open(config.AuditFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		data, _, err := openAuditLine(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("wpis audytu w linii %d: %v", line, err)
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("uszkodzony wpis audytu w linii %d: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// sealAuditLine szyfruje wiersz dziennika audytu bieżącym kluczem głównym.
+// Bez klucza głównego wiersz jest zapisywany jawnie.
+func sealAuditLine(line []byte) ([]byte, error) {
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+
+	if len(masterKeys) == 0 {
+		return line, nil
+	}
+	master := masterKeys[0]
+	sealed, err := seal(master.aead, line, []byte(auditLineAAD))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealedAuditLine{Key: master.id, Sealed: base64.StdEncoding.EncodeToString(sealed)})
+}
+
+// openAuditLine odszyfrowuje wiersz dziennika audytu i zwraca id klucza głównego, którym
+// go zaszyfrowano. Wiersze zapisane przed włączeniem szyfrowania są zwracane bez zmian.
+func openAuditLine(line []byte) ([]byte, string, error) {
+	var sealed sealedAuditLine
+	if err := json.Unmarshal(line, &sealed); err != nil || sealed.Sealed == "" {
+		return line, "", nil
+	}
+
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+
+	for _, master := range masterKeys {
+		if master.id != sealed.Key {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(sealed.Sealed)
+		if err == nil {
+			data, err = open(master.aead, data, []byte(auditLineAAD))
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("nie można odszyfrować wpisu")
+		}
+		return data, master.id, nil
+	}
+	return nil, "", fmt.Errorf("wpis zaszyfrowano nieznanym kluczem głównym %s", sealed.Key)
+}
+
+// reencryptAuditLog szyfruje bieżącym kluczem głównym wiersze dziennika audytu zapisane jawnie
+// lub poprzednim kluczem głównym i zwraca liczbę zmienionych wierszy
+func reencryptAuditLog() (int, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	encryptionMu.Lock()
+	current := ""
+	if len(masterKeys) > 0 {
+		current = masterKeys[0].id
+	}
+	encryptionMu.Unlock()
+	if current == "" {
+		return 0, nil
+	}
+
+	data, err := os.ReadFile(config.AuditFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var out bytes.Buffer
+	count := 0
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		plain, keyID, err := openAuditLine(line)
+		if err == nil && keyID != current {
+			line, err = sealAuditLine(plain)
+			count++
+		}
+		if err != nil {
+			return 0, fmt.Errorf("wpis audytu w linii %d: %v", i+1, err)
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return count, writeFileAtomic(config.AuditFile, out.Bytes())
+}
+
+// verifyAuditChain sprawdza ciągłość łańcucha skrótów i zwraca numer pierwszego
+// niezgodnego wpisu lub 0, gdy dziennik jest nienaruszony
+func verifyAuditChain(entries []auditEntry) int64 {
+	prevHash := ""
+	for i, entry := range entries {
+		if entry.Seq != int64(i+1) || entry.PrevHash != prevHash || entry.computeHash() != entry.Hash {
+			return int64(i + 1)
+		}
+		prevHash = entry.Hash
+	}
+	return 0
+}
+
+// queryAudit zwraca wpisy dziennika audytu (od najnowszych) wraz z wynikiem weryfikacji łańcucha.
+// Filtry: action, user, database, collection, since (RFC3339), limit (domyślnie 100).
+func queryAudit(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Parametr 'limit' musi być dodatnią liczbą całkowitą", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Parametr 'since' musi być datą w formacie RFC3339", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+
+	auditMu.Lock()
+	entries, err := readAuditEntries()
+	auditMu.Unlock()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Błąd odczytu dziennika audytu: %v", err), http.StatusInternalServerError)
+		return
+	}
+	brokenAt := verifyAuditChain(entries)
+
+	results := []auditEntry{}
+	for i := len(entries) - 1; i >= 0 && len(results) < limit; i-- {
+		entry := entries[i]
+		if (query.Get("action") != "" && entry.Action != query.Get("action")) ||
+			(query.Get("user") != "" && entry.User != query.Get("user")) ||
+			(query.Get("database") != "" && entry.Database != query.Get("database")) ||
+			(query.Get("collection") != "" && entry.Collection != query.Get("collection")) {
+			continue
+		}
+		if !since.IsZero() {
+			if ts, err := time.Parse(time.RFC3339, entry.Timestamp); err == nil && ts.Before(since) {
+				continue
+			}
+		}
+		results = append(results, entry)
+	}
+
+	response := map[string]interface{}{
+		"status":   "success",
+		"total":    len(entries),
+		"count":    len(results),
+		"verified": brokenAt == 0,
+		"entries":  results,
+	}
+	if brokenAt != 0 {
+		response["broken_at"] = brokenAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
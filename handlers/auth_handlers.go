@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// Uprawnienia kluczy API
+const (
+	permissionAdmin   = "admin"   // zarządzanie kluczami, ustawienia szyfrowania
+	permissionDecrypt = "decrypt" // odczyt pól szyfrowanych w postaci jawnej
+)
+
+// knownPermissions to uprawnienia, które można nadać kluczom API
+var knownPermissions = map[string]bool{
+	permissionAdmin:   true,
+	permissionDecrypt: true,
+}
+
+// apiKey opisuje klucz API. Przechowywany jest tylko skrót klucza.
+type apiKey struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	KeyHash     string   `json:"key_hash"`
+	Permissions []string `json:"permissions"`
+	CreatedAt   string   `json:"created_at"`
+}
+
+// has sprawdza, czy klucz ma uprawnienie
+func (k *apiKey) has(permission string) bool {
+	for _, p := range k.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	authMu        sync.Mutex
+	apiKeys       []apiKey
+	apiKeysLoaded bool
+)
+
+// loadAPIKeys zwraca klucze API z pliku config.AuthFile
+func loadAPIKeys() ([]apiKey, error) {
+	authMu.Lock()
+	defer authMu.Unlock()
+
+	if !apiKeysLoaded {
+		var keys []apiKey
+		if err := utils.ReadJSONFile(config.AuthFile, &keys); err != nil {
+			return nil, err
+		}
+		apiKeys = keys
+		apiKeysLoaded = true
+	}
+	return apiKeys, nil
+}
+
+// saveAPIKeys zapisuje klucze API
+func saveAPIKeys(keys []apiKey) error {
+	authMu.Lock()
+	defer authMu.Unlock()
+
+	if err := utils.EnsureDirectoryExists(filepath.Dir(config.AuthFile)); err != nil {
+		return err
+	}
+	if keys == nil {
+		keys = []apiKey{}
+	}
+	if err := utils.WriteJSONFile(config.AuthFile, keys); err != nil {
+		return err
+	}
+	apiKeys = keys
+	apiKeysLoaded = true
+	return nil
+}
+
+// hashAPIKey zwraca skrót klucza API zapisywany w pliku
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestAPIKey zwraca klucz API przesłany w nagłówku X-API-Key lub Authorization: Bearer
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// authenticate zwraca klucz API wywołującego lub nil dla żądania bez klucza
+func authenticate(r *http.Request) (*apiKey, error) {
+	key := requestAPIKey(r)
+	if key == "" {
+		return nil, nil
+	}
+
+	keys, err := loadAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := hashAPIKey(key)
+	for i := range keys {
+		if subtle.ConstantTimeCompare([]byte(keys[i].KeyHash), []byte(hash)) == 1 {
+			return &keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("nieprawidłowy klucz API")
+}
+
+// checkAPIKey odrzuca żądania z nieznanym kluczem API (401)
+func checkAPIKey(w http.ResponseWriter, r *http.Request) bool {
+	if _, err := authenticate(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// hasPermission sprawdza, czy wywołujący przedstawił klucz API z uprawnieniem
+func hasPermission(r *http.Request, permission string) bool {
+	key, err := authenticate(r)
+	return err == nil && key != nil && key.has(permission)
+}
+
+// requestUser zwraca nazwę klucza API wywołującego lub pusty napis
+func requestUser(r *http.Request) string {
+	if key, err := authenticate(r); err == nil && key != nil {
+		return key.Name
+	}
+	return ""
+}
+
+// requireAdmin wymaga uprawnienia admin, gdy istnieje już klucz administratora.
+// Dopóki żadnego nie utworzono, polecenia administracyjne są dostępne dla wszystkich,
+// co pozwala utworzyć pierwszy klucz.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	keys, err := loadAPIKeys()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Błąd odczytu kluczy API: %v", err), http.StatusInternalServerError)
+		return false
+	}
+
+	for i := range keys {
+		if keys[i].has(permissionAdmin) {
+			if !hasPermission(r, permissionAdmin) {
+				http.Error(w, "Brak uprawnień: wymagany klucz API z uprawnieniem 'admin'", http.StatusForbidden)
+				return false
+			}
+			return true
+		}
+	}
+	return true
+}
+
+// parsePermissions odczytuje listę uprawnień oddzielonych przecinkami
+func parsePermissions(value string) ([]string, error) {
+	permissions := []string{}
+	seen := map[string]bool{}
+	for _, p := range strings.Split(value, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" || seen[p] {
+			continue
+		}
+		if !knownPermissions[p] {
+			return nil, fmt.Errorf("Nieznane uprawnienie '%s'", p)
+		}
+		seen[p] = true
+		permissions = append(permissions, p)
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+// handleAuthOperation obsługuje polecenia kluczy API: createApiKey, listApiKeys, deleteApiKey, setPermissions
+func handleAuthOperation(w http.ResponseWriter, r *http.Request, command string) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	keys, err := loadAPIKeys()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Błąd odczytu kluczy API: %v", err), http.StatusInternalServerError)
+		return
+	}
+	updated := append([]apiKey{}, keys...)
+
+	switch command {
+	case "listApiKeys":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"count":  len(keys),
+			"keys":   keys,
+		})
+
+	case "createApiKey":
+		name := query.Get("name")
+		if name == "" {
+			http.Error(w, "Parametr 'name' jest wymagany", http.StatusBadRequest)
+			return
+		}
+		permissions, err := parsePermissions(query.Get("permissions"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		secret := make([]byte, 24)
+		if _, err := rand.Read(secret); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można wygenerować klucza: %v", err), http.StatusInternalServerError)
+			return
+		}
+		key := "bdb_" + hex.EncodeToString(secret)
+		entry := apiKey{
+			ID:          uuid.New().String(),
+			Name:        name,
+			KeyHash:     hashAPIKey(key),
+			Permissions: permissions,
+			CreatedAt:   models.GetCurrentTimestamp(),
+		}
+
+		if err := saveAPIKeys(append(updated, entry)); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można zapisać kluczy API: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(r, "createApiKey", "", "", map[string]interface{}{
+			"key_id": entry.ID, "name": entry.Name, "permissions": entry.Permissions,
+		})
+
+		// Klucz jest zwracany tylko raz, w pliku zapisywany jest jego skrót
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": "Utworzono klucz API; zapisz go, nie będzie można go odczytać ponownie",
+			"key":     key,
+			"api_key": entry,
+		})
+
+	case "deleteApiKey", "setPermissions":
+		index := -1
+		for i := range updated {
+			if updated[i].ID == query.Get("id") {
+				index = i
+			}
+		}
+		if index < 0 {
+			http.Error(w, "Nie znaleziono klucza API", http.StatusNotFound)
+			return
+		}
+
+		target := updated[index]
+		details := map[string]interface{}{"key_id": target.ID, "name": target.Name}
+		message := fmt.Sprintf("Usunięto klucz API '%s'", updated[index].Name)
+		if command == "setPermissions" {
+			permissions, err := parsePermissions(query.Get("permissions"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			updated[index].Permissions = permissions
+			details["previous_permissions"] = target.Permissions
+			details["permissions"] = permissions
+			message = fmt.Sprintf("Zmieniono uprawnienia klucza API '%s'", updated[index].Name)
+		} else {
+			updated = append(updated[:index], updated[index+1:]...)
+		}
+
+		if err := saveAPIKeys(updated); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można zapisać kluczy API: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, command, "", "", details)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "success",
+			"message": message,
+		})
+	}
+}
@@ -0,0 +1,448 @@
+package handlers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+const (
+	backupFormat       = "basedb-backup/1"
+	backupManifestName = "manifest.json"
+)
+
+// backupManifest opisuje zawartość archiwum kopii zapasowej
+type backupManifest struct {
+	Format    string       `json:"format"`
+	CreatedAt string       `json:"created_at"`
+	Databases []string     `json:"databases"`
+	Files     []backupFile `json:"files"`
+}
+
+// backupFile opisuje pojedynczy plik w archiwum
+type backupFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupDatabases tworzy spójną kopię zapasową baz danych i wysyła ją jako archiwum tar.gz
+func backupDatabases(w http.ResponseWriter, _ *http.Request, dbNames []string) {
+	snapshotDir, err := os.MkdirTemp("", "basedb-backup-")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można utworzyć katalogu tymczasowego: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(snapshotDir)
+
+	manifest := backupManifest{
+		Format:    backupFormat,
+		CreatedAt: models.GetCurrentTimestamp(),
+		Databases: dbNames,
+		Files:     []backupFile{},
+	}
+
+	// Kopiuj pliki każdej bazy pod blokadą jej kolekcji
+	for _, dbName := range dbNames {
+		files, err := snapshotDatabase(dbName, snapshotDir)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Nie można utworzyć kopii bazy '%s': %v", dbName, err), http.StatusInternalServerError)
+			return
+		}
+		manifest.Files = append(manifest.Files, files...)
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można zapisać manifestu: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	name := "basedb"
+	if len(dbNames) == 1 {
+		name = dbNames[0]
+	}
+	filename := fmt.Sprintf("%s-%s.tar.gz", name, time.Now().UTC().Format("20060102T150405Z"))
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	if err := writeTarFile(tw, backupManifestName, int64(len(manifestData)), strings.NewReader(string(manifestData))); err != nil {
+		return
+	}
+
+	for _, file := range manifest.Files {
+		f, err := os.Open(filepath.Join(snapshotDir, filepath.FromSlash(file.Path)))
+		if err != nil {
+			return
+		}
+		err = writeTarFile(tw, file.Path, file.Size, f)
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	tw.Close()
+	gz.Close()
+}
+
+// snapshotDatabase kopiuje pliki bazy danych do katalogu tymczasowego i liczy ich sumy kontrolne
+func snapshotDatabase(dbName, snapshotDir string) ([]backupFile, error) {
+	unlock, err := lockDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dbPath := utils.GetDatabasePath(config.DataDir, dbName)
+	files := []backupFile{}
+
+	err = filepath.Walk(dbPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		relPath, err := filepath.Rel(config.DataDir, filePath)
+		if err != nil {
+			return err
+		}
+		archivePath := filepath.ToSlash(relPath)
+
+		target := filepath.Join(snapshotDir, relPath)
+		if err := utils.EnsureDirectoryExists(filepath.Dir(target)); err != nil {
+			return err
+		}
+
+		size, checksum, err := copyWithChecksum(filePath, target)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, backupFile{Path: archivePath, Size: size, SHA256: checksum})
+		return nil
+	})
+
+	return files, err
+}
+
+// copyWithChecksum kopiuje plik i zwraca jego rozmiar oraz sumę SHA-256
+func copyWithChecksum(source, target string) (int64, string, error) {
+	in, err := os.Open(source)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return 0, "", err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), in)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hash.Sum(nil)), out.Close()
+}
+
+// writeTarFile zapisuje plik do archiwum tar
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// extractBackup rozpakowuje archiwum do katalogu tymczasowego i weryfikuje je z manifestem
+func extractBackup(r io.Reader, targetDir string) (backupManifest, error) {
+	var manifest backupManifest
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, fmt.Errorf("archiwum nie jest w formacie gzip: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	checksums := map[string]backupFile{}
+	hasManifest := false
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("uszkodzone archiwum: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return manifest, fmt.Errorf("niedozwolona ścieżka w archiwum: %s", header.Name)
+		}
+
+		if name == backupManifestName {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return manifest, fmt.Errorf("nieprawidłowy manifest: %w", err)
+			}
+			hasManifest = true
+			continue
+		}
+
+		target := filepath.Join(targetDir, filepath.FromSlash(name))
+		if err := utils.EnsureDirectoryExists(filepath.Dir(target)); err != nil {
+			return manifest, err
+		}
+
+		out, err := os.Create(target)
+		if err != nil {
+			return manifest, err
+		}
+		hash := sha256.New()
+		size, err := io.Copy(io.MultiWriter(out, hash), tr)
+		out.Close()
+		if err != nil {
+			return manifest, fmt.Errorf("uszkodzone archiwum: %w", err)
+		}
+
+		checksums[name] = backupFile{Path: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	}
+
+	if !hasManifest {
+		return manifest, fmt.Errorf("archiwum nie zawiera pliku %s", backupManifestName)
+	}
+	if manifest.Format != backupFormat {
+		return manifest, fmt.Errorf("nieobsługiwany format kopii: %s", manifest.Format)
+	}
+	for _, dbName := range manifest.Databases {
+		if dbName == "" || dbName == "." || dbName == ".." || path.Base(dbName) != dbName {
+			return manifest, fmt.Errorf("nieprawidłowa nazwa bazy danych: %s", dbName)
+		}
+	}
+
+	// Każdy plik z manifestu musi istnieć i mieć zgodną sumę kontrolną
+	for _, file := range manifest.Files {
+		actual, ok := checksums[file.Path]
+		if !ok {
+			return manifest, fmt.Errorf("brak pliku %s w archiwum", file.Path)
+		}
+		if actual.Size != file.Size || actual.SHA256 != file.SHA256 {
+			return manifest, fmt.Errorf("niezgodna suma kontrolna pliku %s", file.Path)
+		}
+		delete(checksums, file.Path)
+	}
+	if len(checksums) > 0 {
+		return manifest, fmt.Errorf("archiwum zawiera pliki spoza manifestu")
+	}
+
+	return manifest, nil
+}
+
+// restoreBackup odtwarza bazy danych z archiwum przesłanego w ciele żądania.
+// Jeśli podano targetDB, archiwum musi zawierać jedną bazę (lub wskazaną parametrem 'source').
+func restoreBackup(w http.ResponseWriter, r *http.Request, targetDB string) {
+	if r.Method != "POST" && r.Method != "PUT" {
+		http.Error(w, "Wymagana metoda POST lub PUT", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Katalog tymczasowy w tym samym systemie plików co dane, aby móc przenosić pliki
+	stagingDir, err := os.MkdirTemp(filepath.Dir(config.DataDir), ".restore-")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można utworzyć katalogu tymczasowego: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(stagingDir)
+
+	manifest, err := extractBackup(http.MaxBytesReader(w, r.Body, int64(config.MaxRestoreBytes)), stagingDir)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]interface{}{
+				"status": "error",
+				"code":   errorBodyTooLarge,
+				"error":  fmt.Sprintf("Archiwum przekracza %d B", config.MaxRestoreBytes),
+			})
+			return
+		}
+		http.Error(w, fmt.Sprintf("Nieprawidłowa kopia zapasowa: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Ustal, które bazy z archiwum i pod jakimi nazwami odtworzyć
+	targets := map[string]string{}
+	if targetDB == "" {
+		for _, dbName := range manifest.Databases {
+			targets[dbName] = dbName
+		}
+	} else {
+		source := r.URL.Query().Get("source")
+		if source == "" {
+			if len(manifest.Databases) != 1 {
+				http.Error(w, "Archiwum zawiera wiele baz danych, wskaż jedną parametrem 'source'", http.StatusBadRequest)
+				return
+			}
+			source = manifest.Databases[0]
+		}
+		found := false
+		for _, dbName := range manifest.Databases {
+			found = found || dbName == source
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("Archiwum nie zawiera bazy '%s'", source), http.StatusBadRequest)
+			return
+		}
+		targets[source] = targetDB
+	}
+
+	sources := make([]string, 0, len(targets))
+	for source := range targets {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	restored := 0
+	for _, source := range sources {
+		count, err := restoreDatabaseFiles(manifest, stagingDir, source, targets[source])
+		if err != nil {
+			// Bazy odtworzone przed błędem zostały już nadpisane
+			recordAudit(r, "restoreBackup", targetDB, "", map[string]interface{}{
+				"databases": targets, "restored_files": restored, "error": err.Error(),
+			})
+			http.Error(w, fmt.Sprintf("Nie można odtworzyć bazy '%s': %v", targets[source], err), http.StatusInternalServerError)
+			return
+		}
+		restored += count
+	}
+	recordAudit(r, "restoreBackup", targetDB, "", map[string]interface{}{
+		"databases": targets, "restored_files": restored, "backup_created_at": manifest.CreatedAt,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "success",
+		"message":        fmt.Sprintf("Odtworzono %d plików z kopii z %s", restored, manifest.CreatedAt),
+		"databases":      targets,
+		"restored_files": restored,
+	})
+}
+
+// restoreDatabaseFiles przenosi pliki jednej bazy z katalogu tymczasowego na miejsce docelowe.
+// Kolekcje obecne w archiwum zastępują kolekcje o tych samych nazwach, a klucze danych
+// z archiwum są dodawane do pęku kluczy bazy.
+func restoreDatabaseFiles(manifest backupManifest, stagingDir, source, target string) (int, error) {
+	dbPath := utils.GetDatabasePath(config.DataDir, target)
+	if err := utils.EnsureDirectoryExists(dbPath); err != nil {
+		return 0, err
+	}
+
+	var files []string
+	var collectionPaths []string
+	for _, file := range manifest.Files {
+		relPath, ok := strings.CutPrefix(file.Path, source+"/")
+		if !ok || relPath == keyRingFile {
+			continue
+		}
+		files = append(files, relPath)
+		if !strings.Contains(relPath, "/") && strings.HasSuffix(relPath, ".json") {
+			collectionPaths = append(collectionPaths, filepath.Join(dbPath, relPath))
+		}
+	}
+
+	unlock := lockCollections(collectionPaths...)
+	defer unlock()
+
+	// Pliki pomocnicze odtwarzanych kolekcji i ustawienia bazy spoza archiwum nie mogą przetrwać
+	// odtworzenia: stara historia, segmenty czy webhooki nie pasowałyby do nowych danych
+	if err := clearRestoreTargets(dbPath, files); err != nil {
+		return 0, err
+	}
+
+	// Pęk kluczy z kopii jest łączony z pękiem bazy, a nie go zastępuje: kolekcje spoza kopii
+	// i dane zapisane po rotacji kluczy muszą pozostać czytelne
+	if err := mergeKeyRing(dbPath, filepath.Join(stagingDir, source)); err != nil {
+		return 0, err
+	}
+
+	for _, relPath := range files {
+		from := filepath.Join(stagingDir, source, filepath.FromSlash(relPath))
+		to := filepath.Join(dbPath, filepath.FromSlash(relPath))
+		if err := utils.EnsureDirectoryExists(filepath.Dir(to)); err != nil {
+			return 0, err
+		}
+		if err := os.Rename(from, to); err != nil {
+			return 0, err
+		}
+	}
+
+	dropIDIndexesWithPrefix(dbPath)
+	return len(files), nil
+}
+
+// clearRestoreTargets usuwa z bazy docelowej pliki pomocnicze kolekcji obecnych w archiwum
+// oraz pliki ustawień bazy (limity, profiler), zanim zostaną zastąpione plikami z kopii
+func clearRestoreTargets(dbPath string, files []string) error {
+	var stale []string
+	for _, relPath := range files {
+		if collName, ok := strings.CutSuffix(relPath, ".json"); ok && !strings.Contains(relPath, "/") {
+			stale = append(stale, collectionSideFilesIn(dbPath, collName)...)
+		}
+	}
+	stale = append(stale, filepath.Join(dbPath, quotaFile), filepath.Join(dbPath, profileFile))
+
+	for _, path := range stale {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listDatabaseNames zwraca nazwy wszystkich baz danych
+func listDatabaseNames() ([]string, error) {
+	entries, err := os.ReadDir(config.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	databases := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			databases = append(databases, entry.Name())
+		}
+	}
+	return databases, nil
+}
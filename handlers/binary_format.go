@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"BaseDB/models"
+)
+
+// Kodowania segmentów kolekcji
+const (
+	encodingJSON   = "json"   // tablica JSON z wcięciami, czytelna dla człowieka
+	encodingBinary = "binary" // rekordy z prefiksem długości skompresowane gzipem
+)
+
+// binarySegmentMagic rozpoczyna rozpakowaną zawartość segmentu binarnego
+const binarySegmentMagic = "BDBSEG1\n"
+
+// maxBinaryRecordSize ogranicza rozmiar pojedynczego rekordu, chroniąc przed uszkodzonymi plikami
+const maxBinaryRecordSize = 256 << 20
+
+// segmentExtension zwraca rozszerzenie pliku segmentu dla kodowania
+func segmentExtension(encoding string) string {
+	if encoding == encodingBinary {
+		return ".bin"
+	}
+	return ".json"
+}
+
+// encodeDocument serializuje dokument do zapisu w segmencie o podanym kodowaniu
+func encodeDocument(doc models.Document, encoding string) ([]byte, error) {
+	if encoding == encodingBinary {
+		return json.Marshal(doc)
+	}
+	return json.MarshalIndent(doc, "  ", "  ")
+}
+
+// encodeBinarySegment zapisuje rekordy jako ciąg par (długość uvarint, dokument JSON) skompresowany gzipem.
+// Nagłówek gzip nie zawiera czasu modyfikacji, więc ta sama zawartość daje tę samą sumę kontrolną.
+func encodeBinarySegment(docs []encodedDocument) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+
+	if _, err := zw.Write([]byte(binarySegmentMagic)); err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, binary.MaxVarintLen64)
+	for _, doc := range docs {
+		n := binary.PutUvarint(prefix, uint64(len(doc.data)))
+		if _, err := zw.Write(prefix[:n]); err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(doc.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// streamBinarySegment odczytuje kolejne dokumenty z odszyfrowanej zawartości segmentu binarnego
+func streamBinarySegment(r io.Reader, fn func(doc models.Document) error) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	reader := bufio.NewReader(zr)
+
+	magic := make([]byte, len(binarySegmentMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != binarySegmentMagic {
+		return fmt.Errorf("plik nie jest segmentem binarnym")
+	}
+
+	for {
+		size, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if size > maxBinaryRecordSize {
+			return fmt.Errorf("nieprawidłowy rozmiar rekordu: %d", size)
+		}
+
+		record := make([]byte, size)
+		if _, err := io.ReadFull(reader, record); err != nil {
+			return err
+		}
+
+		var doc models.Document
+		if err := json.Unmarshal(record, &doc); err != nil {
+			return err
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// Typy zdarzeń strumienia zmian
+const (
+	changeInsert     = "insert"
+	changeUpdate     = "update"
+	changeDelete     = "delete"
+	changeRename     = "rename"
+	changeDrop       = "drop"
+	changeInvalidate = "invalidate" // zbyt wiele zmian naraz, obserwujący powinien odczytać dane ponownie
+)
+
+const (
+	// watcherBuffer to liczba zdarzeń oczekujących na wysłanie, po której obserwujący jest rozłączany
+	watcherBuffer = 256
+
+	// watchHeartbeat to odstęp komentarzy podtrzymujących połączenie SSE
+	watchHeartbeat = 15 * time.Second
+)
+
+// changeEvent opisuje zmianę w bazie danych. Dla baz danych pole Collection jest puste.
+// Dokumenty są zapisane tak jak na dysku, czyli z zaszyfrowanymi polami szyfrowanymi.
+type changeEvent struct {
+	Token         string                 `json:"token"`
+	Type          string                 `json:"type"`
+	Database      string                 `json:"database"`
+	Collection    string                 `json:"collection,omitempty"`
+	DocumentID    string                 `json:"document_id,omitempty"`
+	Document      models.Document        `json:"document,omitempty"`
+	UpdatedFields map[string]interface{} `json:"updated_fields,omitempty"`
+	RemovedFields []string               `json:"removed_fields,omitempty"`
+	NewName       string                 `json:"new_name,omitempty"`
+	Timestamp     string                 `json:"timestamp"`
+
+	sequence uint64
+}
+
+// changeWatcher to otwarty strumień zmian bazy lub kolekcji
+type changeWatcher struct {
+	dbName   string
+	collName string
+	events   chan changeEvent
+	lagging  bool
+}
+
+// matches sprawdza, czy zdarzenie dotyczy obserwowanej bazy lub kolekcji
+func (cw *changeWatcher) matches(event changeEvent) bool {
+	if event.Database != cw.dbName {
+		return false
+	}
+	return cw.collName == "" || event.Collection == "" || event.Collection == cw.collName
+}
+
+// changeBus rozsyła zdarzenia do obserwujących i przechowuje ostatnie zdarzenia do wznawiania
+// strumieni. Historia jest trzymana w pamięci, więc tokeny tracą ważność po restarcie serwera.
+type changeBus struct {
+	mu       sync.Mutex
+	epoch    string
+	sequence uint64
+	history  []changeEvent
+	watchers map[*changeWatcher]bool
+}
+
+var changes = &changeBus{
+	epoch:    strconv.FormatInt(time.Now().UnixNano(), 36),
+	watchers: map[*changeWatcher]bool{},
+}
+
+// errResumeTokenExpired oznacza, że zdarzeń po tokenie nie ma już w historii
+var errResumeTokenExpired = errors.New("Token wznowienia wygasł, odczytaj dane ponownie i otwórz nowy strumień")
+
+// publish nadaje zdarzeniom kolejne tokeny, zapisuje je w historii i wysyła obserwującym.
+// Zdarzenia jednej operacji trafiają do obserwującego w całości, a gdy nie mieszczą się
+// w jego buforze - jako jedno zdarzenie invalidate z tokenem ostatniego z nich.
+// Obserwujący z pełnym buforem nie nadąża z odbiorem, więc jest rozłączany
+// i może wznowić strumień tokenem. Zwraca zdarzenia z nadanymi tokenami.
+func (cb *changeBus) publish(events ...changeEvent) []changeEvent {
+	if len(events) == 0 {
+		return nil
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := models.GetCurrentTimestamp()
+	published := make([]changeEvent, len(events))
+	for i, event := range events {
+		cb.sequence++
+		event.sequence = cb.sequence
+		event.Token = cb.epoch + "-" + strconv.FormatUint(cb.sequence, 10)
+		event.Timestamp = now
+
+		cb.history = append(cb.history, event)
+		if limit := config.ChangeStreamHistory; limit > 0 && len(cb.history) > limit {
+			cb.history = append([]changeEvent(nil), cb.history[len(cb.history)-limit:]...)
+		}
+		published[i] = event
+	}
+
+	for watcher := range cb.watchers {
+		var matching []changeEvent
+		for _, event := range published {
+			if watcher.matches(event) {
+				matching = append(matching, event)
+			}
+		}
+		if len(matching) == 0 {
+			continue
+		}
+
+		// Tylko publish wysyła do kanału, a robi to pod cb.mu, więc wolne miejsce nie zmaleje
+		free := cap(watcher.events) - len(watcher.events)
+		switch {
+		case len(matching) <= free:
+			for _, event := range matching {
+				watcher.events <- event
+			}
+		case free > 0:
+			last := matching[len(matching)-1]
+			watcher.events <- changeEvent{
+				Token:      last.Token,
+				Type:       changeInvalidate,
+				Database:   last.Database,
+				Collection: last.Collection,
+				Timestamp:  now,
+				sequence:   last.sequence,
+			}
+		default:
+			watcher.lagging = true
+			delete(cb.watchers, watcher)
+			close(watcher.events)
+		}
+	}
+	return published
+}
+
+// subscribe rejestruje obserwującego i zwraca zdarzenia zapisane po tokenie wznowienia
+func (cb *changeBus) subscribe(dbName, collName, resumeToken string) (*changeWatcher, []changeEvent, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	watcher := &changeWatcher{dbName: dbName, collName: collName, events: make(chan changeEvent, watcherBuffer)}
+
+	var backlog []changeEvent
+	if resumeToken != "" {
+		separator := strings.LastIndex(resumeToken, "-")
+		if separator < 0 {
+			return nil, nil, fmt.Errorf("Nieprawidłowy token wznowienia")
+		}
+		sequence, err := strconv.ParseUint(resumeToken[separator+1:], 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Nieprawidłowy token wznowienia")
+		}
+
+		// Token z poprzedniego uruchomienia serwera lub starszy niż historia
+		oldest := cb.sequence + 1
+		if len(cb.history) > 0 {
+			oldest = cb.history[0].sequence
+		}
+		if resumeToken[:separator] != cb.epoch || sequence > cb.sequence || sequence+1 < oldest {
+			return nil, nil, errResumeTokenExpired
+		}
+
+		for _, event := range cb.history {
+			if event.sequence > sequence && watcher.matches(event) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	cb.watchers[watcher] = true
+	return watcher, backlog, nil
+}
+
+// unsubscribe wyrejestrowuje obserwującego
+func (cb *changeBus) unsubscribe(watcher *changeWatcher) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.watchers[watcher] {
+		delete(cb.watchers, watcher)
+		close(watcher.events)
+	}
+}
+
+// isLagging sprawdza, czy obserwujący został rozłączony, bo nie nadążał z odbiorem
+func (cb *changeBus) isLagging(watcher *changeWatcher) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return watcher.lagging
+}
+
+// publishChanges publikuje zdarzenia zmian obserwującym i webhookom
+func publishChanges(events ...changeEvent) {
+	dispatchWebhooks(changes.publish(events...))
+}
+
+// insertEvent tworzy zdarzenie dodania dokumentu
+func insertEvent(dbName, collName string, doc models.Document) changeEvent {
+	return changeEvent{Type: changeInsert, Database: dbName, Collection: collName, DocumentID: documentID(doc), Document: doc}
+}
+
+// updateEvent tworzy zdarzenie zmiany dokumentu wraz z listą zmienionych i usuniętych pól
+func updateEvent(dbName, collName string, previous, current models.Document) changeEvent {
+	event := changeEvent{
+		Type:          changeUpdate,
+		Database:      dbName,
+		Collection:    collName,
+		DocumentID:    documentID(current),
+		Document:      current,
+		UpdatedFields: map[string]interface{}{},
+		RemovedFields: []string{},
+	}
+	for key, value := range current {
+		if old, ok := previous[key]; !ok || !jsonEqual(old, value) {
+			event.UpdatedFields[key] = value
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			event.RemovedFields = append(event.RemovedFields, key)
+		}
+	}
+	return event
+}
+
+// deleteEvent tworzy zdarzenie usunięcia dokumentu
+func deleteEvent(dbName, collName string, doc models.Document) changeEvent {
+	return changeEvent{Type: changeDelete, Database: dbName, Collection: collName, DocumentID: documentID(doc), Document: doc}
+}
+
+// changeBatch zbiera zdarzenia operacji zbiorczej, publikowane dopiero po zapisaniu zmian.
+// Po przekroczeniu rozmiaru historii zdarzenia są zastępowane jednym zdarzeniem invalidate.
+type changeBatch struct {
+	dbName   string
+	collName string
+	events   []changeEvent
+	overflow bool
+}
+
+// newChangeBatch tworzy zbiór zdarzeń dla pliku kolekcji
+func newChangeBatch(jsonFilePath string) *changeBatch {
+	dbName, collName, _ := collectionNames(jsonFilePath)
+	return &changeBatch{dbName: dbName, collName: collName}
+}
+
+// insert dodaje zdarzenie dodania dokumentu
+func (cb *changeBatch) insert(doc models.Document) {
+	if cb.overflow {
+		return
+	}
+	if limit := config.ChangeStreamHistory; limit > 0 && len(cb.events) >= limit {
+		cb.events = nil
+		cb.overflow = true
+		return
+	}
+	cb.events = append(cb.events, insertEvent(cb.dbName, cb.collName, doc))
+}
+
+// publish publikuje zebrane zdarzenia
+func (cb *changeBatch) publish() {
+	if cb.overflow {
+		publishChanges(changeEvent{Type: changeInvalidate, Database: cb.dbName, Collection: cb.collName})
+		return
+	}
+	publishChanges(cb.events...)
+}
+
+// watchChanges otwiera strumień zmian bazy lub kolekcji (collName pusty) jako Server-Sent Events.
+// Parametr 'filter' zawiera zapytanie JSON, które muszą spełniać dokumenty w zdarzeniach,
+// a 'fullDocument=true' dodaje pełny dokument do zdarzeń update i delete.
+// Strumień wznawia się od tokenu z nagłówka Last-Event-ID lub parametru 'resumeAfter'.
+func watchChanges(w http.ResponseWriter, r *http.Request, dbName, collName string) {
+	if r.Method != "GET" {
+		http.Error(w, "Wymagana metoda GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Połączenie nie obsługuje strumieniowania", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	var filter map[string]interface{}
+	if raw := query.Get("filter"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &filter); err != nil {
+			http.Error(w, fmt.Sprintf("Nieprawidłowy format JSON w parametrze 'filter': %v", err), http.StatusBadRequest)
+			return
+		}
+		if !validateOperators(w, filter) {
+			return
+		}
+	}
+	fullDocument := query.Get("fullDocument") == "true"
+
+	// Filtr jest szyfrowany osobno dla każdej kolekcji, bo kolekcje mają różne pola szyfrowane.
+	// Kolekcja, dla której filtra nie da się zaszyfrować, ma w mapie wartość nil.
+	sealedFilters := map[string]map[string]interface{}{}
+	sealedFilter := func(collection string) (map[string]interface{}, error) {
+		if sealed, ok := sealedFilters[collection]; ok {
+			return sealed, nil
+		}
+		sealed := make(map[string]interface{}, len(filter))
+		for field, condition := range filter {
+			sealed[field] = condition
+		}
+		if err := sealQueryFor(utils.GetCollectionPath(config.DataDir, dbName, collection), sealed); err != nil {
+			sealedFilters[collection] = nil
+			return nil, err
+		}
+		sealedFilters[collection] = sealed
+		return sealed, nil
+	}
+	if filter != nil && collName != "" {
+		if _, err := sealedFilter(collName); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	resumeToken := r.Header.Get("Last-Event-ID")
+	if resumeToken == "" {
+		resumeToken = query.Get("resumeAfter")
+	}
+
+	watcher, backlog, err := changes.subscribe(dbName, collName, resumeToken)
+	if err == errResumeTokenExpired {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer changes.unsubscribe(watcher)
+
+	revealers := map[string]*fieldRevealer{}
+	send := func(event changeEvent) error {
+		if filter != nil && event.Document != nil {
+			sealed, _ := sealedFilter(event.Collection)
+			if sealed == nil || !matchesQuery(event.Document, sealed) {
+				return nil
+			}
+		}
+
+		if event.Document != nil {
+			revealer, ok := revealers[event.Collection]
+			if !ok {
+				revealer = newFieldRevealer(r, utils.GetCollectionPath(config.DataDir, dbName, event.Collection))
+				revealers[event.Collection] = revealer
+			}
+			if event.Type != changeInsert && !fullDocument {
+				event.Document = nil
+			}
+			document, err := revealer.reveal(event.Document)
+			if err != nil {
+				return err
+			}
+			event.Document = document
+			if event.UpdatedFields != nil {
+				updatedFields, err := revealer.reveal(models.Document(event.UpdatedFields))
+				if err != nil {
+					return err
+				}
+				event.UpdatedFields = map[string]interface{}(updatedFields)
+			}
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Token, event.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": watching\n\n")
+	flusher.Flush()
+
+	for _, event := range backlog {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-watcher.events:
+			if !ok {
+				if changes.isLagging(watcher) {
+					// Klient może wznowić strumień od ostatniego odebranego tokenu
+					fmt.Fprint(w, "event: error\ndata: {\"error\":\"Strumień nie nadążał z odbiorem zdarzeń, połącz się ponownie\"}\n\n")
+					flusher.Flush()
+				}
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// collationPolish to kod języka polskiego w ustawieniach porównywania napisów
+const collationPolish = "pl"
+
+// polishAlphabet to kolejność liter alfabetu polskiego
+const polishAlphabet = "aąbcćdeęfghijklłmnńoópqrsśtuvwxyzźż"
+
+// polishWeights to pozycje liter alfabetu polskiego, zbudowane z polishAlphabet
+var polishWeights = func() map[rune]int {
+	weights := make(map[rune]int, utf8.RuneCountInString(polishAlphabet))
+	i := 0
+	for _, letter := range polishAlphabet {
+		weights[letter] = i
+		i++
+	}
+	return weights
+}()
+
+// collation to ustawienia porównywania napisów przy sortowaniu
+type collation struct {
+	Locale          string `json:"locale,omitempty"`           // "pl" lub pusty (kolejność znaków Unicode)
+	CaseInsensitive bool   `json:"case_insensitive,omitempty"` // wielkość liter nie ma znaczenia
+	Numeric         bool   `json:"numeric,omitempty"`          // ciągi cyfr są porównywane jak liczby ("9" < "12")
+}
+
+// parseCollation odczytuje ustawienia porównywania podane jako obiekt JSON
+// w parametrze URL (napis) lub w ciele zapytania (mapa)
+func parseCollation(value interface{}) (*collation, error) {
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		raw = []byte(v)
+	default:
+		raw, _ = json.Marshal(v)
+	}
+
+	var c collation
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&c); err != nil {
+		return nil, fmt.Errorf("Nieprawidłowe ustawienia porównywania 'collation': %v", err)
+	}
+	c.Locale = strings.ToLower(c.Locale)
+	if c.Locale != "" && c.Locale != collationPolish {
+		return nil, fmt.Errorf("Nieobsługiwany język porównywania '%s' (dostępne: %s)", c.Locale, collationPolish)
+	}
+	return &c, nil
+}
+
+// compare porównuje dwa napisy. Bez ustawień porównywania napisy są porównywane bajt po bajcie.
+// Najpierw porównywane są litery bez względu na wielkość (według alfabetu języka),
+// a przy równości - bez CaseInsensitive - mała litera poprzedza wielką.
+func (c *collation) compare(a, b string) int {
+	if c == nil {
+		return strings.Compare(a, b)
+	}
+	if r := c.compareLevel(a, b, false); r != 0 || c.CaseInsensitive {
+		return r
+	}
+	return c.compareLevel(a, b, true)
+}
+
+// compareLevel porównuje napisy znak po znaku, z ciągami cyfr porównywanymi jak liczby
+// przy ustawieniu Numeric. Z withCase uwzględniana jest też wielkość liter.
+func (c *collation) compareLevel(a, b string, withCase bool) int {
+	for a != "" && b != "" {
+		if c.Numeric && isASCIIDigit(a[0]) && isASCIIDigit(b[0]) {
+			na, restA := digitRun(a)
+			nb, restB := digitRun(b)
+			if r := compareDigits(na, nb); r != 0 {
+				return r
+			}
+			a, b = restA, restB
+			continue
+		}
+
+		ra, sizeA := utf8.DecodeRuneInString(a)
+		rb, sizeB := utf8.DecodeRuneInString(b)
+		if r := compareInts(c.weight(ra, withCase), c.weight(rb, withCase)); r != 0 {
+			return r
+		}
+		a, b = a[sizeA:], b[sizeB:]
+	}
+	return compareInts(len(a), len(b))
+}
+
+// weight zwraca wagę znaku. W języku polskim litery alfabetu mają wagi według jego kolejności,
+// po znakach spoza liter (spacje, interpunkcja, cyfry), a przed pozostałymi literami.
+func (c *collation) weight(r rune, withCase bool) int {
+	lower := unicode.ToLower(r)
+	var weight int
+	switch {
+	case c.Locale != collationPolish:
+		weight = int(lower)
+		if !c.CaseInsensitive && !withCase {
+			// Bez języka i bez CaseInsensitive obowiązuje zwykła kolejność znaków
+			weight = int(r)
+		}
+	case !unicode.IsLetter(lower):
+		weight = int(lower)
+	default:
+		if position, ok := polishWeights[lower]; ok {
+			weight = 0x10000 + position
+		} else {
+			weight = 0x20000 + int(lower)
+		}
+	}
+
+	if withCase {
+		weight *= 2
+		if r != lower {
+			weight++
+		}
+	}
+	return weight
+}
+
+// isASCIIDigit sprawdza, czy bajt jest cyfrą
+func isASCIIDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// digitRun zwraca ciąg cyfr z początku napisu bez zer wiodących oraz resztę napisu
+func digitRun(s string) (string, string) {
+	end := 0
+	for end < len(s) && isASCIIDigit(s[end]) {
+		end++
+	}
+	digits := strings.TrimLeft(s[:end], "0")
+	return digits, s[end:]
+}
+
+// compareDigits porównuje liczby zapisane jako ciągi cyfr bez zer wiodących
+func compareDigits(a, b string) int {
+	if r := compareInts(len(a), len(b)); r != 0 {
+		return r
+	}
+	return strings.Compare(a, b)
+}
+
+// compareInts porównuje dwie liczby całkowite
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"sort"
@@ -43,14 +46,44 @@ func handleCollectionOperation(w http.ResponseWriter, r *http.Request, dbName st
 		updateOneDocument(w, r, jsonFilePath, dbName, collName)
 	case "updateMany":
 		updateManyDocuments(w, r, jsonFilePath, dbName, collName)
+	case "get":
+		getDocuments(w, r, jsonFilePath, r.URL.Query().Get("id"))
 	case "findOne":
 		findOneDocument(w, r, jsonFilePath, dbName, collName)
 	case "findMany":
 		findManyDocuments(w, r, jsonFilePath, dbName, collName)
 	case "find":
 		find(w, r, jsonFilePath, dbName, collName)
+	case "count":
+		countMatching(w, r, jsonFilePath, dbName, collName)
+	case "distinct":
+		distinctValues(w, r, jsonFilePath, dbName, collName)
+	case "exists":
+		existsMatching(w, r, jsonFilePath, dbName, collName)
 	case "read":
 		readCollection(w, r, jsonFilePath)
+	case "export":
+		exportCollection(w, r, jsonFilePath)
+	case "import":
+		importCollection(w, r, jsonFilePath, dbName)
+	case "convertStorage":
+		convertStorage(w, r, jsonFilePath)
+	case "exportCsv":
+		exportCSV(w, r, jsonFilePath, collName)
+	case "importCsv":
+		importCSV(w, r, jsonFilePath, dbName)
+	case "watch":
+		watchChanges(w, r, dbName, collName)
+	case "getMore":
+		getMore(w, r, dbName, collName)
+	case "killCursors":
+		killCursors(w, r, dbName, collName)
+	case "addWebhook", "listWebhooks", "deleteWebhook", "webhookDeliveries", "deadLetters", "redeliver":
+		handleWebhookOperation(w, r, jsonFilePath, dbName, collName, command)
+	case "setEncryptedFields":
+		setEncryptedFields(w, r, jsonFilePath, dbName, collName)
+	case "setHistory", "revisions", "revision", "diffRevisions", "restoreRevision":
+		handleHistoryOperation(w, r, jsonFilePath, dbName, collName, command)
 	default:
 		http.Error(w, "Nieznana operacja w collections", http.StatusBadRequest)
 	}
@@ -58,12 +91,22 @@ func handleCollectionOperation(w http.ResponseWriter, r *http.Request, dbName st
 
 // createCollection tworzy nową kolekcję
 func createCollection(w http.ResponseWriter, _ *http.Request, dbPath, jsonFilePath, dbName, collName string) {
+	if !utils.IsValidName(dbName) || !utils.IsValidName(collName) {
+		http.Error(w, fmt.Sprintf("Nieprawidłowa nazwa kolekcji '%s/%s'", dbName, collName), http.StatusBadRequest)
+		return
+	}
+
 	// Upewnij się, że katalog bazy danych istnieje
 	if err := utils.EnsureDirectoryExists(dbPath); err != nil {
 		http.Error(w, fmt.Sprintf("Nie można utworzyć katalogu bazy danych: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	// Zablokuj kolekcję na czas odczytu i zapisu
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
 	// Sprawdź czy kolekcja już istnieje
 	if utils.FileExists(jsonFilePath) {
 		http.Error(w, "Kolekcja już istnieje", http.StatusConflict)
@@ -71,8 +114,7 @@ func createCollection(w http.ResponseWriter, _ *http.Request, dbPath, jsonFilePa
 	}
 
 	// Inicjalizuj pusty plik JSON jako tablicę
-	emptyArray := []interface{}{}
-	if err := utils.WriteJSONFile(jsonFilePath, emptyArray); err != nil {
+	if err := writeDocuments(jsonFilePath, []models.Document{}); err != nil {
 		http.Error(w, fmt.Sprintf("Nie można utworzyć pliku JSON: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -85,26 +127,35 @@ func createCollection(w http.ResponseWriter, _ *http.Request, dbPath, jsonFilePa
 }
 
 // deleteCollection usuwa kolekcję
-func deleteCollection(w http.ResponseWriter, _ *http.Request, jsonFilePath, dbName, collName string) {
-	// Usuwanie pliku kolekcji
+func deleteCollection(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string) {
+	// Zablokuj kolekcję na czas odczytu i zapisu
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
 	if !utils.FileExists(jsonFilePath) {
 		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
 		return
 	}
 
-	if err := os.Remove(jsonFilePath); err != nil {
+	// Przenieś kolekcję do kosza zamiast usuwać ją od razu
+	entry, err := moveCollectionToTrash(dbName, collName)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Nie można usunąć kolekcji: %v", err), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, "deleteCollection", dbName, collName, map[string]interface{}{"trash_id": entry.ID})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "success",
-		"message": fmt.Sprintf("Kolekcja '%s' została usunięta z bazy '%s'", collName, dbName),
+		"status":   "success",
+		"message":  fmt.Sprintf("Kolekcja '%s' z bazy '%s' została przeniesiona do kosza", collName, dbName),
+		"trash_id": entry.ID,
 	})
 }
 
-// renameCollection zmienia nazwę kolekcji
+// renameCollection zmienia nazwę kolekcji. Nazwa nie może należeć do istniejącej kolekcji,
+// a gdy nie uda się przenieść plików pomocniczych, plik kolekcji wraca pod starą nazwę.
 func renameCollection(w http.ResponseWriter, r *http.Request, _, jsonFilePath, dbName, collName string) {
 	// Zmiana nazwy pliku kolekcji
 	newName := r.URL.Query().Get("newName")
@@ -112,6 +163,10 @@ func renameCollection(w http.ResponseWriter, r *http.Request, _, jsonFilePath, d
 		http.Error(w, "Brak parametru 'newName'", http.StatusBadRequest)
 		return
 	}
+	if !utils.IsValidName(newName) {
+		http.Error(w, fmt.Sprintf("Nieprawidłowa nazwa kolekcji '%s'", newName), http.StatusBadRequest)
+		return
+	}
 
 	if !utils.FileExists(jsonFilePath) {
 		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
@@ -120,11 +175,29 @@ func renameCollection(w http.ResponseWriter, r *http.Request, _, jsonFilePath, d
 
 	newJsonFilePath := utils.GetCollectionPath(config.DataDir, dbName, newName)
 
+	// Zablokuj obie kolekcje w stałej kolejności, aby uniknąć zakleszczenia
+	unlock := lockCollections(jsonFilePath, newJsonFilePath)
+	defer unlock()
+
+	if utils.FileExists(newJsonFilePath) {
+		http.Error(w, fmt.Sprintf("Kolekcja '%s' już istnieje", newName), http.StatusConflict)
+		return
+	}
+
 	if err := os.Rename(jsonFilePath, newJsonFilePath); err != nil {
 		http.Error(w, fmt.Sprintf("Nie można zmienić nazwy kolekcji: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	if err := moveCollectionSideFiles(dbName, collName, newName); err != nil {
+		os.Rename(newJsonFilePath, jsonFilePath)
+		http.Error(w, fmt.Sprintf("Nie można przenieść ustawień kolekcji: %v", err), http.StatusInternalServerError)
+		return
+	}
+	moveIDIndex(jsonFilePath, newJsonFilePath)
+	publishChanges(changeEvent{Type: changeRename, Database: dbName, Collection: collName, NewName: newName})
+	recordAudit(r, "renameCollection", dbName, collName, map[string]interface{}{"new_name": newName})
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status":  "success",
@@ -133,7 +206,7 @@ func renameCollection(w http.ResponseWriter, r *http.Request, _, jsonFilePath, d
 }
 
 // insertOneDocument dodaje jeden dokument do kolekcji
-func insertOneDocument(w http.ResponseWriter, r *http.Request, dbPath, jsonFilePath, _, _ string) {
+func insertOneDocument(w http.ResponseWriter, r *http.Request, dbPath, jsonFilePath, dbName, collName string) {
 	// Sprawdź czy baza danych i kolekcja istnieją
 	if !utils.FileExists(dbPath) {
 		http.Error(w, "Baza danych nie istnieje", http.StatusNotFound)
@@ -151,17 +224,22 @@ func insertOneDocument(w http.ResponseWriter, r *http.Request, dbPath, jsonFileP
 	}
 
 	var newData models.Document
-	if err := json.NewDecoder(r.Body).Decode(&newData); err != nil {
-		http.Error(w, fmt.Sprintf("Nieprawidłowy format JSON: %v", err), http.StatusBadRequest)
+	if err := decodeJSONBody(w, r, &newData); err != nil {
+		writeBodyError(w, err, "Nieprawidłowy format JSON")
 		return
 	}
 
 	// Dodaj metadane (id, created_at, updated_at)
 	newData = models.AddMetadata(newData)
 
+	// Zablokuj kolekcję na czas odczytu i zapisu
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
 	// Odczytaj istniejące dane
-	var data []models.Document
-	if err := utils.ReadJSONFile(jsonFilePath, &data); err != nil {
+	data, err := readDocuments(jsonFilePath)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -171,25 +249,43 @@ func insertOneDocument(w http.ResponseWriter, r *http.Request, dbPath, jsonFileP
 		data = []models.Document{}
 	}
 
+	// Sprawdź limity bazy danych
+	budget, err := newQuotaBudget(dbName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać limitów bazy danych: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := budget.take(newData); err != nil {
+		writeQuotaError(w, err)
+		return
+	}
+
 	// Dodaj nowe dane
 	data = append(data, newData)
 
 	// Zapisz zaktualizowane dane
-	if err := utils.WriteJSONFile(jsonFilePath, data); err != nil {
+	if err := writeDocuments(jsonFilePath, data); err != nil {
 		http.Error(w, fmt.Sprintf("Nie można zapisać pliku JSON: %v", err), http.StatusInternalServerError)
 		return
 	}
+	publishChanges(insertEvent(dbName, collName, newData))
+
+	revealed, err := revealDocument(r, jsonFilePath, newData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  "success",
 		"message": "Dokument został dodany",
-		"data":    newData,
+		"data":    revealed,
 	})
 }
 
 // insertManyDocuments dodaje wiele dokumentów do kolekcji
-func insertManyDocuments(w http.ResponseWriter, r *http.Request, dbPath, jsonFilePath, _, _ string) {
+func insertManyDocuments(w http.ResponseWriter, r *http.Request, dbPath, jsonFilePath, dbName, collName string) {
 	// Sprawdź czy baza danych i kolekcja istnieją
 	if !utils.FileExists(dbPath) {
 		http.Error(w, "Baza danych nie istnieje", http.StatusNotFound)
@@ -208,8 +304,8 @@ func insertManyDocuments(w http.ResponseWriter, r *http.Request, dbPath, jsonFil
 
 	// Odczytaj tablicę dokumentów z żądania
 	var newDocuments []models.Document
-	if err := json.NewDecoder(r.Body).Decode(&newDocuments); err != nil {
-		http.Error(w, fmt.Sprintf("Nieprawidłowy format JSON, oczekiwano tablicy dokumentów: %v", err), http.StatusBadRequest)
+	if err := decodeJSONBody(w, r, &newDocuments); err != nil {
+		writeBodyError(w, err, "Nieprawidłowy format JSON, oczekiwano tablicy dokumentów")
 		return
 	}
 
@@ -218,9 +314,14 @@ func insertManyDocuments(w http.ResponseWriter, r *http.Request, dbPath, jsonFil
 		newDocuments[i] = models.AddMetadata(newDocuments[i])
 	}
 
+	// Zablokuj kolekcję na czas odczytu i zapisu
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
 	// Odczytaj istniejące dane
-	var data []models.Document
-	if err := utils.ReadJSONFile(jsonFilePath, &data); err != nil {
+	data, err := readDocuments(jsonFilePath)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -230,26 +331,49 @@ func insertManyDocuments(w http.ResponseWriter, r *http.Request, dbPath, jsonFil
 		data = []models.Document{}
 	}
 
+	// Sprawdź limity bazy danych
+	budget, err := newQuotaBudget(dbName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać limitów bazy danych: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := budget.take(newDocuments...); err != nil {
+		writeQuotaError(w, err)
+		return
+	}
+
 	// Dodaj nowe dokumenty
 	data = append(data, newDocuments...)
 
 	// Zapisz zaktualizowane dane
-	if err := utils.WriteJSONFile(jsonFilePath, data); err != nil {
+	if err := writeDocuments(jsonFilePath, data); err != nil {
 		http.Error(w, fmt.Sprintf("Nie można zapisać pliku JSON: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	events := make([]changeEvent, len(newDocuments))
+	for i, doc := range newDocuments {
+		events[i] = insertEvent(dbName, collName, doc)
+	}
+	publishChanges(events...)
+
+	revealed, err := revealDocuments(r, jsonFilePath, newDocuments)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":         "success",
 		"message":        fmt.Sprintf("Dodano %d dokumentów", len(newDocuments)),
 		"inserted_count": len(newDocuments),
-		"documents":      newDocuments,
+		"documents":      revealed,
 	})
 }
 
 // updateOneDocument aktualizuje jeden dokument w kolekcji
-func updateOneDocument(w http.ResponseWriter, r *http.Request, jsonFilePath, _, _ string) {
+func updateOneDocument(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string) {
 	if !utils.FileExists(jsonFilePath) {
 		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
 		return
@@ -269,14 +393,19 @@ func updateOneDocument(w http.ResponseWriter, r *http.Request, jsonFilePath, _,
 
 	// Odczytaj dane aktualizacji
 	var updateData models.Document
-	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
-		http.Error(w, fmt.Sprintf("Nieprawidłowy format JSON: %v", err), http.StatusBadRequest)
+	if err := decodeJSONBody(w, r, &updateData); err != nil {
+		writeBodyError(w, err, "Nieprawidłowy format JSON")
 		return
 	}
 
+	// Zablokuj kolekcję na czas odczytu i zapisu
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
 	// Odczytaj istniejące dane
-	var data []models.Document
-	if err := utils.ReadJSONFile(jsonFilePath, &data); err != nil {
+	data, err := readDocuments(jsonFilePath)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -286,21 +415,39 @@ func updateOneDocument(w http.ResponseWriter, r *http.Request, jsonFilePath, _,
 		return
 	}
 
+	budget, err := newQuotaBudget(dbName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać limitów bazy danych: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	// Znajdź dokument do aktualizacji
 	documentFound := false
+	var previous models.Document
 	for i, doc := range data {
 		if id, ok := doc["id"]; ok && id == documentID {
-			// Zachowaj oryginalne id i created_at
-			updateData["id"] = documentID
-			if createdAt, exists := doc["created_at"]; exists {
-				updateData["created_at"] = createdAt
+			// Sprawdź oczekiwaną wersję (If-Match lub expectedVersion)
+			if !checkIfMatch(w, r, doc) {
+				return
 			}
 
-			// Aktualizuj updated_at
-			updateData["updated_at"] = models.GetCurrentTimestamp()
+			// Sprawdź limit rozmiaru bazy danych dla nowej wersji dokumentu
+			updated := replaceDocument(doc, updateData)
+			if err := budget.replace(doc, updated); err != nil {
+				writeQuotaError(w, err)
+				return
+			}
+
+			// Zachowaj poprzednią wersję w historii
+			if err := recordRevisions(dbName, collName, false, doc); err != nil {
+				http.Error(w, fmt.Sprintf("Nie można zapisać historii: %v", err), http.StatusInternalServerError)
+				return
+			}
 
 			// Aktualizuj dokument
+			updateData = updated
 			data[i] = updateData
+			previous = doc
 			documentFound = true
 			break
 		}
@@ -312,21 +459,29 @@ func updateOneDocument(w http.ResponseWriter, r *http.Request, jsonFilePath, _,
 	}
 
 	// Zapisz zaktualizowane dane
-	if err := utils.WriteJSONFile(jsonFilePath, data); err != nil {
+	if err := writeDocuments(jsonFilePath, data); err != nil {
 		http.Error(w, fmt.Sprintf("Nie można zapisać pliku JSON: %v", err), http.StatusInternalServerError)
 		return
 	}
+	publishChanges(updateEvent(dbName, collName, previous, updateData))
 
+	revealed, err := revealDocument(r, jsonFilePath, updateData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", models.ETag(updateData))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  "success",
 		"message": "Dokument został zaktualizowany",
-		"data":    updateData,
+		"data":    revealed,
 	})
 }
 
 // updateManyDocuments aktualizuje wiele dokumentów w kolekcji
-func updateManyDocuments(w http.ResponseWriter, r *http.Request, jsonFilePath, _, _ string) {
+func updateManyDocuments(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string) {
 	if !utils.FileExists(jsonFilePath) {
 		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
 		return
@@ -343,8 +498,8 @@ func updateManyDocuments(w http.ResponseWriter, r *http.Request, jsonFilePath, _
 		Update models.Document        `json:"update"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, fmt.Sprintf("Nieprawidłowy format JSON: %v", err), http.StatusBadRequest)
+	if err := decodeJSONBody(w, r, &requestBody); err != nil {
+		writeBodyError(w, err, "Nieprawidłowy format JSON")
 		return
 	}
 
@@ -353,9 +508,22 @@ func updateManyDocuments(w http.ResponseWriter, r *http.Request, jsonFilePath, _
 		return
 	}
 
+	// Wartości porównywane z polami szyfrowanymi muszą zostać zaszyfrowane
+	if err := sealQueryFor(jsonFilePath, requestBody.Query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Zablokuj kolekcję na czas odczytu i zapisu
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	start := time.Now()
+
 	// Odczytaj istniejące dane
-	var data []models.Document
-	if err := utils.ReadJSONFile(jsonFilePath, &data); err != nil {
+	data, err := readDocuments(jsonFilePath)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -368,58 +536,172 @@ func updateManyDocuments(w http.ResponseWriter, r *http.Request, jsonFilePath, _
 	// Znajdź i zaktualizuj dokumenty
 	updatedCount := 0
 	updatedDocs := []models.Document{}
+	previousDocs := []models.Document{}
 
 	for i, doc := range data {
 		if matchesQuery(doc, requestBody.Query) {
 			// Wykonaj aktualizację dokumentu
-			updatedDoc := models.Document{}
-			for k, v := range doc {
-				updatedDoc[k] = v
-			}
-
-			// Aktualizuj pola
-			for k, v := range requestBody.Update {
-				updatedDoc[k] = v
-			}
-
-			// Zachowaj id i created_at
-			updatedDoc["id"] = doc["id"]
-			if createdAt, exists := doc["created_at"]; exists {
-				updatedDoc["created_at"] = createdAt
-			}
-
-			// Aktualizuj updated_at
-			updatedDoc["updated_at"] = models.GetCurrentTimestamp()
+			updatedDoc := mergeDocument(doc, requestBody.Update)
 
 			// Zaktualizuj dokument w kolekcji
 			data[i] = updatedDoc
 			updatedCount++
 			updatedDocs = append(updatedDocs, updatedDoc)
+			previousDocs = append(previousDocs, doc)
 		}
 	}
 
+	recordQuery(r, dbName, collName, "updateMany", requestBody.Query, queryOptions{}, queryStats{
+		Plan:     planCollectionScan,
+		Examined: len(data),
+		Matched:  updatedCount,
+		Returned: updatedCount,
+		Phases:   queryPhases{TotalMs: milliseconds(time.Since(start))},
+	})
+
 	if updatedCount == 0 {
 		http.Error(w, "Nie znaleziono dokumentów spełniających kryteria", http.StatusNotFound)
 		return
 	}
 
+	// Sprawdź limit rozmiaru bazy danych dla nowych wersji dokumentów
+	budget, err := newQuotaBudget(dbName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać limitów bazy danych: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for i := range updatedDocs {
+		if err := budget.replace(previousDocs[i], updatedDocs[i]); err != nil {
+			writeQuotaError(w, err)
+			return
+		}
+	}
+
+	// Zachowaj poprzednie wersje w historii
+	if err := recordRevisions(dbName, collName, false, previousDocs...); err != nil {
+		http.Error(w, fmt.Sprintf("Nie można zapisać historii: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	// Zapisz zaktualizowane dane
-	if err := utils.WriteJSONFile(jsonFilePath, data); err != nil {
+	if err := writeDocuments(jsonFilePath, data); err != nil {
 		http.Error(w, fmt.Sprintf("Nie można zapisać pliku JSON: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	events := make([]changeEvent, len(updatedDocs))
+	for i := range updatedDocs {
+		events[i] = updateEvent(dbName, collName, previousDocs[i], updatedDocs[i])
+	}
+	publishChanges(events...)
+
+	revealed, err := revealDocuments(r, jsonFilePath, updatedDocs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":        "success",
 		"message":       fmt.Sprintf("Zaktualizowano %d dokumentów", updatedCount),
 		"updated_count": updatedCount,
-		"documents":     updatedDocs,
+		"documents":     revealed,
+	})
+}
+
+// getDocuments pobiera dokument po id lub wiele dokumentów po liście id
+func getDocuments(w http.ResponseWriter, r *http.Request, jsonFilePath, docID string) {
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	// Lista id z parametru 'ids' (oddzielone przecinkami) lub z ciała {"ids": [...]}
+	var ids []string
+	if idsParam := r.URL.Query().Get("ids"); idsParam != "" {
+		for _, id := range strings.Split(idsParam, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	} else if r.Method == "POST" && docID == "" {
+		var requestBody struct {
+			IDs []string `json:"ids"`
+		}
+		if err := decodeJSONBody(w, r, &requestBody); err != nil {
+			writeBodyError(w, err, "Nieprawidłowy format JSON")
+			return
+		}
+		ids = requestBody.IDs
+	}
+
+	if docID == "" && len(ids) == 0 {
+		http.Error(w, "Brak parametru 'id' lub 'ids'", http.StatusBadRequest)
+		return
+	}
+
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	data, err := readDocuments(jsonFilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Pojedynczy dokument
+	if docID != "" {
+		position := lookupIDIndex(jsonFilePath, data, docID)
+		if position < 0 {
+			http.Error(w, fmt.Sprintf("Nie znaleziono dokumentu o id: %s", docID), http.StatusNotFound)
+			return
+		}
+
+		if !checkIfNoneMatch(w, r, data[position]) {
+			return
+		}
+
+		revealed, err := revealDocument(r, jsonFilePath, data[position])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revealed)
+		return
+	}
+
+	// Wiele dokumentów naraz
+	documents := []models.Document{}
+	missing := []string{}
+	for _, id := range ids {
+		if position := lookupIDIndex(jsonFilePath, data, id); position >= 0 {
+			documents = append(documents, data[position])
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	revealed, err := revealDocuments(r, jsonFilePath, documents)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "success",
+		"count":     len(documents),
+		"documents": revealed,
+		"missing":   missing,
 	})
 }
 
 // findOneDocument wyszukuje jeden dokument w kolekcji
-func findOneDocument(w http.ResponseWriter, r *http.Request, jsonFilePath, _, _ string) {
+func findOneDocument(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string) {
 	if !utils.FileExists(jsonFilePath) {
 		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
 		return
@@ -431,9 +713,20 @@ func findOneDocument(w http.ResponseWriter, r *http.Request, jsonFilePath, _, _
 	// Usuń 'command' z kryteriów wyszukiwania
 	query.Del("command")
 
+	if err := sealParamsFor(jsonFilePath, query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	start := time.Now()
+
 	// Odczytaj istniejące dane
-	var data []models.Document
-	if err := utils.ReadJSONFile(jsonFilePath, &data); err != nil {
+	data, err := readDocuments(jsonFilePath)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -447,39 +740,47 @@ func findOneDocument(w http.ResponseWriter, r *http.Request, jsonFilePath, _, _
 	foundDocument := false
 	var result models.Document
 
+	scanned := 0
 	for _, doc := range data {
-		match := true
-		for key, values := range query {
-			// Sprawdź czy wartość dokumentu pasuje do wartości z zapytania
-			if docValue, exists := doc[key]; exists {
-				if len(values) > 0 && fmt.Sprintf("%v", docValue) != values[0] {
-					match = false
-					break
-				}
-			} else {
-				match = false
-				break
-			}
-		}
-
-		if match {
+		scanned++
+		if matchesParams(doc, query) {
 			result = doc
 			foundDocument = true
 			break
 		}
 	}
 
+	returned := 0
+	if foundDocument {
+		returned = 1
+	}
+	recordQuery(r, dbName, collName, "findOne", query, queryOptions{}, queryStats{
+		Plan:     planCollectionScan,
+		Examined: scanned,
+		Matched:  returned,
+		Returned: returned,
+		Phases:   queryPhases{TotalMs: milliseconds(time.Since(start))},
+	})
+
 	if !foundDocument {
 		http.Error(w, "Nie znaleziono dokumentu spełniającego kryteria", http.StatusNotFound)
 		return
 	}
 
+	revealed, err := revealDocument(r, jsonFilePath, result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(revealed)
 }
 
-// findManyDocuments wyszukuje wiele dokumentów w kolekcji
-func findManyDocuments(w http.ResponseWriter, r *http.Request, jsonFilePath, _, _ string) {
+// findManyDocuments wyszukuje wiele dokumentów w kolekcji.
+// Z parametrem explain=true zwraca opis wykonania zapytania zamiast dokumentów,
+// z batchSize otwiera kursor, a z after stronicuje według klucza.
+func findManyDocuments(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string) {
 	if !utils.FileExists(jsonFilePath) {
 		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
 		return
@@ -489,94 +790,52 @@ func findManyDocuments(w http.ResponseWriter, r *http.Request, jsonFilePath, _,
 	query := r.URL.Query()
 
 	// Pobierz i usuń parametry sortowania i paginacji z zapytania
-	sortField := query.Get("sort")
-	sortOrder := query.Get("order") // "asc" lub "desc"
-	limit := query.Get("limit")
-	skip := query.Get("skip")
-	query.Del("command")
-	query.Del("sort")
-	query.Del("order")
-	query.Del("limit")
-	query.Del("skip")
-
-	// Odczytaj istniejące dane
-	var data []models.Document
-	if err := utils.ReadJSONFile(jsonFilePath, &data); err != nil {
-		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+	options, batchSize, err := parseQueryOptions(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	if data == nil {
-		data = []models.Document{}
+	explain := query.Get("explain") == "true"
+	for param := range queryParams {
+		query.Del(param)
 	}
 
-	// Wyszukaj dokumenty spełniające kryteria
-	var results []models.Document
-
-	for _, doc := range data {
-		match := true
-		for key, values := range query {
-			// Sprawdź czy wartość dokumentu pasuje do wartości z zapytania
-			if docValue, exists := doc[key]; exists {
-				if len(values) > 0 && fmt.Sprintf("%v", docValue) != values[0] {
-					match = false
-					break
-				}
-			} else {
-				match = false
-				break
-			}
-		}
-
-		if match {
-			results = append(results, doc)
-		}
-	}
-
-	// Sortowanie wyników
-	if sortField != "" {
-		sortResults(results, sortField, sortOrder)
-	}
-
-	// Paginacja wyników
-	skipCount := 0
-	if skip != "" {
-		skipCount, _ = strconv.Atoi(skip)
-		if skipCount > len(results) {
-			skipCount = len(results)
-		}
+	if err := sealParamsFor(jsonFilePath, query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	limitCount := len(results)
-	if limit != "" {
-		parsed, err := strconv.Atoi(limit)
-		if err == nil && parsed > 0 {
-			limitCount = parsed
-		}
-	}
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
 
-	// Zastosuj paginację
-	end := skipCount + limitCount
-	if end > len(results) {
-		end = len(results)
+	// Wyszukaj dokumenty spełniające kryteria, czytając kolekcję strumieniowo
+	results, stats, err := runQuery(jsonFilePath, func(doc models.Document) bool {
+		return matchesParams(doc, query)
+	}, options)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
 	}
+	recordQuery(r, dbName, collName, "findMany", query, options, stats)
 
-	if skipCount < len(results) {
-		results = results[skipCount:end]
-	} else {
-		results = []models.Document{}
+	if explain {
+		// Zapytanie zostało wykonane w całości, zwracany jest tylko opis wykonania
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"explain": stats,
+		})
+		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":    "success",
-		"count":     len(results),
-		"documents": results,
-	})
+	writeQueryResults(w, r, jsonFilePath, dbName, collName, results, options, batchSize)
 }
 
-// find wyszukuje wiele dokumentów w kolekcji z operatorami
-func find(w http.ResponseWriter, r *http.Request, jsonFilePath, _, _ string) {
+// find wyszukuje wiele dokumentów w kolekcji z operatorami.
+// Sortowanie można podać w parametrach URL lub w ciele zapytania kluczami $sort i $collation.
+// Z parametrem explain=true zwraca opis wykonania zapytania zamiast dokumentów,
+// z batchSize otwiera kursor, a z after stronicuje według klucza.
+func find(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string) {
 	if !utils.FileExists(jsonFilePath) {
 		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
 		return
@@ -584,65 +843,161 @@ func find(w http.ResponseWriter, r *http.Request, jsonFilePath, _, _ string) {
 
 	// Odczytaj zapytanie z ciała lub parametrów URL
 	var query map[string]interface{}
+	seal := sealQueryFor
 
 	if r.Method == "POST" {
-		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
-			http.Error(w, fmt.Sprintf("Nieprawidłowy format JSON: %v", err), http.StatusBadRequest)
+		if err := decodeJSONBody(w, r, &query); err != nil {
+			writeBodyError(w, err, "Nieprawidłowy format JSON")
 			return
 		}
 	} else {
 		// Twórz zapytanie na podstawie parametrów URL
 		query = make(map[string]interface{})
 		for k, v := range r.URL.Query() {
-			if k != "command" && k != "sort" && k != "order" && k != "limit" && k != "skip" {
+			if !queryParams[k] {
 				if len(v) == 1 {
 					query[k] = v[0]
 				}
 			}
 		}
+		seal = sealURLQueryFor
+	}
+
+	// Pobierz parametry sortowania i paginacji; $sort i $collation z ciała zastępują parametry URL
+	urlQuery := r.URL.Query()
+	options, batchSize, err := parseQueryOptions(urlQuery)
+	if err == nil {
+		err = takeBodyQueryOptions(query, &options)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	explain := urlQuery.Get("explain") == "true"
 
 	// Weryfikuj poprawność operatorów w zapytaniu
 	if !validateOperators(w, query) {
 		return // Error already written to response
 	}
 
-	// Pobierz parametry sortowania i paginacji
-	urlQuery := r.URL.Query()
-	sortField := urlQuery.Get("sort")
-	sortOrder := urlQuery.Get("order") // "asc" lub "desc"
-	limit := urlQuery.Get("limit")
-	skip := urlQuery.Get("skip")
+	// Wartości porównywane z polami szyfrowanymi muszą zostać zaszyfrowane
+	if err := seal(jsonFilePath, query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Odczytaj istniejące dane
-	var data []models.Document
-	if err := utils.ReadJSONFile(jsonFilePath, &data); err != nil {
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	// Wyszukaj dokumenty spełniające kryteria, czytając kolekcję strumieniowo
+	results, stats, err := runQuery(jsonFilePath, func(doc models.Document) bool {
+		return matchesQuery(doc, query)
+	}, options)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
 		return
 	}
+	recordQuery(r, dbName, collName, "find", query, options, stats)
 
-	if data == nil {
-		data = []models.Document{}
+	if explain {
+		// Zapytanie zostało wykonane w całości, zwracany jest tylko opis wykonania
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"explain": stats,
+		})
+		return
 	}
+	writeQueryResults(w, r, jsonFilePath, dbName, collName, results, options, batchSize)
+}
 
-	// Wyszukaj dokumenty spełniające kryteria
-	var results []models.Document
+// readCollection odczytuje wszystkie dokumenty z kolekcji
+func readCollection(w http.ResponseWriter, r *http.Request, jsonFilePath string) {
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
 
-	for _, doc := range data {
-		if matchesQuery(doc, query) {
-			results = append(results, doc)
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Plik JSON nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	manifest, err := readManifest(jsonFilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Kolekcję segmentowaną składamy z segmentów w jedną tablicę,
+	// a pola szyfrowane odszyfrowujemy dla uprawnionych wywołujących
+	revealer := newFieldRevealer(r, jsonFilePath)
+	if revealer != nil {
+		// Odpowiedź jest budowana w pamięci, aby błąd odszyfrowania zgłosić statusem 500
+		var buf bytes.Buffer
+		if err := writeDocumentArray(&buf, jsonFilePath, revealer); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		buf.WriteTo(w)
+		return
+	}
+	if manifest != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeDocumentArray(w, jsonFilePath, nil)
+		return
 	}
 
-	// Sortowanie wyników
-	if sortField != "" {
-		sortResults(results, sortField, sortOrder)
+	file, err := openDataFile(jsonFilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można otworzyć pliku JSON: %v", err), http.StatusInternalServerError)
+		return
 	}
+	defer file.Close()
 
-	// Paginacja wyników
+	w.Header().Set("Content-Type", "application/json")
+	io.Copy(w, file)
+}
+
+// matchesParams sprawdza czy dokument pasuje do prostych kryteriów z parametrów URL
+func matchesParams(doc models.Document, query url.Values) bool {
+	for key, values := range query {
+		// Sprawdź czy wartość dokumentu pasuje do wartości z zapytania
+		docValue, exists := doc[key]
+		if !exists {
+			return false
+		}
+		if len(values) > 0 && !matchesParamValue(docValue, values) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesParamValue porównuje wartość dokumentu z wartością parametru URL. Wartość porównywana
+// z polem szyfrowanym ma po sealParams kilka szyfrogramów i wystarczy zgodność z jednym z nich.
+func matchesParamValue(docValue interface{}, values []string) bool {
+	text := fmt.Sprintf("%v", docValue)
+	if !isEncryptedValue(values[0]) {
+		return text == values[0]
+	}
+	for _, value := range values {
+		if text == value {
+			return true
+		}
+	}
+	return false
+}
+
+// paginateResults stosuje parametry skip i limit do listy wyników
+func paginateResults(results []models.Document, skip, limit string) []models.Document {
 	skipCount := 0
 	if skip != "" {
 		skipCount, _ = strconv.Atoi(skip)
+		if skipCount < 0 {
+			skipCount = 0
+		}
 		if skipCount > len(results) {
 			skipCount = len(results)
 		}
@@ -663,35 +1018,42 @@ func find(w http.ResponseWriter, r *http.Request, jsonFilePath, _, _ string) {
 	}
 
 	if skipCount < len(results) {
-		results = results[skipCount:end]
-	} else {
-		results = []models.Document{}
+		return results[skipCount:end]
 	}
+	return []models.Document{}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":    "success",
-		"count":     len(results),
-		"documents": results,
-	})
+// replaceDocument zastępuje treść dokumentu, zachowując jego id i created_at oraz podbijając wersję
+func replaceDocument(oldDoc, newDoc models.Document) models.Document {
+	if newDoc == nil {
+		newDoc = models.Document{}
+	}
+
+	// Zachowaj oryginalne id i created_at
+	newDoc["id"] = oldDoc["id"]
+	if createdAt, exists := oldDoc["created_at"]; exists {
+		newDoc["created_at"] = createdAt
+	}
+
+	// Aktualizuj updated_at i wersję
+	newDoc["updated_at"] = models.GetCurrentTimestamp()
+
+	return models.BumpVersion(newDoc, oldDoc)
 }
 
-// readCollection odczytuje wszystkie dokumenty z kolekcji
-func readCollection(w http.ResponseWriter, _ *http.Request, jsonFilePath string) {
-	if !utils.FileExists(jsonFilePath) {
-		http.Error(w, "Plik JSON nie istnieje", http.StatusNotFound)
-		return
+// mergeDocument nakłada podane pola na kopię dokumentu
+func mergeDocument(oldDoc, update models.Document) models.Document {
+	updatedDoc := models.Document{}
+	for k, v := range oldDoc {
+		updatedDoc[k] = v
 	}
 
-	file, err := os.Open(jsonFilePath)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Nie można otworzyć pliku JSON: %v", err), http.StatusInternalServerError)
-		return
+	// Aktualizuj pola
+	for k, v := range update {
+		updatedDoc[k] = v
 	}
-	defer file.Close()
 
-	w.Header().Set("Content-Type", "application/json")
-	io.Copy(w, file)
+	return replaceDocument(oldDoc, updatedDoc)
 }
 
 ////////////////////////////////
@@ -732,12 +1094,12 @@ func matchesOperators(doc models.Document, field string, operators map[string]in
 		switch operator {
 		case "$eq":
 			// Równość
-			if fmt.Sprintf("%v", docValue) == fmt.Sprintf("%v", value) {
+			if fmt.Sprintf("%v", docValue) != fmt.Sprintf("%v", value) {
 				return false
 			}
 		case "$ne":
 			// Nierówność
-			if fmt.Sprintf("%v", docValue) != fmt.Sprintf("%v", value) {
+			if fmt.Sprintf("%v", docValue) == fmt.Sprintf("%v", value) {
 				return false
 			}
 		case "$gt":
@@ -780,74 +1142,125 @@ func matchesOperators(doc models.Document, field string, operators map[string]in
 			if !matchesRegex(docValue, value) {
 				return false
 			}
+		case "$elemMatch":
+			// Element tablicy spełniający zapytanie
+			if !matchesElement(docValue, value) {
+				return false
+			}
+		case "$all":
+			// Tablica zawierająca wszystkie wartości
+			if !containsAll(docValue, value) {
+				return false
+			}
+		case "$size":
+			// Tablica o podanej długości
+			items, isArray := docValue.([]interface{})
+			size, _ := value.(float64)
+			if !isArray || float64(len(items)) != size {
+				return false
+			}
+		case "$type":
+			// Wartość podanego typu
+			if !matchesType(docValue, field, value) {
+				return false
+			}
+		case "$mod":
+			// Reszta z dzielenia
+			if !matchesMod(docValue, value) {
+				return false
+			}
 		}
 	}
 	return true
 }
 
+// queryOperators to operatory dozwolone w zapytaniach
+var queryOperators = map[string]bool{
+	"$eq":        true,
+	"$ne":        true,
+	"$gt":        true,
+	"$gte":       true,
+	"$lt":        true,
+	"$lte":       true,
+	"$in":        true,
+	"$nin":       true,
+	"$exists":    true,
+	"$regex":     true,
+	"$elemMatch": true,
+	"$all":       true,
+	"$size":      true,
+	"$type":      true,
+	"$mod":       true,
+}
+
+// valueTypes to nazwy typów akceptowane przez operator $type
+var valueTypes = map[string]bool{
+	"string": true,
+	"number": true,
+	"bool":   true,
+	"object": true,
+	"array":  true,
+	"null":   true,
+	"date":   true,
+}
+
 // validateOperators checks for valid operators in the query and writes errors to HTTP response
 func validateOperators(w http.ResponseWriter, query map[string]interface{}) bool {
-	// Lista dozwolonych operatorów
-	allowedOperators := map[string]bool{
-		"$eq":     true,
-		"$ne":     true,
-		"$gt":     true,
-		"$gte":    true,
-		"$lt":     true,
-		"$lte":    true,
-		"$in":     true,
-		"$nin":    true,
-		"$exists": true,
-		"$regex":  true,
-	}
-
 	// Sprawdź każde pole i jego operatory
 	for field, condition := range query {
 		// Jeśli wartość jest mapą, sprawdź operatory
 		if condMap, ok := condition.(map[string]interface{}); ok {
-			for op := range condMap {
-				// Sprawdź czy operator rozpoczyna się od $
-				if strings.HasPrefix(op, "$") {
-					if !allowedOperators[op] {
-						http.Error(w, fmt.Sprintf("Nieznany operator '%s' dla pola '%s'", op, field), http.StatusBadRequest)
-						return false
-					}
-
-					// Sprawdź poprawność wartości dla danego operatora
-					if !validateOperatorValue(w, op, condMap[op], field) {
-						return false
-					}
-				}
+			if !validateCondition(w, field, condMap) {
+				return false
 			}
+		}
+	}
 
-			// Sprawdź czy nie ma konfliktowych operatorów
-			if condMap["$gt"] != nil && condMap["$lt"] != nil {
-				gtVal, gtOk := toFloat64(condMap["$gt"])
-				ltVal, ltOk := toFloat64(condMap["$lt"])
-				if gtOk && ltOk && gtVal >= ltVal {
-					http.Error(w, fmt.Sprintf("Konflikt operatorów dla pola '%s': $gt:%v musi być mniejsze niż $lt:%v", field, condMap["$gt"], condMap["$lt"]), http.StatusBadRequest)
-					return false
-				}
+	return true
+}
+
+// validateCondition sprawdza operatory warunku dla jednego pola
+func validateCondition(w http.ResponseWriter, field string, condMap map[string]interface{}) bool {
+	for op := range condMap {
+		// Sprawdź czy operator rozpoczyna się od $
+		if strings.HasPrefix(op, "$") {
+			if !queryOperators[op] {
+				http.Error(w, fmt.Sprintf("Nieznany operator '%s' dla pola '%s'", op, field), http.StatusBadRequest)
+				return false
 			}
 
-			if condMap["$gte"] != nil && condMap["$lte"] != nil {
-				gteVal, gteOk := toFloat64(condMap["$gte"])
-				lteVal, lteOk := toFloat64(condMap["$lte"])
-				if gteOk && lteOk && gteVal > lteVal {
-					http.Error(w, fmt.Sprintf("Konflikt operatorów dla pola '%s': $gte:%v musi być mniejsze lub równe $lte:%v", field, condMap["$gte"], condMap["$lte"]), http.StatusBadRequest)
-					return false
-				}
+			// Sprawdź poprawność wartości dla danego operatora
+			if !validateOperatorValue(w, op, condMap[op], field) {
+				return false
 			}
 		}
 	}
 
+	// Sprawdź czy nie ma konfliktowych operatorów
+	if condMap["$gt"] != nil && condMap["$lt"] != nil {
+		gtVal, gtOk := toFloat64(condMap["$gt"])
+		ltVal, ltOk := toFloat64(condMap["$lt"])
+		if gtOk && ltOk && gtVal >= ltVal {
+			http.Error(w, fmt.Sprintf("Konflikt operatorów dla pola '%s': $gt:%v musi być mniejsze niż $lt:%v", field, condMap["$gt"], condMap["$lt"]), http.StatusBadRequest)
+			return false
+		}
+	}
+
+	if condMap["$gte"] != nil && condMap["$lte"] != nil {
+		gteVal, gteOk := toFloat64(condMap["$gte"])
+		lteVal, lteOk := toFloat64(condMap["$lte"])
+		if gteOk && lteOk && gteVal > lteVal {
+			http.Error(w, fmt.Sprintf("Konflikt operatorów dla pola '%s': $gte:%v musi być mniejsze lub równe $lte:%v", field, condMap["$gte"], condMap["$lte"]), http.StatusBadRequest)
+			return false
+		}
+	}
 	return true
 }
 
 // validateOperatorValue sprawdza poprawność wartości dla danego operatora
 func validateOperatorValue(w http.ResponseWriter, operator string, value interface{}, field string) bool {
 	switch operator {
-	case "$in", "$nin":
+	case "$in", "$nin", "$all":
 		// Sprawdź czy wartość jest tablicą
 		_, ok := value.([]interface{})
 		if !ok {
@@ -880,6 +1293,64 @@ func validateOperatorValue(w http.ResponseWriter, operator string, value interfa
 			http.Error(w, fmt.Sprintf("Operator %s nie może przyjmować wartości złożonych (obiekty, tablice) dla pola '%s'", operator, field), http.StatusBadRequest)
 			return false
 		}
+	case "$size":
+		// Sprawdź czy wartość jest nieujemną liczbą całkowitą
+		size, ok := value.(float64)
+		if !ok || size < 0 || size != math.Trunc(size) {
+			http.Error(w, fmt.Sprintf("Operator %s wymaga nieujemnej liczby całkowitej dla pola '%s'", operator, field), http.StatusBadRequest)
+			return false
+		}
+	case "$type":
+		// Sprawdź czy wartość jest nazwą typu lub tablicą nazw typów
+		names, isArray := value.([]interface{})
+		if !isArray {
+			names = []interface{}{value}
+		}
+		if len(names) == 0 {
+			http.Error(w, fmt.Sprintf("Operator %s wymaga co najmniej jednej nazwy typu dla pola '%s'", operator, field), http.StatusBadRequest)
+			return false
+		}
+		for _, name := range names {
+			if typeName, ok := name.(string); !ok || !valueTypes[typeName] {
+				http.Error(w, fmt.Sprintf("Nieznany typ %v operatora %s dla pola '%s' (dozwolone: string, number, bool, object, array, null, date)", name, operator, field), http.StatusBadRequest)
+				return false
+			}
+		}
+	case "$mod":
+		// Sprawdź czy wartość jest parą [dzielnik, reszta] z dzielnikiem różnym od zera
+		args, ok := value.([]interface{})
+		if ok && len(args) == 2 {
+			divisor, divisorOk := args[0].(float64)
+			_, remainderOk := args[1].(float64)
+			ok = divisorOk && remainderOk && math.Trunc(divisor) != 0
+		}
+		if !ok || len(args) != 2 {
+			http.Error(w, fmt.Sprintf("Operator %s wymaga tablicy [dzielnik, reszta] z dzielnikiem różnym od zera dla pola '%s'", operator, field), http.StatusBadRequest)
+			return false
+		}
+	case "$elemMatch":
+		// Sprawdź czy wartość jest zapytaniem na elementach tablicy
+		subQuery, ok := value.(map[string]interface{})
+		if !ok || len(subQuery) == 0 {
+			http.Error(w, fmt.Sprintf("Operator %s wymaga niepustego obiektu zapytania dla pola '%s'", operator, field), http.StatusBadRequest)
+			return false
+		}
+		operators := 0
+		for key := range subQuery {
+			if strings.HasPrefix(key, "$") {
+				operators++
+			}
+		}
+		switch operators {
+		case len(subQuery):
+			// Operatory stosowane do samych elementów, np. {"$gte": 80, "$lt": 90}
+			return validateCondition(w, field, subQuery)
+		case 0:
+			// Zapytanie na polach elementów będących obiektami
+			return validateOperators(w, subQuery)
+		}
+		http.Error(w, fmt.Sprintf("Operator %s nie może łączyć operatorów z nazwami pól dla pola '%s'", operator, field), http.StatusBadRequest)
+		return false
 	}
 	return true
 }
@@ -919,108 +1390,186 @@ func toFloat64(value interface{}) (float64, bool) {
 	return 0, false
 }
 
-// inArray sprawdza czy wartość jest w tablicy
+// inArray sprawdza czy wartość jest w tablicy. Jeśli wartość dokumentu jest tablicą,
+// wystarczy, że w tablicy zapytania jest jeden z jej elementów.
 func inArray(docValue, queryValue interface{}) bool {
 	queryArray, ok := queryValue.([]interface{})
 	if !ok {
 		return false
 	}
 
-	for _, item := range queryArray {
-		if fmt.Sprintf("%v", docValue) == fmt.Sprintf("%v", item) {
-			return true
+	if valueIn(docValue, queryArray) {
+		return true
+	}
+	if items, isArray := docValue.([]interface{}); isArray {
+		for _, item := range items {
+			if valueIn(item, queryArray) {
+				return true
+			}
 		}
 	}
 	return false
 }
 
-// matchesRegex sprawdza czy wartość pasuje do wyrażenia regularnego
-func matchesRegex(docValue, queryValue interface{}) bool {
-	docStr := fmt.Sprintf("%v", docValue)
-	regexStr, ok := queryValue.(string)
-	if !ok {
-		return false
+// valueIn sprawdza czy wartość jest równa jednemu z elementów listy
+func valueIn(value interface{}, list []interface{}) bool {
+	for _, item := range list {
+		if fmt.Sprintf("%v", value) == fmt.Sprintf("%v", item) {
+			return true
+		}
 	}
+	return false
+}
 
-	regex, err := regexp.Compile(regexStr)
-	if err != nil {
+// containsAll sprawdza czy tablica dokumentu zawiera wszystkie wartości z tablicy zapytania.
+// Wartość niebędąca tablicą jest traktowana jak tablica jednoelementowa.
+func containsAll(docValue, queryValue interface{}) bool {
+	required, ok := queryValue.([]interface{})
+	if !ok || len(required) == 0 {
 		return false
 	}
 
-	return regex.MatchString(docStr)
+	items, isArray := docValue.([]interface{})
+	if !isArray {
+		items = []interface{}{docValue}
+	}
+	for _, value := range required {
+		if !valueIn(value, items) {
+			return false
+		}
+	}
+	return true
 }
 
-// sortResults sortuje dokumenty według podanego pola
-func sortResults(docs []models.Document, field, order string) {
-	sort.Slice(docs, func(i, j int) bool {
-		// Pobierz wartości pola
-		var vi, vj interface{}
-		var existi, existj bool
+// matchesElement sprawdza czy choć jeden element tablicy spełnia zapytanie $elemMatch.
+// Zapytanie złożone z samych operatorów jest stosowane do elementów, a zapytanie
+// z nazwami pól - do elementów będących obiektami.
+func matchesElement(docValue, queryValue interface{}) bool {
+	items, isArray := docValue.([]interface{})
+	subQuery, ok := queryValue.(map[string]interface{})
+	if !isArray || !ok {
+		return false
+	}
 
-		if vi, existi = docs[i][field]; !existi {
-			return false
+	operatorsOnly := true
+	for key := range subQuery {
+		if !strings.HasPrefix(key, "$") {
+			operatorsOnly = false
+			break
 		}
+	}
 
-		if vj, existj = docs[j][field]; !existj {
+	for _, item := range items {
+		if operatorsOnly {
+			if matchesOperators(models.Document{"": item}, "", subQuery) {
+				return true
+			}
+		} else if object, isObject := item.(map[string]interface{}); isObject && matchesQuery(models.Document(object), subQuery) {
 			return true
 		}
+	}
+	return false
+}
 
-		// Sprawdź, czy pole może być typu czasowego (created_at, updated_at)
-		if isTimeField(field) || isTimeValue(vi) || isTimeValue(vj) {
-			// Spróbuj skonwertować do czasu
-			ti, oki := parseTime(vi)
-			tj, okj := parseTime(vj)
+// valueType zwraca nazwę typu wartości JSON używaną przez operator $type
+func valueType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64, float32, int, int64:
+		return "number"
+	case bool:
+		return "bool"
+	case map[string]interface{}, models.Document:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return ""
+}
 
-			if oki && okj {
-				if order == "desc" {
-					return ti.After(tj)
-				}
-				return ti.Before(tj)
-			}
-		}
+// matchesType sprawdza czy wartość ma jeden z typów podanych w operatorze $type.
+// Datą jest napis w formacie daty lub napis czasu w polu czasowym (created_at itp.),
+// a każda data jest też napisem.
+func matchesType(docValue interface{}, field string, queryValue interface{}) bool {
+	names, isArray := queryValue.([]interface{})
+	if !isArray {
+		names = []interface{}{queryValue}
+	}
 
-		// Porównaj wartości w zależności od typu
-		switch vi.(type) {
-		case string:
-			// Porównanie stringów
-			si, oki := vi.(string)
-			sj, okj := vj.(string)
-			if oki && okj {
-				if order == "desc" {
-					return si > sj
-				}
-				return si < sj
-			}
-		case float64:
-			// Porównanie liczb
-			fi, oki := vi.(float64)
-			fj, okj := vj.(float64)
-			if oki && okj {
-				if order == "desc" {
-					return fi > fj
-				}
-				return fi < fj
-			}
-		case bool:
-			// Porównanie wartości logicznych
-			bi, oki := vi.(bool)
-			bj, okj := vj.(bool)
-			if oki && okj {
-				if order == "desc" {
-					return !bi && bj
+	for _, name := range names {
+		if name == "date" {
+			if s, ok := docValue.(string); ok && (isTimeValue(s) || isTimeField(field)) {
+				if _, ok := parseTime(s); ok {
+					return true
 				}
-				return bi && !bj
 			}
+			continue
 		}
+		if name == valueType(docValue) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMod sprawdza czy liczba z dokumentu daje podaną resztę z dzielenia ($mod: [dzielnik, reszta]).
+// Liczby są obcinane do części całkowitej.
+func matchesMod(docValue, queryValue interface{}) bool {
+	args, ok := queryValue.([]interface{})
+	if !ok || len(args) != 2 || valueType(docValue) != "number" {
+		return false
+	}
+	number, _ := toFloat64(docValue)
+	divisor, divisorOk := toFloat64(args[0])
+	remainder, remainderOk := toFloat64(args[1])
+	if !divisorOk || !remainderOk || int64(divisor) == 0 {
+		return false
+	}
+	return int64(number)%int64(divisor) == int64(remainder)
+}
+
+// matchesRegex sprawdza czy wartość pasuje do wyrażenia regularnego
+func matchesRegex(docValue, queryValue interface{}) bool {
+	docStr := fmt.Sprintf("%v", docValue)
+	regexStr, ok := queryValue.(string)
+	if !ok {
+		return false
+	}
 
-		// Domyślne porównanie stringów
-		si := fmt.Sprintf("%v", vi)
-		sj := fmt.Sprintf("%v", vj)
-		if order == "desc" {
-			return si > sj
+	regex, err := regexp.Compile(regexStr)
+	if err != nil {
+		return false
+	}
+
+	return regex.MatchString(docStr)
+}
+
+// sortResults sortuje dokumenty według specyfikacji sortowania. Dokumenty o równych
+// wartościach pól są porządkowane według id, więc kolejność jest zawsze ta sama.
+func sortResults(docs []models.Document, spec sortSpec, c *collation) {
+	type sortEntry struct {
+		doc    models.Document
+		values []sortValue
+		id     string
+	}
+
+	// Wartości pól są ustalane raz dla każdego dokumentu, a nie przy każdym porównaniu
+	entries := make([]sortEntry, len(docs))
+	for i, doc := range docs {
+		entries[i] = sortEntry{doc: doc, values: documentSortValues(doc, spec), id: documentID(doc)}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if r := compareSortKeys(entries[i].values, entries[j].values, spec, c); r != 0 {
+			return r < 0
 		}
-		return si < sj
+		return entries[i].id < entries[j].id
 	})
+	for i := range entries {
+		docs[i] = entries[i].doc
+	}
 }
 
 // isTimeField sprawdza czy nazwa pola wskazuje na pole czasowe
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+var (
+	collectionLocksMu sync.Mutex
+	collectionLocks   = map[string]*instrumentedMutex{}
+)
+
+// collectionLock zwraca blokadę chroniącą plik kolekcji przed równoległymi zapisami
+func collectionLock(jsonFilePath string) *instrumentedMutex {
+	collectionLocksMu.Lock()
+	defer collectionLocksMu.Unlock()
+
+	lock, ok := collectionLocks[jsonFilePath]
+	if !ok {
+		lock = &instrumentedMutex{}
+		collectionLocks[jsonFilePath] = lock
+	}
+	return lock
+}
+
+// lockCollections blokuje kilka kolekcji do zapisu w stałej kolejności i zwraca funkcję zwalniającą
+func lockCollections(paths ...string) func() {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+
+	var locks []*instrumentedMutex
+	seen := map[string]bool{}
+	for _, path := range sorted {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		lock := collectionLock(path)
+		lock.Lock()
+		locks = append(locks, lock)
+	}
+
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
+
+// databaseCollectionPaths zwraca posortowane ścieżki plików wszystkich kolekcji bazy danych
+func databaseCollectionPaths(dbName string) ([]string, error) {
+	collections, err := utils.ListJSONFiles(utils.GetDatabasePath(config.DataDir, dbName))
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(collections))
+	for _, collName := range collections {
+		paths = append(paths, utils.GetCollectionPath(config.DataDir, dbName, collName))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// lockDatabaseForWrite blokuje do zapisu wszystkie kolekcje bazy danych i zwraca funkcję zwalniającą
+func lockDatabaseForWrite(dbName string) (func(), error) {
+	paths, err := databaseCollectionPaths(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return lockCollections(paths...), nil
+}
+
+// lockDatabase blokuje do odczytu wszystkie kolekcje bazy danych i zwraca funkcję zwalniającą
+func lockDatabase(dbName string) (func(), error) {
+	paths, err := databaseCollectionPaths(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	locks := make([]*instrumentedMutex, 0, len(paths))
+	for _, path := range paths {
+		lock := collectionLock(path)
+		lock.RLock()
+		locks = append(locks, lock)
+	}
+
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].RUnlock()
+		}
+	}, nil
+}
+
+// expectedVersion odczytuje oczekiwaną wersję dokumentu z nagłówka If-Match
+// lub parametru 'expectedVersion'. Zwraca false, jeśli warunek nie został podany.
+func expectedVersion(r *http.Request) ([]string, bool) {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		var tags []string
+		for _, tag := range strings.Split(ifMatch, ",") {
+			tags = append(tags, strings.TrimPrefix(strings.TrimSpace(tag), "W/"))
+		}
+		return tags, true
+	}
+
+	if version := r.URL.Query().Get("expectedVersion"); version != "" {
+		return []string{strconv.Quote(version)}, true
+	}
+
+	return nil, false
+}
+
+// checkIfMatch sprawdza warunek wersji przed modyfikacją dokumentu.
+// W razie niezgodności zapisuje odpowiedź 412 i zwraca false.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, doc models.Document) bool {
+	tags, ok := expectedVersion(r)
+	if !ok {
+		return true
+	}
+
+	current := models.ETag(doc)
+	for _, tag := range tags {
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+
+	w.Header().Set("ETag", current)
+	http.Error(w, fmt.Sprintf("Wersja dokumentu się nie zgadza, aktualna wersja: %d", models.GetVersion(doc)), http.StatusPreconditionFailed)
+	return false
+}
+
+// checkIfNoneMatch ustawia nagłówek ETag i obsługuje If-None-Match.
+// Jeśli klient ma aktualną wersję, zapisuje odpowiedź 304 i zwraca false.
+func checkIfNoneMatch(w http.ResponseWriter, r *http.Request, doc models.Document) bool {
+	current := models.ETag(doc)
+	w.Header().Set("ETag", current)
+
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" {
+		return true
+	}
+
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			w.WriteHeader(http.StatusNotModified)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// countParams to parametry URL poleceń count, distinct i exists, które nie są kryteriami wyszukiwania
+var countParams = map[string]bool{
+	"command": true,
+	"explain": true,
+	"field":   true,
+}
+
+// errStopScan przerywa przeglądanie kolekcji, gdy wynik jest już znany
+var errStopScan = errors.New("przerwano przeglądanie kolekcji")
+
+// readFilterQuery odczytuje zapytanie z operatorami z ciała żądania POST lub z parametrów URL,
+// sprawdza operatory i szyfruje wartości porównywane z polami szyfrowanymi.
+// Zwraca false, jeśli odpowiedź z błędem została już wysłana.
+func readFilterQuery(w http.ResponseWriter, r *http.Request, jsonFilePath string) (map[string]interface{}, bool) {
+	query := map[string]interface{}{}
+	seal := sealQueryFor
+	if r.Method == "POST" {
+		if err := decodeJSONBody(w, r, &query); err != nil {
+			writeBodyError(w, err, "Nieprawidłowy format JSON")
+			return nil, false
+		}
+		if query == nil {
+			query = map[string]interface{}{}
+		}
+	} else {
+		for k, v := range r.URL.Query() {
+			if !countParams[k] && len(v) == 1 {
+				query[k] = v[0]
+			}
+		}
+		seal = sealURLQueryFor
+	}
+
+	if !validateOperators(w, query) {
+		return nil, false
+	}
+	if err := seal(jsonFilePath, query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return query, true
+}
+
+// indexedIDs zwraca id dokumentów, do których ogranicza się zapytanie, jeśli można je
+// odczytać z indeksu id: pole id porównywane z napisem lub z listą napisów w $in
+func indexedIDs(query map[string]interface{}) ([]string, bool) {
+	switch condition := query["id"].(type) {
+	case string:
+		return []string{condition}, true
+	case map[string]interface{}:
+		list, ok := condition["$in"].([]interface{})
+		if !ok || len(condition) != 1 {
+			return nil, false
+		}
+		ids := make([]string, 0, len(list))
+		seen := make(map[string]bool, len(list))
+		for _, item := range list {
+			id, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		return ids, true
+	}
+	return nil, false
+}
+
+// scanMatches przekazuje do fn dokumenty spełniające zapytanie. Zapytania po id korzystają
+// z indeksu id, pozostałe przeglądają kolekcję strumieniowo. Funkcja fn może zwrócić
+// errStopScan, aby zakończyć przeglądanie. Wywołujący musi trzymać blokadę kolekcji do odczytu.
+func scanMatches(jsonFilePath string, query map[string]interface{}, fn func(models.Document) error) (queryStats, error) {
+	stats := queryStats{Plan: planCollectionScan}
+	start := time.Now()
+
+	visit := func(doc models.Document) error {
+		stats.Examined++
+		if !matchesQuery(doc, query) {
+			return nil
+		}
+		stats.Matched++
+		return fn(doc)
+	}
+
+	var err error
+	if ids, ok := indexedIDs(query); ok {
+		stats.Plan, stats.Index = planIDIndex, "id"
+		var data []models.Document
+		if data, err = readDocuments(jsonFilePath); err == nil {
+			for _, id := range ids {
+				if position := lookupIDIndex(jsonFilePath, data, id); position >= 0 {
+					if err = visit(data[position]); err != nil {
+						break
+					}
+				}
+			}
+		}
+	} else {
+		err = streamDocuments(jsonFilePath, visit)
+	}
+	if errors.Is(err, errStopScan) {
+		err = nil
+	}
+
+	stats.Phases.TotalMs = milliseconds(time.Since(start))
+	return stats, err
+}
+
+// writeCountResult wysyła wynik polecenia count, distinct lub exists, z opisem wykonania
+// zapytania przy parametrze explain=true
+func writeCountResult(w http.ResponseWriter, r *http.Request, response map[string]interface{}, stats queryStats) {
+	response["status"] = "success"
+	if r.URL.Query().Get("explain") == "true" {
+		response["explain"] = stats
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// countMatching zwraca liczbę dokumentów spełniających zapytanie bez zwracania samych dokumentów.
+// Bez kryteriów liczba dokumentów kolekcji segmentowanej pochodzi z manifestu.
+func countMatching(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string) {
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	query, ok := readFilterQuery(w, r, jsonFilePath)
+	if !ok {
+		return
+	}
+
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	var stats queryStats
+	var err error
+	count := 0
+	if manifest, _ := readManifest(jsonFilePath); manifest != nil && len(query) == 0 {
+		start := time.Now()
+		stats.Plan = planCountMetadata
+		count, err = countDocuments(jsonFilePath)
+		stats.Matched = count
+		stats.Phases.TotalMs = milliseconds(time.Since(start))
+	} else {
+		stats, err = scanMatches(jsonFilePath, query, func(models.Document) error {
+			count++
+			return nil
+		})
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordQuery(r, dbName, collName, "count", query, queryOptions{}, stats)
+
+	writeCountResult(w, r, map[string]interface{}{"count": count}, stats)
+}
+
+// existsMatching sprawdza, czy choć jeden dokument spełnia zapytanie.
+// Przeglądanie kolekcji kończy się na pierwszym pasującym dokumencie.
+func existsMatching(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string) {
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	query, ok := readFilterQuery(w, r, jsonFilePath)
+	if !ok {
+		return
+	}
+
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	stats, err := scanMatches(jsonFilePath, query, func(models.Document) error {
+		return errStopScan
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordQuery(r, dbName, collName, "exists", query, queryOptions{}, stats)
+
+	writeCountResult(w, r, map[string]interface{}{"exists": stats.Matched > 0}, stats)
+}
+
+// distinctValue to unikalna wartość pola wraz z liczbą wystąpień
+type distinctValue struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+// distinctValues zwraca unikalne wartości pola (parametr field, również ścieżka z kropkami)
+// w dokumentach spełniających opcjonalne zapytanie, z liczbą wystąpień każdej wartości.
+// Elementy tablic są liczone jako osobne wartości, a dokumenty bez pola są pomijane.
+// Wartości są posortowane tak jak przy sortowaniu wyników zapytań.
+func distinctValues(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string) {
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	field := r.URL.Query().Get("field")
+	if field == "" {
+		http.Error(w, "Brak parametru 'field'", http.StatusBadRequest)
+		return
+	}
+
+	query, ok := readFilterQuery(w, r, jsonFilePath)
+	if !ok {
+		return
+	}
+
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	// Wartości są grupowane według postaci JSON, w której klucze obiektów są posortowane
+	groups := map[string]*distinctValue{}
+	add := func(value interface{}) {
+		raw, _ := json.Marshal(value)
+		if group, ok := groups[string(raw)]; ok {
+			group.Count++
+			return
+		}
+		groups[string(raw)] = &distinctValue{Value: value, Count: 1}
+	}
+
+	stats, err := scanMatches(jsonFilePath, query, func(doc models.Document) error {
+		value, exists := lookupPath(doc, field)
+		if !exists {
+			return nil
+		}
+		if items, isArray := value.([]interface{}); isArray && len(items) > 0 {
+			for _, item := range items {
+				add(item)
+			}
+			return nil
+		}
+		add(value)
+		return nil
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Wartości równe przy sortowaniu (np. ta sama data w różnych formatach) są porządkowane według JSON
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := groups[keys[i]].Value, groups[keys[j]].Value
+		if c := compareSortValues(newSortValue(a, field), newSortValue(b, field), nil); c != 0 {
+			return c < 0
+		}
+		return keys[i] < keys[j]
+	})
+
+	revealer := newFieldRevealer(r, jsonFilePath)
+	values := make([]distinctValue, len(keys))
+	for i, key := range keys {
+		values[i] = *groups[key]
+		if revealer != nil {
+			revealed, err := revealer.revealValue(field, values[i].Value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Nie można odszyfrować wartości pola: %v", err), http.StatusInternalServerError)
+				return
+			}
+			values[i].Value = revealed
+		}
+	}
+
+	stats.Returned = len(values)
+	recordQuery(r, dbName, collName, "distinct", query, queryOptions{}, stats)
+
+	writeCountResult(w, r, map[string]interface{}{
+		"field":  field,
+		"count":  len(values),
+		"values": values,
+	}, stats)
+}
@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// exportCSV wysyła dokumenty kolekcji jako CSV.
+// Parametry:
+//
+//	fields=a,b.c        lista kolumn (domyślnie wszystkie pola), pola zagnieżdżone w notacji z kropką
+//	delimiter=;         separator kolumn (domyślnie przecinek, "tab" oznacza tabulator)
+func exportCSV(w http.ResponseWriter, r *http.Request, jsonFilePath, collName string) {
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	delimiter, err := parseDelimiter(r.URL.Query().Get("delimiter"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	revealer := newFieldRevealer(r, jsonFilePath)
+
+	// Bez listy pól zbierz wszystkie spłaszczone klucze w pierwszym przebiegu
+	var fields []string
+	if fieldsParam := r.URL.Query().Get("fields"); fieldsParam != "" {
+		for _, field := range strings.Split(fieldsParam, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, field)
+			}
+		}
+	} else {
+		seen := map[string]bool{}
+		err := streamDocuments(jsonFilePath, func(doc models.Document) error {
+			doc, err := revealer.reveal(doc)
+			if err != nil {
+				return err
+			}
+			for key := range flattenDocument(doc) {
+				if !seen[key] {
+					seen[key] = true
+					fields = append(fields, key)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+			return
+		}
+		sort.Strings(fields)
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", collName+".csv"))
+
+	writer := csv.NewWriter(w)
+	writer.Comma = delimiter
+	writer.Write(fields)
+
+	record := make([]string, len(fields))
+	err = streamDocuments(jsonFilePath, func(doc models.Document) error {
+		doc, err := revealer.reveal(doc)
+		if err != nil {
+			return err
+		}
+		flat := flattenDocument(doc)
+		for i, field := range fields {
+			value, ok := flat[field]
+			if !ok {
+				// Pole może wskazywać cały obiekt zagnieżdżony
+				value, ok = lookupPath(doc, field)
+			}
+			record[i] = ""
+			if ok {
+				record[i] = csvValue(value)
+			}
+		}
+		return writer.Write(record)
+	})
+	writer.Flush()
+
+	// Nagłówki zostały już wysłane, więc błąd można zgłosić tylko w treści
+	if err != nil {
+		fmt.Fprintf(w, "\nPrzerwano eksport: %v\n", err)
+	}
+}
+
+// importCSV dodaje do kolekcji dokumenty z pliku CSV. Pierwszy wiersz zawiera nazwy pól,
+// nazwy z kropką tworzą obiekty zagnieżdżone.
+// Parametry:
+//
+//	delimiter=;                     separator kolumn (domyślnie przecinek, "tab" oznacza tabulator)
+//	types={"zip":"string"}          typy kolumn (string, number, bool, date, auto), także w postaci zip:string,age:number
+//	ids=keep|regenerate             zachowaj kolumnę id (domyślnie) lub nadaj nowe
+//	onError=skip|abort              pomiń błędne wiersze (domyślnie) lub przerwij import
+func importCSV(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName string) {
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje. Użyj 'createCollection' aby ją utworzyć", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Wymagana metoda POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	delimiter, err := parseDelimiter(query.Get("delimiter"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	columnTypes, err := parseColumnTypes(query.Get("types"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	regenerateIDs := query.Get("ids") == "regenerate"
+	abortOnError := query.Get("onError") == "abort"
+
+	body := &recordLimitReader{r: r.Body}
+	body.reset()
+	reader := csv.NewReader(body)
+	reader.Comma = delimiter
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == nil && reader.InputOffset() > int64(config.MaxDocumentBytes) {
+		err = errRecordTooLong
+	}
+	if errors.Is(err, errRecordTooLong) {
+		writeBodyError(w, importRecordTooLong(1), "")
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać nagłówka CSV: %v", err), http.StatusBadRequest)
+		return
+	}
+	header = append([]string(nil), header...)
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	// Dokumenty trafiają najpierw do pliku tymczasowego, a kolekcja jest blokowana
+	// dopiero na czas scalania
+	stage, err := newImportStage(jsonFilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można przygotować importu: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer stage.remove()
+
+	summary := importSummary{Errors: []importError{}}
+	for {
+		start := reader.InputOffset()
+		body.reset()
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		summary.Processed++
+
+		// Rekord dłuższy niż config.MaxDocumentBytes przerywa import niezależnie od onError
+		if errors.Is(err, errRecordTooLong) || (err == nil && reader.InputOffset()-start > int64(config.MaxDocumentBytes)) {
+			line := summary.Processed + 1
+			if err == nil {
+				line, _ = reader.FieldPos(0)
+			}
+			reportImportFailure(w, nil, &summary, http.StatusRequestEntityTooLarge, importRecordTooLong(line))
+			return
+		}
+
+		// Błędna liczba kolumn nie przerywa czytania kolejnych wierszy
+		var line int
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			line = parseErr.StartLine
+		} else if err == nil {
+			line, _ = reader.FieldPos(0)
+		}
+		if err != nil && (parseErr == nil || parseErr.Err != csv.ErrFieldCount) {
+			summary.addError(line, err)
+			reportImportFailure(w, nil, &summary, http.StatusBadRequest, fmt.Errorf("Nieprawidłowy plik CSV: %v", err))
+			return
+		}
+
+		if err == nil {
+			var doc models.Document
+			doc, err = csvRecordToDocument(header, record, columnTypes)
+			if err == nil {
+				err = stage.add(line, doc)
+			}
+		}
+
+		if err != nil {
+			summary.addError(line, err)
+			if abortOnError {
+				reportImportFailure(w, nil, &summary, http.StatusBadRequest, fmt.Errorf("Import przerwany w wierszu %d: %v", line, err))
+				return
+			}
+		}
+	}
+
+	if status, err := mergeImport(jsonFilePath, dbName, stage, &summary, regenerateIDs, abortOnError); err != nil {
+		reportImportFailure(w, nil, &summary, status, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Zaimportowano %d dokumentów", summary.Inserted),
+		"summary": summary,
+	})
+}
+
+// errRecordTooLong przerywa odczyt rekordu CSV dłuższego niż config.MaxDocumentBytes
+var errRecordTooLong = errors.New("rekord przekracza maksymalny rozmiar dokumentu")
+
+// csvReadAhead to rozmiar bufora, którym csv.Reader czyta dane z wyprzedzeniem
+const csvReadAhead = 4096
+
+// recordLimitReader ogranicza liczbę bajtów odczytanych od ostatniego wywołania reset,
+// aby pojedynczy rekord CSV, także z wielowierszowym polem w cudzysłowie, nie mógł zająć
+// dowolnie dużo pamięci. Limit uwzględnia bufor odczytu z wyprzedzeniem, więc dokładny
+// rozmiar rekordu sprawdza się dodatkowo przez csv.Reader.InputOffset.
+type recordLimitReader struct {
+	r         io.Reader
+	remaining int
+}
+
+// Read czyta dane, dopóki nie wyczerpie limitu bieżącego rekordu
+func (l *recordLimitReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, errRecordTooLong
+	}
+	if len(p) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= n
+	return n, err
+}
+
+// reset przywraca limit przed odczytem kolejnego rekordu
+func (l *recordLimitReader) reset() {
+	l.remaining = config.MaxDocumentBytes + csvReadAhead
+}
+
+// csvRecordToDocument zamienia wiersz CSV na dokument, konwertując wartości na typy
+func csvRecordToDocument(header, record []string, columnTypes map[string]string) (models.Document, error) {
+	doc := models.Document{}
+	for i, column := range header {
+		if column == "" || i >= len(record) || record[i] == "" {
+			continue
+		}
+
+		value, err := convertCSVValue(record[i], columnTypes[column])
+		if err != nil {
+			return nil, fmt.Errorf("kolumna '%s': %v", column, err)
+		}
+		if err := setPath(doc, column, value); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// convertCSVValue konwertuje tekst z CSV na wartość podanego typu lub typ wykryty automatycznie
+func convertCSVValue(raw, valueType string) (interface{}, error) {
+	switch valueType {
+	case "string":
+		return raw, nil
+	case "number":
+		return parseCSVNumber(strings.TrimSpace(raw))
+	case "bool":
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("'%s' nie jest wartością logiczną", raw)
+		}
+		return b, nil
+	case "date":
+		// Data pozostaje w postaci z pliku, z ułamkami sekund i oryginalną strefą czasową
+		value := strings.TrimSpace(raw)
+		if _, err := time.Parse(time.RFC3339Nano, value); err != nil {
+			return nil, fmt.Errorf("'%s' nie jest datą RFC3339", raw)
+		}
+		return value, nil
+	}
+
+	return inferCSVValue(raw), nil
+}
+
+// inferCSVValue wykrywa liczby, wartości logiczne i daty RFC3339.
+// Liczby z zerami wiodącymi (np. kody pocztowe, PESEL) oraz liczby całkowite,
+// których nie da się zapisać dokładnie, pozostają tekstem.
+func inferCSVValue(raw string) interface{} {
+	value := strings.TrimSpace(raw)
+
+	switch strings.ToLower(value) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if isPlainNumber(value) {
+		if f, err := parseCSVNumber(value); err == nil {
+			return f
+		}
+	}
+
+	if _, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return value
+	}
+
+	return raw
+}
+
+// maxExactInteger to największa liczba całkowita, którą float64 zapisuje dokładnie (2^53)
+const maxExactInteger = 1 << 53
+
+// parseCSVNumber odczytuje liczbę z CSV. Liczby całkowite spoza zakresu ±2^53 są odrzucane,
+// bo po zapisaniu jako liczba JSON zostałyby zaokrąglone.
+func parseCSVNumber(value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' nie jest liczbą", value)
+	}
+	if !strings.ContainsAny(value, ".eE") {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n > maxExactInteger || n < -maxExactInteger {
+			return 0, fmt.Errorf("liczba całkowita '%s' jest zbyt duża, aby zapisać ją dokładnie", value)
+		}
+	}
+	return f, nil
+}
+
+// isPlainNumber sprawdza czy tekst jest liczbą dziesiętną bez zer wiodących
+func isPlainNumber(value string) bool {
+	digits := strings.TrimPrefix(value, "-")
+	if digits == "" {
+		return false
+	}
+	if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+		return false
+	}
+
+	seenDot := false
+	for i, c := range digits {
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && !seenDot && i > 0 && i < len(digits)-1:
+			seenDot = true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseColumnTypes odczytuje mapę typów kolumn z JSON lub listy pole:typ
+func parseColumnTypes(param string) (map[string]string, error) {
+	types := map[string]string{}
+	if param == "" {
+		return types, nil
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(param), "{") {
+		if err := json.Unmarshal([]byte(param), &types); err != nil {
+			return nil, fmt.Errorf("Nieprawidłowy parametr 'types': %v", err)
+		}
+	} else {
+		for _, pair := range strings.Split(param, ",") {
+			field, valueType, ok := strings.Cut(pair, ":")
+			if !ok {
+				return nil, fmt.Errorf("Nieprawidłowy parametr 'types': oczekiwano pole:typ, otrzymano '%s'", pair)
+			}
+			types[strings.TrimSpace(field)] = strings.TrimSpace(valueType)
+		}
+	}
+
+	for field, valueType := range types {
+		switch valueType {
+		case "string", "number", "bool", "date", "auto":
+		default:
+			return nil, fmt.Errorf("Nieznany typ '%s' dla kolumny '%s'", valueType, field)
+		}
+	}
+	return types, nil
+}
+
+// parseDelimiter odczytuje separator kolumn CSV
+func parseDelimiter(param string) (rune, error) {
+	switch param {
+	case "":
+		return ',', nil
+	case "tab", "\\t":
+		return '\t', nil
+	}
+
+	delimiter, size := utf8.DecodeRuneInString(param)
+	if size != len(param) || delimiter == '"' || delimiter == '\r' || delimiter == '\n' || delimiter == utf8.RuneError {
+		return 0, fmt.Errorf("Nieprawidłowy separator: '%s'", param)
+	}
+	return delimiter, nil
+}
+
+// csvValue zamienia wartość dokumentu na tekst komórki CSV
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// flattenDocument spłaszcza obiekty zagnieżdżone do kluczy w notacji z kropką
+func flattenDocument(doc models.Document) map[string]interface{} {
+	flat := map[string]interface{}{}
+	flattenInto(flat, "", doc)
+	return flat
+}
+
+// flattenInto dopisuje spłaszczone pola obiektu do mapy
+func flattenInto(flat map[string]interface{}, prefix string, value map[string]interface{}) {
+	for key, v := range value {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenInto(flat, path, nested)
+			continue
+		}
+		flat[path] = v
+	}
+}
+
+// lookupPath zwraca wartość pola wskazanego ścieżką z kropkami (np. "adres.miasto")
+func lookupPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := doc[path]; ok {
+		return value, true
+	}
+
+	var current interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			if d, isDoc := current.(models.Document); isDoc {
+				object = d
+			} else {
+				return nil, false
+			}
+		}
+		if current, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setPath ustawia wartość pola wskazanego ścieżką z kropkami, tworząc obiekty pośrednie
+func setPath(doc map[string]interface{}, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+	current := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, exists := current[part]
+		if !exists {
+			nested := map[string]interface{}{}
+			current[part] = nested
+			current = nested
+			continue
+		}
+		nested, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("pole '%s' koliduje z polem '%s'", path, part)
+		}
+		current = nested
+	}
+	current[parts[len(parts)-1]] = value
+	return nil
+}
@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"BaseDB/config"
+	"BaseDB/models"
+)
+
+// queryParams to parametry URL zapytań find i findMany, które nie są kryteriami wyszukiwania
+var queryParams = map[string]bool{
+	"command":   true,
+	"sort":      true,
+	"order":     true,
+	"collation": true,
+	"limit":     true,
+	"skip":      true,
+	"explain":   true,
+	"after":     true,
+	"batchSize": true,
+}
+
+// parseQueryOptions odczytuje parametry sortowania i paginacji zapytania.
+// Parametr sort zawiera listę pól z kierunkami (sort=age:desc,name:asc) lub tablicę JSON,
+// a collation - ustawienia porównywania napisów w JSON.
+// Parametr after włącza stronicowanie według klucza (postać opisuje parseKeysetAfter),
+// a pusty after zaczyna od pierwszej strony. Parametr batchSize otwiera kursor.
+func parseQueryOptions(values url.Values) (queryOptions, int, error) {
+	options := queryOptions{
+		Skip:   values.Get("skip"),
+		Limit:  values.Get("limit"),
+		Keyset: values.Has("after"),
+		After:  values.Get("after"),
+	}
+
+	var err error
+	if options.Sort, err = parseSortSpec(values.Get("sort"), values.Get("order")); err != nil {
+		return options, 0, err
+	}
+	if options.Collation, err = parseCollation(values.Get("collation")); err != nil {
+		return options, 0, err
+	}
+	if options.Keyset {
+		if _, err := parseKeysetAfter(options.After, options.Sort); err != nil {
+			return options, 0, err
+		}
+	}
+
+	batchSize := 0
+	if value := values.Get("batchSize"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return options, 0, fmt.Errorf("Parametr 'batchSize' musi być dodatnią liczbą całkowitą")
+		}
+		batchSize = parsed
+	}
+	return options, batchSize, nil
+}
+
+// keysetAfter to pozycja, po której zaczyna się strona przy stronicowaniu według klucza
+type keysetAfter struct {
+	values []interface{}
+	id     string
+}
+
+// sortsByIDOnly sprawdza, czy wyniki są sortowane tylko po id
+func sortsByIDOnly(spec sortSpec) bool {
+	return len(spec) == 0 || (len(spec) == 1 && spec[0].Field == "id")
+}
+
+// parseKeysetAfter odczytuje parametr after. Bez pól sortowania (lub przy sortowaniu tylko po id)
+// parametr zawiera samo id. Przy jednym polu sortowania zawiera wartość pola w JSON i id
+// po ostatnim przecinku, a przy kilku polach - tablicę JSON wartości pól i id.
+// Pusta wartość oznacza dokument bez pola sortowania.
+func parseKeysetAfter(after string, spec sortSpec) (*keysetAfter, error) {
+	if after == "" {
+		return nil, nil
+	}
+	if sortsByIDOnly(spec) {
+		return &keysetAfter{values: []interface{}{after}, id: after}, nil
+	}
+
+	separator := strings.LastIndex(after, ",")
+	if separator < 0 {
+		if len(spec) == 1 {
+			return nil, fmt.Errorf("Parametr 'after' musi mieć postać <wartość pola '%s'>,<id>", spec[0].Field)
+		}
+		return nil, fmt.Errorf("Parametr 'after' musi mieć postać <tablica JSON wartości pól %s>,<id>", spec)
+	}
+	key := &keysetAfter{id: after[separator+1:]}
+	raw := after[:separator]
+
+	if len(spec) == 1 {
+		var value interface{}
+		if raw != "" {
+			if err := json.Unmarshal([]byte(raw), &value); err != nil {
+				// Wartość niebędąca JSON jest traktowana jak napis
+				value = raw
+			}
+		}
+		key.values = []interface{}{value}
+		return key, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &key.values); err != nil || len(key.values) != len(spec) {
+		return nil, fmt.Errorf("Parametr 'after' musi zawierać tablicę JSON z %d wartościami pól %s", len(spec), spec)
+	}
+	return key, nil
+}
+
+// applyKeyset zwraca posortowane dokumenty występujące po pozycji after
+func applyKeyset(docs []models.Document, options queryOptions) []models.Document {
+	spec := options.effectiveSort()
+	key, _ := parseKeysetAfter(options.After, spec)
+	if key == nil {
+		return docs
+	}
+
+	afterValues := make([]sortValue, len(spec))
+	for i, sortKey := range spec {
+		afterValues[i] = newSortValue(key.values[i], sortKey.Field)
+	}
+	start := sort.Search(len(docs), func(i int) bool {
+		if c := compareSortKeys(documentSortValues(docs[i], spec), afterValues, spec, options.Collation); c != 0 {
+			return c > 0
+		}
+		return documentID(docs[i]) > key.id
+	})
+	return docs[start:]
+}
+
+// nextKeysetAfter zwraca wartość parametru after dla strony następującej po dokumencie
+func nextKeysetAfter(doc models.Document, spec sortSpec) string {
+	if sortsByIDOnly(spec) {
+		return documentID(doc)
+	}
+
+	values := make([]interface{}, len(spec))
+	for i, key := range spec {
+		values[i], _ = lookupPath(doc, key.Field)
+	}
+	if len(spec) == 1 {
+		if values[0] == nil {
+			return "," + documentID(doc)
+		}
+		raw, _ := json.Marshal(values[0])
+		return string(raw) + "," + documentID(doc)
+	}
+	raw, _ := json.Marshal(values)
+	return string(raw) + "," + documentID(doc)
+}
+
+// queryCursor przechowuje wyniki zapytania pobierane partiami poleceniem getMore.
+// Wyniki są migawką z chwili wykonania zapytania, więc późniejsze zmiany kolekcji ich nie zmieniają.
+type queryCursor struct {
+	id           string
+	owner        string
+	database     string
+	collection   string
+	jsonFilePath string
+	documents    []models.Document
+	position     int
+	batchSize    int
+	lastUsed     time.Time
+}
+
+var (
+	cursorsMu sync.Mutex
+	cursors   = map[string]*queryCursor{}
+)
+
+// cursorOwner zwraca id klucza API wywołującego; kursor może być używany tylko przez ten sam klucz
+func cursorOwner(r *http.Request) string {
+	if key, err := authenticate(r); err == nil && key != nil {
+		return key.ID
+	}
+	return ""
+}
+
+// expireCursors zamyka kursory bezczynne dłużej niż config.CursorTimeoutSeconds.
+// Wywołujący musi trzymać cursorsMu.
+func expireCursors(now time.Time) {
+	timeout := time.Duration(config.CursorTimeoutSeconds) * time.Second
+	for id, cursor := range cursors {
+		if now.Sub(cursor.lastUsed) > timeout {
+			delete(cursors, id)
+		}
+	}
+}
+
+// openCursor zapamiętuje pozostałe wyniki zapytania i zwraca id kursora
+func openCursor(r *http.Request, jsonFilePath, dbName, collName string, documents []models.Document, batchSize int) string {
+	now := time.Now()
+	cursor := &queryCursor{
+		id:           uuid.New().String(),
+		owner:        cursorOwner(r),
+		database:     dbName,
+		collection:   collName,
+		jsonFilePath: jsonFilePath,
+		documents:    documents,
+		batchSize:    batchSize,
+		lastUsed:     now,
+	}
+
+	cursorsMu.Lock()
+	defer cursorsMu.Unlock()
+
+	expireCursors(now)
+	if config.MaxCursors > 0 && len(cursors) >= config.MaxCursors {
+		// Zamknij najdawniej używany kursor
+		var oldest *queryCursor
+		for _, c := range cursors {
+			if oldest == nil || c.lastUsed.Before(oldest.lastUsed) {
+				oldest = c
+			}
+		}
+		delete(cursors, oldest.id)
+	}
+	cursors[cursor.id] = cursor
+	return cursor.id
+}
+
+// writeQueryResults wysyła wyniki zapytania find lub findMany. Z batchSize wysyłana jest
+// pierwsza partia, a pozostałe wyniki trafiają do kursora. Przy stronicowaniu według klucza
+// odpowiedź zawiera next_after, jeśli strona jest pełna.
+func writeQueryResults(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string, results []models.Document, options queryOptions, batchSize int) {
+	response := map[string]interface{}{"status": "success"}
+
+	if options.Keyset && len(results) > 0 {
+		if limit, err := strconv.Atoi(options.Limit); err == nil && len(results) == limit {
+			response["next_after"] = nextKeysetAfter(results[len(results)-1], options.effectiveSort())
+		}
+	}
+
+	if batchSize > 0 && len(results) > batchSize {
+		rest := results[batchSize:]
+		results = results[:batchSize]
+		response["cursor_id"] = openCursor(r, jsonFilePath, dbName, collName, rest, batchSize)
+		response["has_more"] = true
+	} else if batchSize > 0 {
+		response["has_more"] = false
+	}
+
+	revealed, err := revealDocuments(r, jsonFilePath, results)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response["count"] = len(results)
+	response["documents"] = revealed
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// getMore zwraca kolejną partię wyników kursora (parametry cursorId i opcjonalnie batchSize).
+// Kursor jest zamykany po zwróceniu ostatniej partii.
+func getMore(w http.ResponseWriter, r *http.Request, dbName, collName string) {
+	query := r.URL.Query()
+	cursorID := query.Get("cursorId")
+	if cursorID == "" {
+		http.Error(w, "Brak parametru 'cursorId'", http.StatusBadRequest)
+		return
+	}
+
+	batchSize := 0
+	if value := query.Get("batchSize"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Parametr 'batchSize' musi być dodatnią liczbą całkowitą", http.StatusBadRequest)
+			return
+		}
+		batchSize = parsed
+	}
+
+	cursorsMu.Lock()
+	expireCursors(time.Now())
+	cursor, ok := cursors[cursorID]
+	if !ok || cursor.database != dbName || cursor.collection != collName || cursor.owner != cursorOwner(r) {
+		cursorsMu.Unlock()
+		http.Error(w, "Kursor nie istnieje lub wygasł", http.StatusNotFound)
+		return
+	}
+
+	if batchSize == 0 {
+		batchSize = cursor.batchSize
+	}
+	end := cursor.position + batchSize
+	if end > len(cursor.documents) {
+		end = len(cursor.documents)
+	}
+	batch := cursor.documents[cursor.position:end]
+	cursor.position = end
+	cursor.lastUsed = time.Now()
+
+	hasMore := cursor.position < len(cursor.documents)
+	if !hasMore {
+		delete(cursors, cursorID)
+	}
+	cursorsMu.Unlock()
+
+	revealed, err := revealDocuments(r, cursor.jsonFilePath, batch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":    "success",
+		"has_more":  hasMore,
+		"count":     len(batch),
+		"documents": revealed,
+	}
+	if hasMore {
+		response["cursor_id"] = cursorID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// killCursors zamyka kursory podane w parametrze cursorIds (oddzielone przecinkami)
+func killCursors(w http.ResponseWriter, r *http.Request, dbName, collName string) {
+	value := r.URL.Query().Get("cursorIds")
+	if value == "" {
+		http.Error(w, "Brak parametru 'cursorIds'", http.StatusBadRequest)
+		return
+	}
+
+	killed := []string{}
+	notFound := []string{}
+	owner := cursorOwner(r)
+
+	cursorsMu.Lock()
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if cursor, ok := cursors[id]; ok && cursor.database == dbName && cursor.collection == collName && cursor.owner == owner {
+			delete(cursors, id)
+			killed = append(killed, id)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+	cursorsMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "success",
+		"killed":    killed,
+		"not_found": notFound,
+	})
+}
@@ -17,6 +17,10 @@ func handleDatabaseOperation(w http.ResponseWriter, r *http.Request, dbName stri
 	switch command {
 	case "create":
 		// Tworzenie katalogu bazy danych
+		if !utils.IsValidName(dbName) {
+			http.Error(w, fmt.Sprintf("Nieprawidłowa nazwa bazy danych '%s'", dbName), http.StatusBadRequest)
+			return
+		}
 		if err := utils.EnsureDirectoryExists(dbPath); err != nil {
 			http.Error(w, fmt.Sprintf("Nie można utworzyć bazy danych: %v", err), http.StatusInternalServerError)
 			return
@@ -28,21 +32,25 @@ func handleDatabaseOperation(w http.ResponseWriter, r *http.Request, dbName stri
 		})
 
 	case "delete":
-		// Usuwanie katalogu bazy danych
+		// Usuwanie bazy danych
 		if !utils.FileExists(dbPath) {
 			http.Error(w, "Baza danych nie istnieje", http.StatusNotFound)
 			return
 		}
 
-		if err := os.RemoveAll(dbPath); err != nil {
+		// Przenieś bazę danych do kosza zamiast usuwać ją od razu
+		entry, err := moveDatabaseToTrash(dbName)
+		if err != nil {
 			http.Error(w, fmt.Sprintf("Nie można usunąć bazy danych: %v", err), http.StatusInternalServerError)
 			return
 		}
+		recordAudit(r, "deleteDatabase", dbName, "", map[string]interface{}{"trash_id": entry.ID})
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{
-			"status":  "success",
-			"message": fmt.Sprintf("Baza danych '%s' została usunięta", dbName),
+			"status":   "success",
+			"message":  fmt.Sprintf("Baza danych '%s' została przeniesiona do kosza", dbName),
+			"trash_id": entry.ID,
 		})
 
 	case "rename":
@@ -52,6 +60,10 @@ func handleDatabaseOperation(w http.ResponseWriter, r *http.Request, dbName stri
 			http.Error(w, "Brak parametru 'newName'", http.StatusBadRequest)
 			return
 		}
+		if !utils.IsValidName(newName) {
+			http.Error(w, fmt.Sprintf("Nieprawidłowa nazwa bazy danych '%s'", newName), http.StatusBadRequest)
+			return
+		}
 
 		newPath := utils.GetDatabasePath(config.DataDir, newName)
 
@@ -64,6 +76,9 @@ func handleDatabaseOperation(w http.ResponseWriter, r *http.Request, dbName stri
 			http.Error(w, fmt.Sprintf("Nie można zmienić nazwy bazy danych: %v", err), http.StatusInternalServerError)
 			return
 		}
+		dropIDIndexesWithPrefix(dbPath)
+		publishChanges(changeEvent{Type: changeRename, Database: dbName, NewName: newName})
+		recordAudit(r, "renameDatabase", dbName, "", map[string]interface{}{"new_name": newName})
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{
@@ -90,6 +105,51 @@ func handleDatabaseOperation(w http.ResponseWriter, r *http.Request, dbName stri
 			"database":    dbName,
 			"collections": collections,
 		})
+	case "backup":
+		// Spójna kopia zapasowa bazy danych jako archiwum tar.gz
+		if !requireAdmin(w, r) {
+			return
+		}
+		if !utils.FileExists(dbPath) {
+			http.Error(w, "Baza danych nie istnieje", http.StatusNotFound)
+			return
+		}
+		backupDatabases(w, r, []string{dbName})
+
+	case "restoreBackup":
+		// Odtworzenie bazy danych (nowej lub istniejącej) z archiwum w ciele żądania
+		if !requireAdmin(w, r) {
+			return
+		}
+		restoreBackup(w, r, dbName)
+
+	case "watch":
+		// Strumień zmian wszystkich kolekcji bazy
+		if !utils.FileExists(dbPath) {
+			http.Error(w, "Baza danych nie istnieje", http.StatusNotFound)
+			return
+		}
+		watchChanges(w, r, dbName, "")
+
+	case "profile":
+		// Ustawienia profilera i wpisy system.profile
+		profileDatabase(w, r, dbName)
+
+	case "setQuota", "getQuota":
+		// Limity liczby dokumentów i rozmiaru bazy
+		handleQuotaOperation(w, r, dbName, command)
+
+	case "rotateKey":
+		// Nowy klucz danych bazy i ponowne zaszyfrowanie jej plików
+		if !requireAdmin(w, r) {
+			return
+		}
+		if !utils.FileExists(dbPath) {
+			http.Error(w, "Baza danych nie istnieje", http.StatusNotFound)
+			return
+		}
+		rotateKeys(w, r, []string{dbName})
+
 	default:
 		http.Error(w, "Nieznana operacja w database", http.StatusBadRequest)
 	}
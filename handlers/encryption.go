@@ -0,0 +1,861 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// Zaszyfrowany plik danych ma postać:
+//
+//	"BDBENC1\n" | długość id klucza (1 bajt) | id klucza danych | fragmenty
+//
+// Każdy fragment to długość (uint32, najstarszy bit oznacza ostatni fragment), nonce i szyfrogram AES-GCM.
+// Numer fragmentu i znacznik końca są uwierzytelniane, więc zmiana kolejności lub obcięcie pliku są wykrywane.
+// Pliki bez nagłówka są czytane jako niezaszyfrowane, co pozwala włączyć szyfrowanie na istniejących danych.
+const (
+	encryptedFileMagic = "BDBENC1\n"
+	encryptedChunkSize = 64 << 10
+	finalChunkFlag     = 1 << 31
+)
+
+// keyRingFile przechowuje klucze danych bazy zaszyfrowane kluczem głównym
+const keyRingFile = ".keyring"
+
+// masterKey to klucz główny służący do szyfrowania kluczy danych
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// keyRing to zawartość pliku z kluczami danych bazy
+type keyRing struct {
+	MasterKeyID string           `json:"master_key_id"`
+	ActiveKey   string           `json:"active_key"`
+	Keys        []wrappedDataKey `json:"keys"`
+	// FieldKey szyfruje wybrane pola dokumentów; nie podlega rotacji, aby zaszyfrowane wartości
+	// w historii, kopiach i eksportach pozostały czytelne
+	FieldKey string `json:"field_key,omitempty"`
+}
+
+// fieldKeyAAD wiąże zaszyfrowany klucz pól z jego przeznaczeniem
+const fieldKeyAAD = "field-key"
+
+// wrappedDataKey to klucz danych zaszyfrowany kluczem głównym
+type wrappedDataKey struct {
+	ID        string `json:"id"`
+	Key       string `json:"key"`
+	CreatedAt string `json:"created_at"`
+}
+
+// cachedKeyRing to odszyfrowany pęk kluczy bazy, ważny dopóki plik się nie zmieni
+type cachedKeyRing struct {
+	active   string
+	raw      map[string][]byte
+	aeads    map[string]cipher.AEAD
+	fieldKey []byte
+	modTime  time.Time
+	size     int64
+}
+
+var (
+	encryptionMu sync.Mutex
+	masterKeys   []*masterKey // bieżący klucz główny, opcjonalnie poprzedni
+	keyRings     = map[string]*cachedKeyRing{}
+)
+
+// InitEncryption wczytuje klucze główne z konfiguracji. Bez klucza głównego dane są zapisywane jawnie.
+func InitEncryption() error {
+	current, err := loadMasterKey(config.MasterKey, config.MasterKeyFile)
+	if err != nil {
+		return fmt.Errorf("klucz główny: %v", err)
+	}
+	previous, err := loadMasterKey(config.PreviousMasterKey, config.PreviousMasterKeyFile)
+	if err != nil {
+		return fmt.Errorf("poprzedni klucz główny: %v", err)
+	}
+	if current == nil && previous != nil {
+		return fmt.Errorf("podano poprzedni klucz główny bez bieżącego")
+	}
+
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+
+	masterKeys = nil
+	if current != nil {
+		masterKeys = append(masterKeys, current)
+	}
+	if previous != nil {
+		masterKeys = append(masterKeys, previous)
+	}
+	keyRings = map[string]*cachedKeyRing{}
+	return nil
+}
+
+// loadMasterKey odczytuje klucz główny z wartości lub pliku
+func loadMasterKey(value, file string) (*masterKey, error) {
+	if value == "" && file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		value = strings.TrimSpace(string(data))
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	key, err := decodeKey(value)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(key)
+	return &masterKey{id: hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+// decodeKey dekoduje 32-bajtowy klucz zapisany w hex lub base64
+func decodeKey(value string) ([]byte, error) {
+	if key, err := hex.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("klucz musi mieć 32 bajty zapisane w base64 lub hex")
+}
+
+// newAEAD tworzy szyfr AES-GCM dla klucza
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal szyfruje dane losowym nonce i zwraca nonce wraz z szyfrogramem
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open odszyfrowuje dane zapisane przez seal
+func open(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("za krótki szyfrogram")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+}
+
+// encryptionEnabled sprawdza, czy skonfigurowano klucz główny
+func encryptionEnabled() bool {
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+	return len(masterKeys) > 0
+}
+
+// databaseDir zwraca katalog bazy, do której należy plik danych.
+// Pliki spoza config.DataDir (kosz, katalogi tymczasowe) nie należą do żadnej bazy.
+func databaseDir(path string) (string, bool) {
+	rel, err := filepath.Rel(config.DataDir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", false
+	}
+	dbName := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+	return filepath.Join(config.DataDir, dbName), true
+}
+
+// keyRingDir zwraca katalog z pękiem kluczy, którym zaszyfrowano plik danych: katalog bazy
+// albo, dla plików elementu kosza, jego katalog danych z kopią pęku kluczy bazy
+func keyRingDir(path string) (string, bool) {
+	if dbPath, ok := databaseDir(path); ok {
+		return dbPath, true
+	}
+	rel, err := filepath.Rel(config.TrashDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	parts := strings.SplitN(filepath.ToSlash(rel), "/", 3)
+	if len(parts) < 2 || parts[1] != trashDataDir {
+		return "", false
+	}
+	return filepath.Join(config.TrashDir, parts[0], trashDataDir), true
+}
+
+// loadKeyRing odczytuje pęk kluczy bazy. Wywołujący musi trzymać encryptionMu.
+func loadKeyRing(dbPath string) (*cachedKeyRing, error) {
+	path := filepath.Join(dbPath, keyRingFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		delete(keyRings, dbPath)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if cached := keyRings[dbPath]; cached != nil && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached, nil
+	}
+
+	var ring keyRing
+	if err := utils.ReadJSONFile(path, &ring); err != nil {
+		return nil, err
+	}
+
+	var master *masterKey
+	for _, key := range masterKeys {
+		if key.id == ring.MasterKeyID {
+			master = key
+		}
+	}
+	if master == nil {
+		return nil, fmt.Errorf("klucze danych bazy zaszyfrowano nieznanym kluczem głównym %s", ring.MasterKeyID)
+	}
+
+	cached := &cachedKeyRing{
+		active:  ring.ActiveKey,
+		raw:     map[string][]byte{},
+		aeads:   map[string]cipher.AEAD{},
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+	for _, wrapped := range ring.Keys {
+		data, err := base64.StdEncoding.DecodeString(wrapped.Key)
+		if err != nil {
+			return nil, fmt.Errorf("klucz danych %s: %v", wrapped.ID, err)
+		}
+		raw, err := open(master.aead, data, []byte(wrapped.ID))
+		if err != nil {
+			return nil, fmt.Errorf("nie można odszyfrować klucza danych %s", wrapped.ID)
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, err
+		}
+		cached.raw[wrapped.ID] = raw
+		cached.aeads[wrapped.ID] = aead
+	}
+	if cached.aeads[cached.active] == nil {
+		return nil, fmt.Errorf("brak aktywnego klucza danych %s", cached.active)
+	}
+	if ring.FieldKey != "" {
+		data, err := base64.StdEncoding.DecodeString(ring.FieldKey)
+		if err == nil {
+			cached.fieldKey, err = open(master.aead, data, []byte(fieldKeyAAD))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("nie można odszyfrować klucza pól")
+		}
+	}
+
+	keyRings[dbPath] = cached
+	return cached, nil
+}
+
+// saveKeyRing szyfruje klucze danych bieżącym kluczem głównym i zapisuje pęk kluczy.
+// Wywołujący musi trzymać encryptionMu.
+func saveKeyRing(dbPath, active string, raw map[string][]byte, created map[string]string, fieldKey []byte) error {
+	master := masterKeys[0]
+	ring := keyRing{MasterKeyID: master.id, ActiveKey: active, Keys: []wrappedDataKey{}}
+
+	if fieldKey != nil {
+		wrapped, err := seal(master.aead, fieldKey, []byte(fieldKeyAAD))
+		if err != nil {
+			return err
+		}
+		ring.FieldKey = base64.StdEncoding.EncodeToString(wrapped)
+	}
+
+	for id, key := range raw {
+		wrapped, err := seal(master.aead, key, []byte(id))
+		if err != nil {
+			return err
+		}
+		ring.Keys = append(ring.Keys, wrappedDataKey{
+			ID:        id,
+			Key:       base64.StdEncoding.EncodeToString(wrapped),
+			CreatedAt: created[id],
+		})
+	}
+
+	data, err := json.MarshalIndent(ring, "", "  ")
+	if err != nil {
+		return err
+	}
+	delete(keyRings, dbPath)
+	return writeFileAtomic(filepath.Join(dbPath, keyRingFile), data)
+}
+
+// addDataKey dodaje do pęku bazy nowy aktywny klucz danych, zachowując poprzednie klucze do odczytu.
+// Wszystkie klucze są przy tym szyfrowane bieżącym kluczem głównym.
+func addDataKey(dbPath string, keepOld bool) (string, error) {
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+	return addDataKeyLocked(dbPath, keepOld)
+}
+
+// addDataKeyLocked działa jak addDataKey. Wywołujący musi trzymać encryptionMu.
+func addDataKeyLocked(dbPath string, keepOld bool) (string, error) {
+	if len(masterKeys) == 0 {
+		return "", fmt.Errorf("szyfrowanie nie jest włączone")
+	}
+
+	raw := map[string][]byte{}
+	created := map[string]string{}
+	var fieldKey []byte
+	if keepOld {
+		cached, err := loadKeyRing(dbPath)
+		if err != nil {
+			return "", err
+		}
+		if cached != nil {
+			for id, key := range cached.raw {
+				raw[id] = key
+			}
+			created = keyCreationTimes(dbPath)
+			fieldKey = cached.fieldKey
+		}
+	}
+
+	key := make([]byte, 32)
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(idBytes)
+	raw[id] = key
+	created[id] = models.GetCurrentTimestamp()
+
+	return id, saveKeyRing(dbPath, id, raw, created, fieldKey)
+}
+
+// retainDataKey usuwa z pęku bazy wszystkie klucze poza podanym
+func retainDataKey(dbPath, id string) error {
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+
+	cached, err := loadKeyRing(dbPath)
+	if err != nil {
+		return err
+	}
+	if cached == nil || cached.raw[id] == nil {
+		return fmt.Errorf("brak klucza danych %s", id)
+	}
+	return saveKeyRing(dbPath, id, map[string][]byte{id: cached.raw[id]}, keyCreationTimes(dbPath), cached.fieldKey)
+}
+
+// adoptDataKey dodaje do pęku w katalogu dbPath klucz danych keyID z pęku w katalogu sourceDir
+// i ustawia go jako aktywny. Pozostałe klucze i klucz pól pozostają bez zmian.
+func adoptDataKey(dbPath, sourceDir, keyID string) error {
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+
+	source, err := loadKeyRing(sourceDir)
+	if err != nil {
+		return err
+	}
+	if source == nil || source.raw[keyID] == nil {
+		return fmt.Errorf("brak klucza danych %s", keyID)
+	}
+	target, err := loadKeyRing(dbPath)
+	if err != nil {
+		return err
+	}
+
+	raw := map[string][]byte{keyID: source.raw[keyID]}
+	created := keyCreationTimes(dbPath)
+	created[keyID] = keyCreationTimes(sourceDir)[keyID]
+	var fieldKey []byte
+	if target != nil {
+		for id, key := range target.raw {
+			raw[id] = key
+		}
+		fieldKey = target.fieldKey
+	}
+	return saveKeyRing(dbPath, keyID, raw, created, fieldKey)
+}
+
+// forgetKeyRing usuwa z pamięci odszyfrowany pęk kluczy katalogu, który przestaje być używany
+func forgetKeyRing(dbPath string) {
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+	delete(keyRings, dbPath)
+}
+
+// mergeKeyRing dodaje do pęku bazy klucze danych z pęku zapisanego w katalogu sourceDir
+// (kopia pęku w koszu lub w odtwarzanej kopii zapasowej), których pęk bazy jeszcze nie zawiera.
+// Aktywny klucz i klucz pól bazy się nie zmieniają. Baza bez pęku otrzymuje kopię pęku.
+func mergeKeyRing(dbPath, sourceDir string) error {
+	sourcePath := filepath.Join(sourceDir, keyRingFile)
+	if !utils.FileExists(sourcePath) {
+		return nil
+	}
+
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+
+	if len(masterKeys) == 0 {
+		// Bez klucza głównego nie można odczytać kluczy, pęk jest tylko przenoszony do bazy bez pęku
+		if utils.FileExists(filepath.Join(dbPath, keyRingFile)) {
+			return nil
+		}
+		data, err := os.ReadFile(sourcePath)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(filepath.Join(dbPath, keyRingFile), data)
+	}
+
+	source, err := loadKeyRing(sourceDir)
+	delete(keyRings, sourceDir)
+	if err != nil {
+		return fmt.Errorf("kopia pęku kluczy: %v", err)
+	}
+	target, err := loadKeyRing(dbPath)
+	if err != nil {
+		return err
+	}
+	sourceCreated := keyCreationTimes(sourceDir)
+	if target == nil {
+		return saveKeyRing(dbPath, source.active, source.raw, sourceCreated, source.fieldKey)
+	}
+
+	raw := make(map[string][]byte, len(target.raw)+len(source.raw))
+	for id, key := range target.raw {
+		raw[id] = key
+	}
+	created := keyCreationTimes(dbPath)
+	added := false
+	for id, key := range source.raw {
+		if raw[id] == nil {
+			raw[id] = key
+			created[id] = sourceCreated[id]
+			added = true
+		}
+	}
+	fieldKey := target.fieldKey
+	if fieldKey == nil && source.fieldKey != nil {
+		fieldKey, added = source.fieldKey, true
+	}
+	if !added {
+		return nil
+	}
+	return saveKeyRing(dbPath, target.active, raw, created, fieldKey)
+}
+
+// snapshotKeyRing kopiuje pęk kluczy bazy do katalogu targetDir, aby przeniesione tam
+// pliki (np. kolekcja w koszu) dało się odczytać także po rotacji kluczy bazy
+func snapshotKeyRing(dbPath, targetDir string) error {
+	data, err := os.ReadFile(filepath.Join(dbPath, keyRingFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := utils.EnsureDirectoryExists(targetDir); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(targetDir, keyRingFile), data)
+}
+
+// keyCreationTimes zwraca daty utworzenia kluczy zapisane w pęku bazy
+func keyCreationTimes(dbPath string) map[string]string {
+	created := map[string]string{}
+	var ring keyRing
+	if err := utils.ReadJSONFile(filepath.Join(dbPath, keyRingFile), &ring); err == nil {
+		for _, key := range ring.Keys {
+			created[key.ID] = key.CreatedAt
+		}
+	}
+	return created
+}
+
+// activeDataKey zwraca aktywny klucz danych bazy (lub elementu kosza), do której należy plik,
+// tworząc go przy pierwszym użyciu.
+// Zwraca nil, jeśli plik należy zapisać bez szyfrowania.
+func activeDataKey(path string) (string, cipher.AEAD, error) {
+	dbPath, ok := keyRingDir(path)
+	if !ok || !encryptionEnabled() {
+		return "", nil, nil
+	}
+
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+
+	cached, err := loadKeyRing(dbPath)
+	if err == nil && cached == nil {
+		if _, err = addDataKeyLocked(dbPath, false); err == nil {
+			cached, err = loadKeyRing(dbPath)
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return cached.active, cached.aeads[cached.active], nil
+}
+
+// fieldKeyFor zwraca klucz pól bazy, do której należy plik, tworząc go przy pierwszym użyciu
+func fieldKeyFor(path string) ([]byte, error) {
+	dbPath, ok := databaseDir(path)
+	if !ok {
+		return nil, fmt.Errorf("plik %s nie należy do żadnej bazy", filepath.Base(path))
+	}
+
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+
+	if len(masterKeys) == 0 {
+		return nil, fmt.Errorf("szyfrowanie pól wymaga klucza głównego (BASEDB_MASTER_KEY lub BASEDB_MASTER_KEY_FILE)")
+	}
+
+	cached, err := loadKeyRing(dbPath)
+	if err == nil && cached == nil {
+		if _, err = addDataKeyLocked(dbPath, false); err == nil {
+			cached, err = loadKeyRing(dbPath)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cached.fieldKey != nil {
+		return cached.fieldKey, nil
+	}
+
+	fieldKey := make([]byte, 32)
+	if _, err := rand.Read(fieldKey); err != nil {
+		return nil, err
+	}
+	if err := saveKeyRing(dbPath, cached.active, cached.raw, keyCreationTimes(dbPath), fieldKey); err != nil {
+		return nil, err
+	}
+	return fieldKey, nil
+}
+
+// dataKey zwraca klucz danych o podanym id dla bazy (lub elementu kosza), do której należy plik
+func dataKey(path, id string) (cipher.AEAD, error) {
+	dbPath, ok := keyRingDir(path)
+	if !ok {
+		return nil, fmt.Errorf("plik %s jest zaszyfrowany, ale nie należy do żadnej bazy", filepath.Base(path))
+	}
+
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+
+	if len(masterKeys) == 0 {
+		return nil, fmt.Errorf("plik %s jest zaszyfrowany, a klucz główny nie jest skonfigurowany", filepath.Base(path))
+	}
+	cached, err := loadKeyRing(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if cached == nil || cached.aeads[id] == nil {
+		return nil, fmt.Errorf("brak klucza danych %s", id)
+	}
+	return cached.aeads[id], nil
+}
+
+// chunkAAD zwraca dane uwierzytelniające fragmentu pliku
+func chunkAAD(index uint64, final bool) []byte {
+	aad := make([]byte, 9)
+	binary.BigEndian.PutUint64(aad, index)
+	if final {
+		aad[8] = 1
+	}
+	return aad
+}
+
+// encryptingWriter szyfruje zapisywane dane fragmentami
+type encryptingWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index uint64
+}
+
+// newEncryptingWriter zapisuje nagłówek zaszyfrowanego pliku
+func newEncryptingWriter(w io.Writer, keyID string, aead cipher.AEAD) (*encryptingWriter, error) {
+	header := append([]byte(encryptedFileMagic), byte(len(keyID)))
+	header = append(header, keyID...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{w: w, aead: aead}, nil
+}
+
+// Write buforuje dane i szyfruje pełne fragmenty
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	for len(e.buf) > encryptedChunkSize {
+		if err := e.sealChunk(e.buf[:encryptedChunkSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = append(e.buf[:0], e.buf[encryptedChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+// Close szyfruje ostatni fragment. Nie zamyka docelowego pliku.
+func (e *encryptingWriter) Close() error {
+	err := e.sealChunk(e.buf, true)
+	e.buf = nil
+	return err
+}
+
+// sealChunk szyfruje i zapisuje jeden fragment
+func (e *encryptingWriter) sealChunk(plaintext []byte, final bool) error {
+	sealed, err := seal(e.aead, plaintext, chunkAAD(e.index, final))
+	if err != nil {
+		return err
+	}
+
+	length := uint32(len(sealed))
+	if final {
+		length |= finalChunkFlag
+	}
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], length)
+	if _, err := e.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.index++
+	return nil
+}
+
+// decryptingReader odszyfrowuje kolejne fragmenty pliku
+type decryptingReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	index uint64
+	plain []byte
+	done  bool
+}
+
+// Read zwraca odszyfrowane dane
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// nextChunk odczytuje i odszyfrowuje kolejny fragment
+func (d *decryptingReader) nextChunk() error {
+	var header [4]byte
+	if _, err := io.ReadFull(d.r, header[:]); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("zaszyfrowany plik jest niekompletny")
+		}
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	final := length&finalChunkFlag != 0
+	length &^= finalChunkFlag
+	if int(length) > encryptedChunkSize+d.aead.NonceSize()+d.aead.Overhead() {
+		return fmt.Errorf("nieprawidłowy fragment zaszyfrowanego pliku")
+	}
+
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("zaszyfrowany plik jest niekompletny")
+	}
+	plain, err := open(d.aead, sealed, chunkAAD(d.index, final))
+	if err != nil {
+		return fmt.Errorf("nie można odszyfrować pliku: dane są uszkodzone lub klucz jest nieprawidłowy")
+	}
+
+	d.index++
+	d.plain = plain
+	d.done = final
+	return nil
+}
+
+// dataFileReader łączy czytnik danych z zamykanym plikiem
+type dataFileReader struct {
+	io.Reader
+	file    *os.File
+	counter *countingReader
+	path    string
+}
+
+// Close zamyka plik i zgłasza liczbę odczytanych bajtów
+func (r *dataFileReader) Close() error {
+	metrics.addBytes(r.path, r.counter.n, false)
+	return r.file.Close()
+}
+
+// nopWriteCloser zapisuje dane bez szyfrowania
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close nic nie robi
+func (nopWriteCloser) Close() error { return nil }
+
+// openDataFile otwiera plik danych do odczytu, odszyfrowując go w razie potrzeby
+func openDataFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := &countingReader{r: file}
+	reader := bufio.NewReader(counter)
+	keyID, encrypted, err := readEncryptionHeader(reader)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !encrypted {
+		return &dataFileReader{Reader: reader, file: file, counter: counter, path: path}, nil
+	}
+
+	aead, err := dataKey(path, keyID)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &dataFileReader{Reader: &decryptingReader{r: reader, aead: aead}, file: file, counter: counter, path: path}, nil
+}
+
+// readEncryptionHeader odczytuje nagłówek zaszyfrowanego pliku i zwraca id klucza danych.
+// Dla pliku bez nagłówka nie zużywa żadnych danych.
+func readEncryptionHeader(reader *bufio.Reader) (string, bool, error) {
+	magic, err := reader.Peek(len(encryptedFileMagic))
+	if err != nil || string(magic) != encryptedFileMagic {
+		return "", false, nil
+	}
+	reader.Discard(len(encryptedFileMagic))
+
+	length, err := reader.ReadByte()
+	if err != nil {
+		return "", false, err
+	}
+	keyID := make([]byte, length)
+	if _, err := io.ReadFull(reader, keyID); err != nil {
+		return "", false, err
+	}
+	return string(keyID), true, nil
+}
+
+// fileKeyID zwraca id klucza danych, którym zaszyfrowano plik, lub pusty napis dla pliku jawnego
+func fileKeyID(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	keyID, _, err := readEncryptionHeader(bufio.NewReader(file))
+	return keyID, err
+}
+
+// newDataWriter opakowuje plik docelowy tak, aby zapisywane dane były szyfrowane kluczem bazy.
+// Close kończy szyfrowanie, ale nie zamyka pliku.
+func newDataWriter(w io.Writer, path string) (io.WriteCloser, error) {
+	keyID, aead, err := activeDataKey(path)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingWriter{w: w}
+	if aead == nil {
+		return &countingWriteCloser{WriteCloser: nopWriteCloser{counter}, counter: counter, path: path}, nil
+	}
+	writer, err := newEncryptingWriter(counter, keyID, aead)
+	if err != nil {
+		return nil, err
+	}
+	return &countingWriteCloser{WriteCloser: writer, counter: counter, path: path}, nil
+}
+
+// readDataFile odczytuje cały plik danych
+func readDataFile(path string) ([]byte, error) {
+	reader, err := openDataFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// writeDataFile atomowo zapisuje plik danych, szyfrując go, jeśli szyfrowanie jest włączone
+func writeDataFile(path string, data []byte) error {
+	var buf bytes.Buffer
+	writer, err := newDataWriter(&buf, path)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// readJSONDataFile odczytuje plik danych JSON; brakujący lub pusty plik nie zmienia v
+func readJSONDataFile(path string, v interface{}) error {
+	if !utils.FileExists(path) {
+		return nil
+	}
+
+	data, err := readDataFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONDataFile zapisuje strukturę do pliku danych JSON
+func writeJSONDataFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeDataFile(path, data)
+}
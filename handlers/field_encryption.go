@@ -0,0 +1,564 @@
+package handlers
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// Zaszyfrowana wartość pola to napis "$enc:1:<tryb>:<base64(nonce | szyfrogram)>", gdzie tryb to
+// "r" (losowy nonce) lub "d" (nonce wyliczany z wartości, szyfrowanie deterministyczne).
+// Szyfrowana jest wartość zapisana jako JSON, więc po odszyfrowaniu zachowuje swój typ.
+// Ścieżka pola jest uwierzytelniana, więc szyfrogramu nie da się przenieść do innego pola.
+const encryptedValuePrefix = "$enc:1:"
+
+// fieldCipher szyfruje i odszyfrowuje pola dokumentów jednej bazy
+type fieldCipher struct {
+	fields []models.EncryptedField
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// collectionNames zwraca nazwy bazy i kolekcji dla pliku kolekcji
+func collectionNames(jsonFilePath string) (string, string, bool) {
+	dbPath, ok := databaseDir(jsonFilePath)
+	if !ok || filepath.Clean(filepath.Dir(jsonFilePath)) != filepath.Clean(dbPath) {
+		return "", "", false
+	}
+	return filepath.Base(dbPath), strings.TrimSuffix(filepath.Base(jsonFilePath), ".json"), true
+}
+
+// fieldCipherFor zwraca szyfr pól kolekcji lub nil, jeśli kolekcja nie ma pól szyfrowanych
+func fieldCipherFor(jsonFilePath string) (*fieldCipher, error) {
+	dbName, collName, ok := collectionNames(jsonFilePath)
+	if !ok {
+		return nil, nil
+	}
+
+	options, err := loadCollectionOptions(dbName, collName)
+	if err != nil || len(options.EncryptedFields) == 0 {
+		return nil, err
+	}
+	return newFieldCipher(jsonFilePath, options.EncryptedFields)
+}
+
+// newFieldCipher tworzy szyfr pól na podstawie klucza pól bazy
+func newFieldCipher(jsonFilePath string, fields []models.EncryptedField) (*fieldCipher, error) {
+	key, err := fieldKeyFor(jsonFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(deriveKey(key, "basedb field encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return &fieldCipher{fields: fields, aead: aead, macKey: deriveKey(key, "basedb field nonce")}, nil
+}
+
+// deriveKey wyprowadza z klucza pól osobny klucz do danego zastosowania
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// isEncryptedValue sprawdza, czy wartość jest zaszyfrowanym polem
+func isEncryptedValue(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && strings.HasPrefix(s, encryptedValuePrefix)
+}
+
+// field zwraca ustawienia pola szyfrowanego o podanej ścieżce
+func (c *fieldCipher) field(path string) (models.EncryptedField, bool) {
+	for _, field := range c.fields {
+		if field.Path == path {
+			return field, true
+		}
+	}
+	return models.EncryptedField{}, false
+}
+
+// encryptValue szyfruje wartość pola
+func (c *fieldCipher) encryptValue(field models.EncryptedField, value interface{}) (string, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	mode := "r"
+	var sealed []byte
+	if field.Deterministic {
+		mode = "d"
+		mac := hmac.New(sha256.New, c.macKey)
+		mac.Write([]byte(field.Path))
+		mac.Write([]byte{0})
+		mac.Write(plaintext)
+		nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+		sealed = c.aead.Seal(nonce, nonce, plaintext, []byte(field.Path))
+	} else if sealed, err = seal(c.aead, plaintext, []byte(field.Path)); err != nil {
+		return "", err
+	}
+
+	return encryptedValuePrefix + mode + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptValue odszyfrowuje wartość pola zapisanego pod podaną ścieżką
+func (c *fieldCipher) decryptValue(path, value string) (interface{}, error) {
+	encoded := strings.TrimPrefix(value, encryptedValuePrefix)
+	if len(encoded) < 2 || encoded[1] != ':' {
+		return nil, fmt.Errorf("nieprawidłowa zaszyfrowana wartość pola '%s'", path)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded[2:])
+	if err != nil {
+		return nil, fmt.Errorf("nieprawidłowa zaszyfrowana wartość pola '%s'", path)
+	}
+	plaintext, err := open(c.aead, sealed, []byte(path))
+	if err != nil {
+		return nil, fmt.Errorf("nie można odszyfrować pola '%s'", path)
+	}
+
+	var result interface{}
+	err = json.Unmarshal(plaintext, &result)
+	return result, err
+}
+
+// sealDocument szyfruje w miejscu jawne wartości pól szyfrowanych
+func (c *fieldCipher) sealDocument(doc models.Document) error {
+	for _, field := range c.fields {
+		value, ok := lookupPath(doc, field.Path)
+		if !ok || value == nil || isEncryptedValue(value) {
+			continue
+		}
+
+		sealed, err := c.encryptValue(field, value)
+		if err != nil {
+			return err
+		}
+		if _, literal := doc[field.Path]; literal {
+			doc[field.Path] = sealed
+		} else if err := setPath(doc, field.Path, sealed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealQuery szyfruje w zapytaniu wartości porównywane z polami szyfrowanymi deterministycznie.
+// Pól szyfrowanych losowo nie da się wyszukiwać, a na polach deterministycznych działają
+// tylko operatory równości.
+func (c *fieldCipher) sealQuery(query map[string]interface{}) error {
+	for path, condition := range query {
+		field, ok := c.field(path)
+		if !ok {
+			continue
+		}
+
+		operators, hasOperators := condition.(map[string]interface{})
+		if hasOperators {
+			if _, onlyExists := operators["$exists"]; onlyExists && len(operators) == 1 {
+				continue
+			}
+		}
+		if !field.Deterministic {
+			return fmt.Errorf("Pole '%s' jest szyfrowane niedeterministycznie i nie może być użyte w zapytaniu", path)
+		}
+
+		if !hasOperators {
+			sealed, err := c.encryptValue(field, condition)
+			if err != nil {
+				return err
+			}
+			query[path] = sealed
+			continue
+		}
+
+		sealedOperators := map[string]interface{}{}
+		for operator, value := range operators {
+			switch operator {
+			case "$eq", "$ne":
+				sealed, err := c.encryptValue(field, value)
+				if err != nil {
+					return err
+				}
+				sealedOperators[operator] = sealed
+			case "$in", "$nin":
+				values, ok := value.([]interface{})
+				if !ok {
+					sealedOperators[operator] = value
+					continue
+				}
+				sealedValues := make([]interface{}, len(values))
+				for i, item := range values {
+					sealed, err := c.encryptValue(field, item)
+					if err != nil {
+						return err
+					}
+					sealedValues[i] = sealed
+				}
+				sealedOperators[operator] = sealedValues
+			case "$exists":
+				sealedOperators[operator] = value
+			default:
+				return fmt.Errorf("Operator '%s' nie jest obsługiwany dla pola szyfrowanego '%s'", operator, path)
+			}
+		}
+		query[path] = sealedOperators
+	}
+	return nil
+}
+
+// urlValueCandidates zwraca możliwe wartości parametru URL porównywanego z polem szyfrowanym:
+// sam napis oraz liczbę lub wartość logiczną, jeśli napis ją zapisuje. Parametr URL nie niesie
+// typu, a szyfrogram deterministyczny pasuje tylko do wartości tego samego typu.
+func urlValueCandidates(raw string) []interface{} {
+	candidates := []interface{}{raw}
+	var typed interface{}
+	if err := json.Unmarshal([]byte(raw), &typed); err == nil {
+		switch typed.(type) {
+		case float64, bool:
+			candidates = append(candidates, typed)
+		}
+	}
+	return candidates
+}
+
+// sealParams zastępuje wartości parametrów URL porównywane z polami szyfrowanymi szyfrogramami
+// wszystkich możliwych typów wartości (zob. urlValueCandidates). Dokument pasuje, jeśli pole
+// jest równe któremukolwiek z nich.
+func (c *fieldCipher) sealParams(params url.Values) error {
+	for path, values := range params {
+		field, ok := c.field(path)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if !field.Deterministic {
+			return fmt.Errorf("Pole '%s' jest szyfrowane niedeterministycznie i nie może być użyte w zapytaniu", path)
+		}
+		var sealedValues []string
+		for _, candidate := range urlValueCandidates(values[0]) {
+			sealed, err := c.encryptValue(field, candidate)
+			if err != nil {
+				return err
+			}
+			sealedValues = append(sealedValues, sealed)
+		}
+		params[path] = sealedValues
+	}
+	return nil
+}
+
+// sealURLQuery szyfruje zapytanie zbudowane z parametrów URL. Wartość porównywana z polem
+// szyfrowanym jest zamieniana na $in ze wszystkimi możliwymi typami wartości parametru.
+func (c *fieldCipher) sealURLQuery(query map[string]interface{}) error {
+	for path, condition := range query {
+		raw, isString := condition.(string)
+		if _, ok := c.field(path); ok && isString {
+			query[path] = map[string]interface{}{"$in": urlValueCandidates(raw)}
+		}
+	}
+	return c.sealQuery(query)
+}
+
+// sealDocuments szyfruje pola szyfrowane w dokumentach zapisywanych do kolekcji
+func sealDocuments(jsonFilePath string, docs []models.Document) error {
+	fields, err := fieldCipherFor(jsonFilePath)
+	if err != nil || fields == nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := fields.sealDocument(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldRevealer odszyfrowuje pola dokumentów w odpowiedziach dla wywołujących z uprawnieniem decrypt.
+// Klucz pól jest pobierany dopiero przy pierwszej zaszyfrowanej wartości.
+type fieldRevealer struct {
+	jsonFilePath string
+	cipher       *fieldCipher
+}
+
+// newFieldRevealer zwraca nil, jeśli wywołujący nie może odczytać pól szyfrowanych
+func newFieldRevealer(r *http.Request, jsonFilePath string) *fieldRevealer {
+	if !hasPermission(r, permissionDecrypt) || !encryptionEnabled() {
+		return nil
+	}
+	return &fieldRevealer{jsonFilePath: jsonFilePath}
+}
+
+// reveal zwraca kopię dokumentu z odszyfrowanymi wszystkimi zaszyfrowanymi polami, również takimi,
+// które nie są już skonfigurowane jako szyfrowane. Bez uprawnienia zwraca dokument bez zmian.
+// Pola, którego nie da się odszyfrować, nie zastępuje szyfrogram - zwracany jest błąd.
+func (fr *fieldRevealer) reveal(doc models.Document) (models.Document, error) {
+	if fr == nil || doc == nil {
+		return doc, nil
+	}
+	revealed, err := fr.revealValue("", map[string]interface{}(doc))
+	if err != nil {
+		return nil, fmt.Errorf("Nie można odszyfrować dokumentu: %v", err)
+	}
+	return models.Document(revealed.(map[string]interface{})), nil
+}
+
+// revealValue kopiuje wartość, odszyfrowując zaszyfrowane pola
+func (fr *fieldRevealer) revealValue(path string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if path == "" || !isEncryptedValue(v) {
+			return v, nil
+		}
+		if fr.cipher == nil {
+			fields, err := newFieldCipher(fr.jsonFilePath, nil)
+			if err != nil {
+				return nil, err
+			}
+			fr.cipher = fields
+		}
+		return fr.cipher.decryptValue(path, v)
+	case models.Document:
+		return fr.revealValue(path, map[string]interface{}(v))
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			itemPath := key
+			if path != "" {
+				itemPath = path + "." + key
+			}
+			revealed, err := fr.revealValue(itemPath, item)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = revealed
+		}
+		return result, nil
+	default:
+		return v, nil
+	}
+}
+
+// revealAll odszyfrowuje pola w liście dokumentów
+func (fr *fieldRevealer) revealAll(docs []models.Document) ([]models.Document, error) {
+	if fr == nil {
+		return docs, nil
+	}
+	revealed := make([]models.Document, len(docs))
+	for i, doc := range docs {
+		var err error
+		if revealed[i], err = fr.reveal(doc); err != nil {
+			return nil, err
+		}
+	}
+	return revealed, nil
+}
+
+// revealDocuments odszyfrowuje pola dokumentów, jeśli wywołujący ma uprawnienie decrypt
+func revealDocuments(r *http.Request, jsonFilePath string, docs []models.Document) ([]models.Document, error) {
+	return newFieldRevealer(r, jsonFilePath).revealAll(docs)
+}
+
+// revealDocument odszyfrowuje pola dokumentu, jeśli wywołujący ma uprawnienie decrypt
+func revealDocument(r *http.Request, jsonFilePath string, doc models.Document) (models.Document, error) {
+	return newFieldRevealer(r, jsonFilePath).reveal(doc)
+}
+
+// sealQueryFor szyfruje wartości zapytania dla pól szyfrowanych kolekcji
+func sealQueryFor(jsonFilePath string, query map[string]interface{}) error {
+	fields, err := fieldCipherFor(jsonFilePath)
+	if err != nil || fields == nil {
+		return err
+	}
+	return fields.sealQuery(query)
+}
+
+// sealURLQueryFor szyfruje zapytanie zbudowane z parametrów URL dla pól szyfrowanych kolekcji
+func sealURLQueryFor(jsonFilePath string, query map[string]interface{}) error {
+	fields, err := fieldCipherFor(jsonFilePath)
+	if err != nil || fields == nil {
+		return err
+	}
+	return fields.sealURLQuery(query)
+}
+
+// sealParamsFor szyfruje parametry URL dla pól szyfrowanych kolekcji
+func sealParamsFor(jsonFilePath string, params url.Values) error {
+	fields, err := fieldCipherFor(jsonFilePath)
+	if err != nil || fields == nil {
+		return err
+	}
+	return fields.sealParams(params)
+}
+
+// setEncryptedFields ustawia listę pól szyfrowanych kolekcji i ponownie zapisuje jej dokumenty,
+// szyfrując nowe pola i odszyfrowując pola, które przestały być szyfrowane
+func setEncryptedFields(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != "PUT" && r.Method != "POST" {
+		http.Error(w, "Wymagana metoda PUT lub POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !encryptionEnabled() {
+		http.Error(w, "Szyfrowanie nie jest włączone: ustaw klucz główny w BASEDB_MASTER_KEY lub BASEDB_MASTER_KEY_FILE", http.StatusBadRequest)
+		return
+	}
+
+	var requestBody struct {
+		Fields []models.EncryptedField `json:"fields"`
+	}
+	if err := decodeJSONBody(w, r, &requestBody); err != nil {
+		writeBodyError(w, err, "Nieprawidłowy format JSON")
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, field := range requestBody.Fields {
+		if field.Path == "" || strings.HasPrefix(field.Path, "_") {
+			http.Error(w, fmt.Sprintf("Pole '%s' nie może być szyfrowane", field.Path), http.StatusBadRequest)
+			return
+		}
+		if seen[field.Path] {
+			http.Error(w, fmt.Sprintf("Pole '%s' podano więcej niż raz", field.Path), http.StatusBadRequest)
+			return
+		}
+		seen[field.Path] = true
+	}
+
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	data, err := readDocuments(jsonFilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Wszystkie pola są najpierw odszyfrowywane, a przy zapisie szyfrowane według nowych ustawień
+	revealer := &fieldRevealer{jsonFilePath: jsonFilePath}
+	for i, doc := range data {
+		revealed, err := revealer.revealValue("", map[string]interface{}(doc))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Nie można odszyfrować dokumentu: %v", err), http.StatusInternalServerError)
+			return
+		}
+		data[i] = models.Document(revealed.(map[string]interface{}))
+	}
+
+	options, err := loadCollectionOptions(dbName, collName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać ustawień kolekcji: %v", err), http.StatusInternalServerError)
+		return
+	}
+	previous := options
+	options.EncryptedFields = requestBody.Fields
+
+	if err := saveCollectionOptions(dbName, collName, options); err != nil {
+		http.Error(w, fmt.Sprintf("Nie można zapisać ustawień kolekcji: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeDocuments(jsonFilePath, data); err != nil {
+		saveCollectionOptions(dbName, collName, previous)
+		http.Error(w, fmt.Sprintf("Nie można zapisać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := resealCollectionCopies(jsonFilePath, dbName, collName); err != nil {
+		http.Error(w, fmt.Sprintf("Nie można zaszyfrować historii i nieudanych zdarzeń webhooków: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "setEncryptedFields", dbName, collName, map[string]interface{}{"fields": options.EncryptedFields})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "success",
+		"message":   fmt.Sprintf("Ustawiono pola szyfrowane kolekcji '%s'", collName),
+		"fields":    options.EncryptedFields,
+		"documents": len(data),
+	})
+}
+
+// resealCollectionCopies szyfruje według bieżących ustawień pól kopie dokumentów przechowywane
+// poza plikiem kolekcji: wersje w historii i zdarzenia z listy nieudanych webhooków.
+// Dziennik wywołań webhooków zawiera tylko id dokumentów, więc nie wymaga zmian.
+// Wywołujący musi trzymać blokadę kolekcji.
+func resealCollectionCopies(jsonFilePath, dbName, collName string) error {
+	fields, err := fieldCipherFor(jsonFilePath)
+	if err != nil {
+		return err
+	}
+	revealer := &fieldRevealer{jsonFilePath: jsonFilePath}
+	reseal := func(doc models.Document) (models.Document, error) {
+		if doc == nil {
+			return nil, nil
+		}
+		revealed, err := revealer.reveal(doc)
+		if err == nil && fields != nil {
+			err = fields.sealDocument(revealed)
+		}
+		return revealed, err
+	}
+
+	history, err := readHistory(dbName, collName)
+	if err != nil {
+		return err
+	}
+	if len(history) > 0 {
+		for _, revisions := range history {
+			for i := range revisions {
+				if revisions[i].Document, err = reseal(revisions[i].Document); err != nil {
+					return err
+				}
+			}
+		}
+		if err := writeHistory(dbName, collName, history); err != nil {
+			return err
+		}
+	}
+
+	// Nieudane zdarzenia czekające w pamięci trafiają najpierw do pliku
+	if err := flushWebhookLogLocked(dbName, collName); err != nil {
+		return err
+	}
+	if !utils.FileExists(utils.GetCollectionWebhooksPath(config.DataDir, dbName, collName)) {
+		return nil
+	}
+	file, err := readWebhookFile(dbName, collName)
+	if err != nil || len(file.DeadLetters) == 0 {
+		return err
+	}
+	for i := range file.DeadLetters {
+		event := &file.DeadLetters[i].Event
+		if event.Document, err = reseal(event.Document); err != nil {
+			return err
+		}
+		updated, err := reseal(models.Document(event.UpdatedFields))
+		if err != nil {
+			return err
+		}
+		event.UpdatedFields = updated
+	}
+	return writeWebhookFile(dbName, collName, file)
+}
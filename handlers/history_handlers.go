@@ -0,0 +1,480 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// patchOperation reprezentuje operację JSON Patch (RFC 6902)
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON pomija pole value w operacji remove
+func (op patchOperation) MarshalJSON() ([]byte, error) {
+	if op.Op == "remove" {
+		return json.Marshal(map[string]string{"op": op.Op, "path": op.Path})
+	}
+	type plain patchOperation
+	return json.Marshal(plain(op))
+}
+
+// loadCollectionOptions odczytuje ustawienia kolekcji
+func loadCollectionOptions(dbName, collName string) (models.CollectionOptions, error) {
+	var options models.CollectionOptions
+	err := readJSONDataFile(utils.GetCollectionMetaPath(config.DataDir, dbName, collName), &options)
+	return options, err
+}
+
+// saveCollectionOptions zapisuje ustawienia kolekcji
+func saveCollectionOptions(dbName, collName string, options models.CollectionOptions) error {
+	metaPath := utils.GetCollectionMetaPath(config.DataDir, dbName, collName)
+	if err := utils.EnsureDirectoryExists(filepath.Dir(metaPath)); err != nil {
+		return err
+	}
+	return writeJSONDataFile(metaPath, options)
+}
+
+// collectionSideFiles zwraca pliki pomocnicze kolekcji (ustawienia, historia, segmenty, webhooki)
+func collectionSideFiles(dbName, collName string) []string {
+	return collectionSideFilesIn(utils.GetDatabasePath(config.DataDir, dbName), collName)
+}
+
+// collectionSideFilesIn zwraca ścieżki plików pomocniczych kolekcji w podanym katalogu bazy
+func collectionSideFilesIn(dbPath, collName string) []string {
+	return []string{
+		utils.GetCollectionMetaPath(dbPath, "", collName),
+		utils.GetCollectionHistoryPath(dbPath, "", collName),
+		utils.GetCollectionSegmentsPath(dbPath, "", collName),
+		utils.GetCollectionWebhooksPath(dbPath, "", collName),
+	}
+}
+
+// moveCollectionSideFiles przenosi pliki pomocnicze kolekcji po zmianie nazwy.
+// Po błędzie przeniesione już pliki wracają na miejsce.
+func moveCollectionSideFiles(dbName, collName, newName string) error {
+	oldFiles := collectionSideFiles(dbName, collName)
+	newFiles := collectionSideFiles(dbName, newName)
+	for i, oldPath := range oldFiles {
+		if !utils.FileExists(oldPath) {
+			os.RemoveAll(newFiles[i])
+			continue
+		}
+		// Pozostałości po kolekcji o nowej nazwie blokowałyby przeniesienie katalogu segmentów
+		os.RemoveAll(newFiles[i])
+		err := utils.EnsureDirectoryExists(filepath.Dir(newFiles[i]))
+		if err == nil {
+			err = os.Rename(oldPath, newFiles[i])
+		}
+		if err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if utils.FileExists(newFiles[j]) {
+					os.Rename(newFiles[j], oldFiles[j])
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// readHistory odczytuje historię wersji dokumentów kolekcji
+func readHistory(dbName, collName string) (map[string][]models.Revision, error) {
+	history := map[string][]models.Revision{}
+	if err := readJSONDataFile(utils.GetCollectionHistoryPath(config.DataDir, dbName, collName), &history); err != nil {
+		return nil, err
+	}
+	if history == nil {
+		history = map[string][]models.Revision{}
+	}
+	return history, nil
+}
+
+// writeHistory zapisuje historię wersji dokumentów kolekcji
+func writeHistory(dbName, collName string, history map[string][]models.Revision) error {
+	historyPath := utils.GetCollectionHistoryPath(config.DataDir, dbName, collName)
+	if err := utils.EnsureDirectoryExists(filepath.Dir(historyPath)); err != nil {
+		return err
+	}
+	return writeJSONDataFile(historyPath, history)
+}
+
+// recordRevisions zapisuje poprzednie wersje dokumentów, jeśli kolekcja ma włączoną historię.
+// Wywołujący musi trzymać blokadę kolekcji.
+func recordRevisions(dbName, collName string, deleted bool, docs ...models.Document) error {
+	options, err := loadCollectionOptions(dbName, collName)
+	if err != nil || !options.History || len(docs) == 0 {
+		return err
+	}
+
+	history, err := readHistory(dbName, collName)
+	if err != nil {
+		return err
+	}
+
+	now := models.GetCurrentTimestamp()
+	for _, doc := range docs {
+		id := documentID(doc)
+		if id == "" {
+			continue
+		}
+
+		timestamp, _ := doc["updated_at"].(string)
+		revisions := append(history[id], models.Revision{
+			Version:    models.GetVersion(doc),
+			Timestamp:  timestamp,
+			ArchivedAt: now,
+			Deleted:    deleted,
+			Document:   doc,
+		})
+
+		// Usuń najstarsze wersje ponad limit
+		if options.MaxRevisions > 0 && len(revisions) > options.MaxRevisions {
+			revisions = revisions[len(revisions)-options.MaxRevisions:]
+		}
+		history[id] = revisions
+	}
+
+	return writeHistory(dbName, collName, history)
+}
+
+// handleHistoryOperation obsługuje polecenia historii wersji dokumentów
+func handleHistoryOperation(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName, command string) {
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	if command == "setHistory" {
+		setHistory(w, r, jsonFilePath, dbName, collName)
+		return
+	}
+	if command == "restoreRevision" && r.Method != "POST" {
+		http.Error(w, "Wymagana metoda POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	docID := r.URL.Query().Get("id")
+	if docID == "" {
+		http.Error(w, "Brak parametru 'id'", http.StatusBadRequest)
+		return
+	}
+
+	lock := collectionLock(jsonFilePath)
+	if command == "restoreRevision" {
+		lock.Lock()
+		defer lock.Unlock()
+	} else {
+		lock.RLock()
+		defer lock.RUnlock()
+	}
+
+	data, err := readDocuments(jsonFilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	history, err := readHistory(dbName, collName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać historii: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Aktualna wersja dokumentu (jeśli dokument nie został usunięty)
+	var current models.Document
+	position := lookupIDIndex(jsonFilePath, data, docID)
+	if position >= 0 {
+		current = data[position]
+	}
+
+	revisions := history[docID]
+	if current == nil && len(revisions) == 0 {
+		http.Error(w, fmt.Sprintf("Nie znaleziono dokumentu o id: %s", docID), http.StatusNotFound)
+		return
+	}
+
+	switch command {
+	case "revisions":
+		list := []map[string]interface{}{}
+		for _, rev := range revisions {
+			list = append(list, map[string]interface{}{
+				"version":     rev.Version,
+				"timestamp":   rev.Timestamp,
+				"archived_at": rev.ArchivedAt,
+				"deleted":     rev.Deleted,
+			})
+		}
+
+		response := map[string]interface{}{
+			"status":    "success",
+			"id":        docID,
+			"count":     len(list),
+			"revisions": list,
+		}
+		if current != nil {
+			response["current_version"] = models.GetVersion(current)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+	case "revision":
+		doc, ok := findRevision(w, r.URL.Query().Get("version"), current, revisions)
+		if !ok {
+			return
+		}
+
+		revealed, err := revealDocument(r, jsonFilePath, doc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revealed)
+
+	case "diffRevisions":
+		from, ok := findRevision(w, r.URL.Query().Get("from"), current, revisions)
+		if !ok {
+			return
+		}
+		to, ok := findRevision(w, r.URL.Query().Get("to"), current, revisions)
+		if !ok {
+			return
+		}
+
+		revealer := newFieldRevealer(r, jsonFilePath)
+		from, err := revealer.reveal(from)
+		if err == nil {
+			to, err = revealer.reveal(to)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		patch := diffValues("", map[string]interface{}(from), map[string]interface{}(to))
+
+		w.Header().Set("Content-Type", "application/json-patch+json")
+		json.NewEncoder(w).Encode(patch)
+
+	case "restoreRevision":
+		doc, ok := findRevision(w, r.URL.Query().Get("version"), current, revisions)
+		if !ok {
+			return
+		}
+
+		// Sprawdź oczekiwaną wersję (If-Match lub expectedVersion)
+		if current != nil && !checkIfMatch(w, r, current) {
+			return
+		}
+
+		restored := models.Document{}
+		for k, v := range doc {
+			restored[k] = v
+		}
+
+		budget, err := newQuotaBudget(dbName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Nie można odczytać limitów bazy danych: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if current != nil {
+			restored = replaceDocument(current, restored)
+			if err := budget.replace(current, restored); err != nil {
+				writeQuotaError(w, err)
+				return
+			}
+			data[position] = restored
+		} else {
+			// Dokument został usunięty - przywróć go z wersją następną po ostatniej zapisanej
+			last := revisions[len(revisions)-1].Document
+			restored = replaceDocument(last, restored)
+			if err := budget.take(restored); err != nil {
+				writeQuotaError(w, err)
+				return
+			}
+			data = append(data, restored)
+		}
+
+		if err := writeDocuments(jsonFilePath, data); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można zapisać pliku JSON: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if current != nil {
+			publishChanges(updateEvent(dbName, collName, current, restored))
+
+			// Wersja sprzed przywrócenia trafia do historii dopiero po udanym zapisie dokumentu
+			if err := recordRevisions(dbName, collName, false, current); err != nil {
+				http.Error(w, fmt.Sprintf("Dokument przywrócono, ale nie można zapisać historii: %v", err), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			publishChanges(insertEvent(dbName, collName, restored))
+		}
+
+		revealed, err := revealDocument(r, jsonFilePath, restored)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("ETag", models.ETag(restored))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": fmt.Sprintf("Dokument przywrócono do wersji %d", models.GetVersion(doc)),
+			"data":    revealed,
+		})
+	}
+}
+
+// setHistory włącza lub wyłącza historię wersji w kolekcji
+func setHistory(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName string) {
+	if r.Method != "PUT" && r.Method != "POST" {
+		http.Error(w, "Wymagana metoda PUT lub POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	enabled, err := strconv.ParseBool(query.Get("enabled"))
+	if err != nil {
+		http.Error(w, "Parametr 'enabled' musi mieć wartość true lub false", http.StatusBadRequest)
+		return
+	}
+
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	options, err := loadCollectionOptions(dbName, collName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać ustawień kolekcji: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	options.History = enabled
+	if maxRevisions := query.Get("maxRevisions"); maxRevisions != "" {
+		parsed, err := strconv.Atoi(maxRevisions)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Parametr 'maxRevisions' musi być nieujemną liczbą całkowitą", http.StatusBadRequest)
+			return
+		}
+		options.MaxRevisions = parsed
+	}
+
+	if err := saveCollectionOptions(dbName, collName, options); err != nil {
+		http.Error(w, fmt.Sprintf("Nie można zapisać ustawień kolekcji: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Historia wersji w kolekcji '%s' została ustawiona", collName),
+		"options": options,
+	})
+}
+
+// findRevision wyszukuje wersję dokumentu w historii lub zwraca wersję aktualną.
+// W razie błędu zapisuje odpowiedź i zwraca false.
+func findRevision(w http.ResponseWriter, versionParam string, current models.Document, revisions []models.Revision) (models.Document, bool) {
+	if versionParam == "" || versionParam == "current" {
+		if current == nil {
+			http.Error(w, "Dokument został usunięty, podaj parametr wersji", http.StatusNotFound)
+			return nil, false
+		}
+		return current, true
+	}
+
+	version, err := strconv.ParseInt(versionParam, 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nieprawidłowy numer wersji: %s", versionParam), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if current != nil && models.GetVersion(current) == version {
+		return current, true
+	}
+
+	// Szukaj od najnowszej, bo starsze wpisy bez wersji mogą się powtarzać
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if revisions[i].Version == version {
+			return revisions[i].Document, true
+		}
+	}
+
+	http.Error(w, fmt.Sprintf("Nie znaleziono wersji %d dokumentu", version), http.StatusNotFound)
+	return nil, false
+}
+
+// diffValues generuje operacje JSON Patch przekształcające wartość from w to
+func diffValues(path string, from, to interface{}) []patchOperation {
+	ops := []patchOperation{}
+
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+	if fromIsMap && toIsMap {
+		keys := make([]string, 0, len(fromMap)+len(toMap))
+		for k := range fromMap {
+			keys = append(keys, k)
+		}
+		for k := range toMap {
+			if _, exists := fromMap[k]; !exists {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			childPath := path + "/" + escapePointer(k)
+			fromValue, inFrom := fromMap[k]
+			toValue, inTo := toMap[k]
+
+			switch {
+			case !inTo:
+				ops = append(ops, patchOperation{Op: "remove", Path: childPath})
+			case !inFrom:
+				ops = append(ops, patchOperation{Op: "add", Path: childPath, Value: toValue})
+			default:
+				ops = append(ops, diffValues(childPath, fromValue, toValue)...)
+			}
+		}
+		return ops
+	}
+
+	if !jsonEqual(from, to) {
+		ops = append(ops, patchOperation{Op: "replace", Path: path, Value: to})
+	}
+	return ops
+}
+
+// jsonEqual porównuje wartości po normalizacji liczb do reprezentacji JSON
+func jsonEqual(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aJSON) == string(bJSON)
+}
+
+// escapePointer koduje klucz jako fragment wskaźnika JSON (RFC 6901)
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
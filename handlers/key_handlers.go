@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"BaseDB/config"
+	"BaseDB/utils"
+)
+
+// keyRotation opisuje wynik rotacji klucza danych jednej bazy
+type keyRotation struct {
+	Database string `json:"database"`
+	KeyID    string `json:"key_id"`
+	Files    int    `json:"files"`
+}
+
+// rotateKeys generuje nowe klucze danych dla podanych baz i ponownie szyfruje nimi pliki.
+// Klucze danych są przy tym szyfrowane bieżącym kluczem głównym, więc polecenie służy też
+// do przejścia na nowy klucz główny (poprzedni należy podać w BASEDB_PREVIOUS_MASTER_KEY).
+func rotateKeys(w http.ResponseWriter, r *http.Request, dbNames []string) {
+	if !encryptionEnabled() {
+		http.Error(w, "Szyfrowanie nie jest włączone: ustaw klucz główny w BASEDB_MASTER_KEY lub BASEDB_MASTER_KEY_FILE", http.StatusBadRequest)
+		return
+	}
+
+	rotated := []keyRotation{}
+	for _, dbName := range dbNames {
+		result, err := rotateDatabaseKey(dbName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Błąd rotacji klucza bazy '%s': %v", dbName, err), http.StatusInternalServerError)
+			return
+		}
+		rotated = append(rotated, result)
+	}
+
+	// Dziennik audytu jest szyfrowany kluczem głównym, więc po jego zmianie trzeba go przepisać
+	auditLines, err := reencryptAuditLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można zaszyfrować dziennika audytu: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "rotateKey", "", "", map[string]interface{}{"rotated": rotated, "audit_lines": auditLines})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "success",
+		"message":     fmt.Sprintf("Zmieniono klucze danych %d baz", len(rotated)),
+		"rotated":     rotated,
+		"audit_lines": auditLines,
+	})
+}
+
+// rotateDatabaseKey ustawia nowy klucz danych bazy i ponownie szyfruje nim wszystkie jej pliki.
+// Kolekcje są blokowane po kolei, więc baza pozostaje dostępna w trakcie rotacji.
+// Poprzednie klucze są usuwane dopiero po zaszyfrowaniu wszystkich plików nowym kluczem.
+// Elementy kosza pochodzące z bazy są szyfrowane ponownie razem z nią, a z ich kopii pęku
+// kluczy znikają poprzednie klucze. Kopie zapasowe pobrane przed rotacją zawierają
+// poprzednie klucze (zaszyfrowane kluczem głównym) i rotacja ich nie obejmuje.
+func rotateDatabaseKey(dbName string) (keyRotation, error) {
+	result := keyRotation{Database: dbName}
+	dbPath := utils.GetDatabasePath(config.DataDir, dbName)
+
+	keyID, err := addDataKey(dbPath, true)
+	if err != nil {
+		return result, err
+	}
+	result.KeyID = keyID
+
+	collections, err := utils.ListJSONFiles(dbPath)
+	if err != nil {
+		return result, err
+	}
+
+	for _, collName := range collections {
+		jsonFilePath := utils.GetCollectionPath(config.DataDir, dbName, collName)
+		paths := append([]string{jsonFilePath}, collectionSideFiles(dbName, collName)...)
+
+		lock := collectionLock(jsonFilePath)
+		lock.Lock()
+		count, err := reencryptPaths(paths, keyID)
+		lock.Unlock()
+
+		result.Files += count
+		if err != nil {
+			return result, err
+		}
+	}
+
+	// Pozostałe pliki bazy, np. pliki pomocnicze kolekcji utworzonych w trakcie rotacji,
+	// które są już zaszyfrowane nowym kluczem i zostaną pominięte. Blokady kolekcji
+	// wstrzymują zapisy, które mogłyby zostać nadpisane przez ponowne szyfrowanie.
+	unlock, err := lockDatabase(dbName)
+	if err != nil {
+		return result, err
+	}
+	count, err := reencryptPaths([]string{dbPath}, keyID)
+	unlock()
+	result.Files += count
+	if err != nil {
+		return result, err
+	}
+
+	if err := retainDataKey(dbPath, keyID); err != nil {
+		return result, err
+	}
+
+	count, err = rotateTrashKeys(dbName, dbPath, keyID)
+	result.Files += count
+	return result, err
+}
+
+// reencryptPaths szyfruje kluczem keyID pliki i zawartość katalogów, które używają innego klucza
+func reencryptPaths(paths []string, keyID string) (int, error) {
+	count := 0
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !info.Mode().IsRegular() || info.Name() == keyRingFile || strings.Contains(info.Name(), ".tmp-") {
+				return nil
+			}
+
+			current, err := fileKeyID(path)
+			if err != nil || current == keyID {
+				return err
+			}
+
+			data, err := readDataFile(path)
+			if err != nil {
+				return fmt.Errorf("%s: %v", filepath.Base(path), err)
+			}
+			if err := writeDataFile(path, data); err != nil {
+				return err
+			}
+			dropIDIndex(path)
+			count++
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"BaseDB/config"
+	"BaseDB/utils"
+)
+
+// latencyBuckets to górne granice przedziałów histogramów czasu w sekundach
+var latencyBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram zlicza obserwacje w przedziałach, jak histogram Prometheusa
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// observe dodaje obserwację
+func (h *histogram) observe(value float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// metricLabels to wartości etykiet serii, połączone znakiem \x00
+type metricLabels string
+
+// labels tworzy klucz serii z wartości etykiet
+func labels(values ...string) metricLabels {
+	return metricLabels(strings.Join(values, "\x00"))
+}
+
+// metricsRegistry przechowuje metryki serwera
+type metricsRegistry struct {
+	mu               sync.Mutex
+	requests         map[metricLabels]uint64     // command, status
+	requestDurations map[metricLabels]*histogram // command, status
+	bytesRead        map[metricLabels]uint64     // database, collection
+	bytesWritten     map[metricLabels]uint64     // database, collection
+	docsScanned      map[metricLabels]uint64     // database, collection, command
+	docsReturned     map[metricLabels]uint64     // database, collection, command
+	lockWaits        map[metricLabels]*histogram // mode
+}
+
+var metrics = &metricsRegistry{
+	requests:         map[metricLabels]uint64{},
+	requestDurations: map[metricLabels]*histogram{},
+	bytesRead:        map[metricLabels]uint64{},
+	bytesWritten:     map[metricLabels]uint64{},
+	docsScanned:      map[metricLabels]uint64{},
+	docsReturned:     map[metricLabels]uint64{},
+	lockWaits:        map[metricLabels]*histogram{},
+}
+
+// observeRequest zapisuje obsłużone żądanie i czas jego obsługi
+func (m *metricsRegistry) observeRequest(command string, status int, duration time.Duration) {
+	key := labels(command, fmt.Sprint(status))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[key]++
+	if m.requestDurations[key] == nil {
+		m.requestDurations[key] = &histogram{}
+	}
+	m.requestDurations[key].observe(duration.Seconds())
+}
+
+// addBytes dolicza bajty odczytane lub zapisane w pliku danych kolekcji
+func (m *metricsRegistry) addBytes(path string, n int64, written bool) {
+	dbName, collName, ok := metricsCollection(path)
+	if !ok || n == 0 {
+		return
+	}
+	key := labels(dbName, collName)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if written {
+		m.bytesWritten[key] += uint64(n)
+	} else {
+		m.bytesRead[key] += uint64(n)
+	}
+}
+
+// observeQuery zapisuje liczbę dokumentów przejrzanych i zwróconych przez zapytanie
+func (m *metricsRegistry) observeQuery(dbName, collName, command string, scanned, returned int) {
+	key := labels(dbName, collName, command)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docsScanned[key] += uint64(scanned)
+	m.docsReturned[key] += uint64(returned)
+}
+
+// observeLockWait zapisuje czas oczekiwania na blokadę kolekcji
+func (m *metricsRegistry) observeLockWait(mode string, wait time.Duration) {
+	key := labels(mode)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lockWaits[key] == nil {
+		m.lockWaits[key] = &histogram{}
+	}
+	m.lockWaits[key].observe(wait.Seconds())
+}
+
+// metricsCollection zwraca bazę i kolekcję, do której należy plik danych.
+// Pliki bazy niezwiązane z kolekcją (np. pęk kluczy) mają pustą nazwę kolekcji.
+func metricsCollection(path string) (string, string, bool) {
+	rel, err := filepath.Rel(config.DataDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", "", false
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	switch {
+	case len(parts) == 2 && strings.HasSuffix(parts[1], ".json"):
+		return parts[0], strings.TrimSuffix(parts[1], ".json"), true
+	case len(parts) >= 3 && strings.HasPrefix(parts[1], "."):
+		// Pliki pomocnicze: .meta/{c}.json, .history/{c}.json, .segments/{c}/...
+		return parts[0], strings.TrimSuffix(parts[2], ".json"), true
+	case len(parts) >= 1:
+		return parts[0], "", true
+	}
+	return "", "", false
+}
+
+// countingReader zlicza bajty odczytane z pliku
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read odczytuje dane, zliczając bajty
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// countingWriteCloser zlicza bajty zapisane do pliku i zgłasza je po zamknięciu zapisu
+type countingWriteCloser struct {
+	io.WriteCloser
+	counter *countingWriter
+	path    string
+}
+
+// Close kończy zapis i zgłasza liczbę zapisanych bajtów
+func (cw *countingWriteCloser) Close() error {
+	err := cw.WriteCloser.Close()
+	metrics.addBytes(cw.path, cw.counter.n, true)
+	return err
+}
+
+// countingWriter zlicza bajty przekazane do zapisu
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write zapisuje dane, zliczając bajty
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// instrumentedMutex to blokada kolekcji mierząca czas oczekiwania na jej uzyskanie
+type instrumentedMutex struct {
+	sync.RWMutex
+}
+
+// Lock blokuje do zapisu
+func (m *instrumentedMutex) Lock() {
+	start := time.Now()
+	m.RWMutex.Lock()
+	metrics.observeLockWait("write", time.Since(start))
+}
+
+// RLock blokuje do odczytu
+func (m *instrumentedMutex) RLock() {
+	start := time.Now()
+	m.RWMutex.RLock()
+	metrics.observeLockWait("read", time.Since(start))
+}
+
+// HandleMetrics udostępnia metryki w formacie tekstowym Prometheusa
+func HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIKey(w, r) || !requireAdmin(w, r) {
+		return
+	}
+
+	var b strings.Builder
+
+	metrics.mu.Lock()
+	writeCounter(&b, "basedb_http_requests_total", "Liczba obsłużonych żądań według polecenia i kodu odpowiedzi.",
+		[]string{"command", "status"}, metrics.requests)
+	writeHistograms(&b, "basedb_http_request_duration_seconds", "Czas obsługi żądań według polecenia i kodu odpowiedzi.",
+		[]string{"command", "status"}, metrics.requestDurations)
+	writeCounter(&b, "basedb_storage_read_bytes_total", "Bajty odczytane z plików danych według kolekcji.",
+		[]string{"database", "collection"}, metrics.bytesRead)
+	writeCounter(&b, "basedb_storage_written_bytes_total", "Bajty zapisane do plików danych według kolekcji.",
+		[]string{"database", "collection"}, metrics.bytesWritten)
+	writeCounter(&b, "basedb_query_documents_scanned_total", "Dokumenty przejrzane przez zapytania.",
+		[]string{"database", "collection", "command"}, metrics.docsScanned)
+	writeCounter(&b, "basedb_query_documents_returned_total", "Dokumenty zwrócone przez zapytania.",
+		[]string{"database", "collection", "command"}, metrics.docsReturned)
+	writeHistograms(&b, "basedb_lock_wait_seconds", "Czas oczekiwania na blokadę kolekcji.",
+		[]string{"mode"}, metrics.lockWaits)
+	metrics.mu.Unlock()
+
+	// Zajętość dysku jest liczona przy każdym odczycie metryk
+	usage := map[metricLabels]uint64{}
+	if dbNames, err := listDatabaseNames(); err == nil {
+		for _, dbName := range dbNames {
+			if size, _, err := pathUsage(utils.GetDatabasePath(config.DataDir, dbName)); err == nil {
+				usage[labels(dbName)] = uint64(size)
+			}
+		}
+	}
+	writeGauge(&b, "basedb_disk_usage_bytes", "Rozmiar plików bazy danych na dysku.", []string{"database"}, usage)
+	writeGauge(&b, "basedb_uptime_seconds", "Czas działania serwera.", nil,
+		map[metricLabels]uint64{labels(): uint64(time.Since(serverStarted).Seconds())})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+// writeCounter zapisuje serie licznika posortowane według etykiet
+func writeCounter(b *strings.Builder, name, help string, names []string, values map[metricLabels]uint64) {
+	writeSeries(b, name, help, "counter", names, values)
+}
+
+// writeGauge zapisuje serie wskaźnika posortowane według etykiet
+func writeGauge(b *strings.Builder, name, help string, names []string, values map[metricLabels]uint64) {
+	writeSeries(b, name, help, "gauge", names, values)
+}
+
+// writeSeries zapisuje serie metryki o jednej wartości
+func writeSeries(b *strings.Builder, name, help, kind string, names []string, values map[metricLabels]uint64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, key := range sortedLabels(values) {
+		fmt.Fprintf(b, "%s%s %d\n", name, formatLabels(names, key, ""), values[key])
+	}
+}
+
+// writeHistograms zapisuje serie histogramu: przedziały, sumę i liczbę obserwacji
+func writeHistograms(b *strings.Builder, name, help string, names []string, values map[metricLabels]*histogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, key := range sortedLabels(values) {
+		h := values[key]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", name, formatLabels(names, key, fmt.Sprint(bound)), h.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", name, formatLabels(names, key, "+Inf"), h.count)
+		fmt.Fprintf(b, "%s_sum%s %g\n", name, formatLabels(names, key, ""), h.sum)
+		fmt.Fprintf(b, "%s_count%s %d\n", name, formatLabels(names, key, ""), h.count)
+	}
+}
+
+// sortedLabels zwraca klucze serii w stałej kolejności
+func sortedLabels[V any](values map[metricLabels]V) []metricLabels {
+	keys := make([]metricLabels, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// formatLabels zapisuje etykiety serii, opcjonalnie z etykietą przedziału histogramu "le"
+func formatLabels(names []string, key metricLabels, le string) string {
+	var parts []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(string(key), "\x00") {
+			if i < len(names) {
+				parts = append(parts, fmt.Sprintf("%s=\"%s\"", names[i], escapeLabel(value)))
+			}
+		}
+	}
+	if le != "" {
+		parts = append(parts, fmt.Sprintf("le=\"%s\"", le))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// escapeLabel zamienia znaki specjalne w wartości etykiety
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
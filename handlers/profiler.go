@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// profileCollection to kolekcja bazy, do której trafiają wpisy profilera
+const profileCollection = "system.profile"
+
+// profileFile to plik z ustawieniami profilera, przechowywany w katalogu bazy
+const profileFile = ".profile"
+
+// Poziomy profilera
+const (
+	profileOff  = "off"  // nic nie jest zapisywane
+	profileSlow = "slow" // zapisywane są zapytania dłuższe niż próg
+	profileAll  = "all"  // zapisywane są wszystkie zapytania
+)
+
+// profileSettings to ustawienia profilera bazy danych
+type profileSettings struct {
+	Level  string `json:"level"`
+	SlowMs int    `json:"slow_ms"`
+}
+
+// profileSettingsEntry to ustawienia profilera zapamiętane wraz z czasem zmiany pliku
+type profileSettingsEntry struct {
+	settings profileSettings
+	modTime  time.Time
+}
+
+var (
+	profileSettingsMu    sync.Mutex
+	profileSettingsCache = map[string]profileSettingsEntry{}
+
+	profileQueue     = make(chan profileRecord, 1024)
+	profileQueueOnce sync.Once
+)
+
+// profileRecord to wpis profilera oczekujący na zapis do bazy
+type profileRecord struct {
+	database string
+	entry    models.Document
+}
+
+// defaultProfileSettings zwraca ustawienia bazy, dla której nie zapisano ustawień profilera
+func defaultProfileSettings() profileSettings {
+	return profileSettings{Level: profileOff, SlowMs: config.ProfileSlowMs}
+}
+
+// loadProfileSettings zwraca ustawienia profilera bazy. Plik ustawień jest odczytywany
+// ponownie tylko po jego zmianie, więc sprawdzenie przy każdym zapytaniu jest tanie.
+func loadProfileSettings(dbName string) (profileSettings, error) {
+	path := filepath.Join(utils.GetDatabasePath(config.DataDir, dbName), profileFile)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return defaultProfileSettings(), nil
+	}
+	if err != nil {
+		return profileSettings{}, err
+	}
+
+	profileSettingsMu.Lock()
+	defer profileSettingsMu.Unlock()
+
+	if cached, ok := profileSettingsCache[path]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.settings, nil
+	}
+
+	settings := defaultProfileSettings()
+	if err := utils.ReadJSONFile(path, &settings); err != nil {
+		return profileSettings{}, err
+	}
+	profileSettingsCache[path] = profileSettingsEntry{settings: settings, modTime: info.ModTime()}
+	return settings, nil
+}
+
+// saveProfileSettings zapisuje ustawienia profilera bazy
+func saveProfileSettings(dbName string, settings profileSettings) error {
+	path := filepath.Join(utils.GetDatabasePath(config.DataDir, dbName), profileFile)
+	if err := utils.WriteJSONFile(path, settings); err != nil {
+		return err
+	}
+
+	profileSettingsMu.Lock()
+	delete(profileSettingsCache, path)
+	profileSettingsMu.Unlock()
+	return nil
+}
+
+// recordQuery zapisuje wykonanie zapytania w metrykach i, zależnie od poziomu profilera bazy,
+// w kolekcji system.profile. Zapytania na samej kolekcji system.profile nie są profilowane.
+func recordQuery(r *http.Request, dbName, collName, command string, query interface{}, options queryOptions, stats queryStats) {
+	metrics.observeQuery(dbName, collName, command, stats.Examined, stats.Returned)
+
+	if collName == profileCollection {
+		return
+	}
+	settings, err := loadProfileSettings(dbName)
+	if err != nil {
+		slog.Warn("nie można odczytać ustawień profilera", "database", dbName, "error", err)
+		return
+	}
+	switch settings.Level {
+	case profileAll:
+	case profileSlow:
+		if stats.Phases.TotalMs < float64(settings.SlowMs) {
+			return
+		}
+	default:
+		return
+	}
+
+	if values, ok := query.(url.Values); ok {
+		query = paramsQuery(values)
+	}
+	entry := models.Document{
+		"ts":                 time.Now().UTC().Format(time.RFC3339Nano),
+		"op":                 command,
+		"database":           dbName,
+		"collection":         collName,
+		"query_shape":        queryShape(query),
+		"query":              query,
+		"duration_ms":        stats.Phases.TotalMs,
+		"plan":               stats.Plan,
+		"documents_examined": stats.Examined,
+		"documents_returned": stats.Returned,
+		"in_memory_sort":     stats.InMemorySort,
+		"user":               requestUser(r),
+		"remote_addr":        r.RemoteAddr,
+	}
+	if len(options.Sort) > 0 || options.Collation != nil || options.Skip != "" || options.Limit != "" || options.Keyset {
+		entryOptions := map[string]interface{}{
+			"sort": options.Sort.String(), "skip": options.Skip, "limit": options.Limit,
+		}
+		if options.Collation != nil {
+			entryOptions["collation"] = options.Collation
+		}
+		if options.Keyset {
+			entryOptions["after"] = options.After
+		}
+		entry["options"] = entryOptions
+	}
+
+	profileQueueOnce.Do(func() { go profileWriter() })
+	select {
+	case profileQueue <- profileRecord{database: dbName, entry: models.AddMetadata(entry)}:
+	default:
+		slog.Warn("kolejka profilera jest pełna, wpis pominięto", "database", dbName, "collection", collName)
+	}
+}
+
+// paramsQuery zamienia parametry URL zapytania na mapę pole - wartość
+func paramsQuery(values url.Values) map[string]interface{} {
+	query := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if len(value) > 0 {
+			query[key] = value[0]
+		}
+	}
+	return query
+}
+
+// queryShape zwraca kształt zapytania: pola i operatory bez wartości,
+// dzięki czemu zapytania różniące się tylko wartościami mają ten sam kształt
+func queryShape(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		shape := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			shape[key] = queryShape(item)
+		}
+		return shape
+	case models.Document:
+		return queryShape(map[string]interface{}(v))
+	}
+	return "?"
+}
+
+// profileWriter zapisuje wpisy profilera w kolekcjach system.profile.
+// Wpisy oczekujące w kolejce są zapisywane razem, po jednym zapisie na bazę.
+func profileWriter() {
+	for record := range profileQueue {
+		batch := map[string][]models.Document{record.database: {record.entry}}
+	drain:
+		for {
+			select {
+			case next := <-profileQueue:
+				batch[next.database] = append(batch[next.database], next.entry)
+			default:
+				break drain
+			}
+		}
+
+		for dbName, entries := range batch {
+			if err := appendProfileEntries(dbName, entries); err != nil {
+				slog.Warn("nie można zapisać wpisów profilera", "database", dbName, "error", err)
+			}
+		}
+	}
+}
+
+// appendProfileEntries dopisuje wpisy do kolekcji system.profile bazy,
+// zachowując tylko config.ProfileMaxEntries ostatnich wpisów
+func appendProfileEntries(dbName string, entries []models.Document) error {
+	if !utils.FileExists(utils.GetDatabasePath(config.DataDir, dbName)) {
+		return nil
+	}
+	jsonFilePath := utils.GetCollectionPath(config.DataDir, dbName, profileCollection)
+
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	data, err := readDocuments(jsonFilePath)
+	if err != nil {
+		return err
+	}
+	data = append(data, entries...)
+	if config.ProfileMaxEntries > 0 && len(data) > config.ProfileMaxEntries {
+		data = data[len(data)-config.ProfileMaxEntries:]
+	}
+	return writeDocuments(jsonFilePath, data)
+}
+
+// profileDatabase obsługuje polecenie profile bazy danych.
+// Z parametrem level (off, slow, all) i opcjonalnym slowMs zmienia ustawienia profilera.
+// Bez niego zwraca ustawienia i wpisy system.profile, filtrowane zapytaniem z operatorami
+// w ciele żądania POST, z parametrami sort, order (domyślnie od najnowszych), skip i limit.
+func profileDatabase(w http.ResponseWriter, r *http.Request, dbName string) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if !utils.FileExists(utils.GetDatabasePath(config.DataDir, dbName)) {
+		http.Error(w, "Baza danych nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	settings, err := loadProfileSettings(dbName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać ustawień profilera: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	urlQuery := r.URL.Query()
+	if level := urlQuery.Get("level"); level != "" {
+		if level != profileOff && level != profileSlow && level != profileAll {
+			http.Error(w, "Parametr 'level' musi mieć wartość off, slow lub all", http.StatusBadRequest)
+			return
+		}
+		settings.Level = level
+		if value := urlQuery.Get("slowMs"); value != "" {
+			slowMs, err := strconv.Atoi(value)
+			if err != nil || slowMs < 0 {
+				http.Error(w, "Parametr 'slowMs' musi być nieujemną liczbą całkowitą", http.StatusBadRequest)
+				return
+			}
+			settings.SlowMs = slowMs
+		}
+
+		if err := saveProfileSettings(dbName, settings); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można zapisać ustawień profilera: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "setProfileLevel", dbName, "", map[string]interface{}{
+			"level": settings.Level, "slow_ms": settings.SlowMs,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "success",
+			"message":  fmt.Sprintf("Ustawiono poziom profilera bazy '%s' na %s", dbName, settings.Level),
+			"settings": settings,
+		})
+		return
+	}
+
+	query := map[string]interface{}{}
+	if r.Method == "POST" {
+		if err := decodeJSONBody(w, r, &query); err != nil {
+			writeBodyError(w, err, "Nieprawidłowy format JSON")
+			return
+		}
+	}
+	if !validateOperators(w, query) {
+		return
+	}
+
+	options := queryOptions{
+		Skip:  urlQuery.Get("skip"),
+		Limit: urlQuery.Get("limit"),
+	}
+	if options.Sort, err = parseSortSpec(urlQuery.Get("sort"), urlQuery.Get("order")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(options.Sort) == 0 {
+		options.Sort = sortSpec{{Field: "ts", Desc: true}}
+	}
+	if options.Limit == "" {
+		options.Limit = "100"
+	}
+
+	jsonFilePath := utils.GetCollectionPath(config.DataDir, dbName, profileCollection)
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	entries, _, err := runQuery(jsonFilePath, func(doc models.Document) bool {
+		return matchesQuery(doc, query)
+	}, options)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać wpisów profilera: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []models.Document{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"settings": settings,
+		"count":    len(entries),
+		"entries":  entries,
+	})
+}
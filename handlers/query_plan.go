@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"time"
+
+	"BaseDB/models"
+)
+
+// Plany wykonania zapytań
+const (
+	// planCollectionScan oznacza odczyt wszystkich dokumentów kolekcji i filtrowanie każdego z nich.
+	// Indeks id służy do pobierania dokumentów po id (polecenie get) oraz w poleceniach count,
+	// distinct i exists, gdy zapytanie dotyczy pola id.
+	planCollectionScan = "COLLSCAN"
+	// planIDIndex oznacza pobranie dokumentów wskazanych w zapytaniu po id z indeksu id.
+	planIDIndex = "IDINDEX"
+	// planCountMetadata oznacza odczyt liczby dokumentów z manifestu kolekcji segmentowanej.
+	planCountMetadata = "COUNT_METADATA"
+)
+
+// queryPhases to czasy faz wykonania zapytania w milisekundach
+type queryPhases struct {
+	ReadMs     float64 `json:"read_ms"`
+	FilterMs   float64 `json:"filter_ms"`
+	SortMs     float64 `json:"sort_ms"`
+	PaginateMs float64 `json:"paginate_ms"`
+	TotalMs    float64 `json:"total_ms"`
+}
+
+// queryStats opisuje wykonanie zapytania: wybrany plan, liczbę dokumentów i czasy faz
+type queryStats struct {
+	Plan         string      `json:"plan"`
+	Index        string      `json:"index,omitempty"`
+	Examined     int         `json:"documents_examined"`
+	Matched      int         `json:"documents_matched"`
+	Returned     int         `json:"documents_returned"`
+	Sort         string      `json:"sort,omitempty"`
+	InMemorySort bool        `json:"in_memory_sort"`
+	Phases       queryPhases `json:"phases"`
+}
+
+// queryOptions to parametry sortowania i paginacji zapytania
+type queryOptions struct {
+	Sort      sortSpec
+	Collation *collation // ustawienia porównywania napisów przy sortowaniu
+	Skip      string
+	Limit     string
+	Keyset    bool   // stronicowanie według klucza (parametr after)
+	After     string // pozycja, po której zaczyna się strona
+}
+
+// effectiveSort zwraca specyfikację sortowania zapytania. Przy stronicowaniu według klucza
+// bez pól sortowania wyniki są sortowane po id.
+func (o queryOptions) effectiveSort() sortSpec {
+	if len(o.Sort) == 0 && o.Keyset {
+		return sortSpec{{Field: "id"}}
+	}
+	return o.Sort
+}
+
+// runQuery wykonuje zapytanie na kolekcji: czyta dokumenty strumieniowo, filtruje je,
+// sortuje w pamięci i stosuje paginację, mierząc przy tym czas każdej fazy.
+// Przy stronicowaniu według klucza wyniki bez pola sortowania są sortowane po id.
+// Wywołujący musi trzymać blokadę kolekcji do odczytu.
+func runQuery(jsonFilePath string, match func(models.Document) bool, options queryOptions) ([]models.Document, queryStats, error) {
+	stats := queryStats{Plan: planCollectionScan}
+	var results []models.Document
+	var filterTime time.Duration
+
+	start := time.Now()
+	err := streamDocuments(jsonFilePath, func(doc models.Document) error {
+		filterStart := time.Now()
+		stats.Examined++
+		if match(doc) {
+			results = append(results, doc)
+		}
+		filterTime += time.Since(filterStart)
+		return nil
+	})
+	if err != nil {
+		return nil, stats, err
+	}
+	stats.Phases.ReadMs = milliseconds(time.Since(start) - filterTime)
+	stats.Phases.FilterMs = milliseconds(filterTime)
+	stats.Matched = len(results)
+
+	// Sortowanie wyników
+	if spec := options.effectiveSort(); len(spec) > 0 {
+		sortStart := time.Now()
+		sortResults(results, spec, options.Collation)
+		stats.Phases.SortMs = milliseconds(time.Since(sortStart))
+		stats.Sort = spec.String()
+		stats.InMemorySort = true
+	}
+
+	// Paginacja wyników
+	paginateStart := time.Now()
+	if options.Keyset {
+		results = applyKeyset(results, options)
+	}
+	results = paginateResults(results, options.Skip, options.Limit)
+	stats.Phases.PaginateMs = milliseconds(time.Since(paginateStart))
+
+	stats.Returned = len(results)
+	stats.Phases.TotalMs = milliseconds(time.Since(start))
+	return results, stats, nil
+}
+
+// milliseconds zamienia czas trwania na milisekundy
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// quotaFile to plik z limitami bazy danych, przechowywany w katalogu bazy
+const quotaFile = ".quota"
+
+// Kody błędów przekroczenia limitów bazy danych
+const (
+	errorQuotaDocuments = "QUOTA_MAX_DOCUMENTS"
+	errorQuotaBytes     = "QUOTA_MAX_BYTES"
+)
+
+// databaseQuota opisuje limity bazy danych; zero oznacza brak limitu
+type databaseQuota struct {
+	MaxDocuments int   `json:"max_documents"`
+	MaxBytes     int64 `json:"max_bytes"`
+}
+
+// quotaError opisuje przekroczenie limitu bazy danych
+type quotaError struct {
+	Code  string
+	Limit int64
+	Usage int64
+}
+
+// Error zwraca opis przekroczonego limitu
+func (e *quotaError) Error() string {
+	if e.Code == errorQuotaDocuments {
+		return fmt.Sprintf("Przekroczono limit dokumentów bazy danych (%d, po zapisie byłoby %d)", e.Limit, e.Usage)
+	}
+	return fmt.Sprintf("Przekroczono limit rozmiaru bazy danych (%d B, po zapisie byłoby %d B)", e.Limit, e.Usage)
+}
+
+// writeQuotaError odpowiada błędem przekroczenia limitu z kodem błędu
+func writeQuotaError(w http.ResponseWriter, err *quotaError) {
+	writeJSON(w, http.StatusInsufficientStorage, map[string]interface{}{
+		"status": "error",
+		"code":   err.Code,
+		"error":  err.Error(),
+		"limit":  err.Limit,
+		"usage":  err.Usage,
+	})
+}
+
+// loadQuota odczytuje limity bazy danych; brak pliku oznacza brak limitów
+func loadQuota(dbName string) (databaseQuota, error) {
+	var quota databaseQuota
+	path := filepath.Join(utils.GetDatabasePath(config.DataDir, dbName), quotaFile)
+	if !utils.FileExists(path) {
+		return quota, nil
+	}
+	err := utils.ReadJSONFile(path, &quota)
+	return quota, err
+}
+
+// saveQuota zapisuje limity bazy danych; limity zerowe usuwają plik
+func saveQuota(dbName string, quota databaseQuota) error {
+	path := filepath.Join(utils.GetDatabasePath(config.DataDir, dbName), quotaFile)
+	if quota.MaxDocuments == 0 && quota.MaxBytes == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return utils.WriteJSONFile(path, quota)
+}
+
+// documentCount to liczba dokumentów kolekcji zapamiętana dla stanu pliku kolekcji
+type documentCount struct {
+	count   int
+	modTime time.Time
+	size    int64
+}
+
+var (
+	documentCountsMu sync.Mutex
+	documentCounts   = map[string]documentCount{}
+)
+
+// cachedDocumentCount zwraca liczbę dokumentów kolekcji. Wynik jest zapamiętywany
+// i liczony ponownie dopiero po zmianie czasu modyfikacji lub rozmiaru pliku kolekcji.
+func cachedDocumentCount(jsonFilePath string) (int, error) {
+	info, err := os.Stat(jsonFilePath)
+	if err != nil {
+		return 0, err
+	}
+
+	documentCountsMu.Lock()
+	cached, ok := documentCounts[jsonFilePath]
+	documentCountsMu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.count, nil
+	}
+
+	count, err := countDocuments(jsonFilePath)
+	if err != nil {
+		return 0, err
+	}
+	documentCountsMu.Lock()
+	documentCounts[jsonFilePath] = documentCount{count: count, modTime: info.ModTime(), size: info.Size()}
+	documentCountsMu.Unlock()
+	return count, nil
+}
+
+// databaseUsage zwraca liczbę dokumentów i rozmiar plików bazy danych.
+// Pozostałe kolekcje są liczone bez blokad: zapisy podmieniają pliki atomowo,
+// więc odczyt widzi stan sprzed lub po zapisie. Liczby dokumentów niezmienionych
+// kolekcji pochodzą z pamięci, więc nie trzeba ich za każdym razem odczytywać.
+func databaseUsage(dbName string) (int, int64, error) {
+	dbPath := utils.GetDatabasePath(config.DataDir, dbName)
+	size, _, err := pathUsage(dbPath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	collections, err := utils.ListJSONFiles(dbPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	documents := 0
+	for _, collName := range collections {
+		count, err := cachedDocumentCount(utils.GetCollectionPath(config.DataDir, dbName, collName))
+		if err != nil {
+			return 0, 0, err
+		}
+		documents += count
+	}
+	return documents, size, nil
+}
+
+// quotaBudget śledzi, ile dokumentów i bajtów można jeszcze zapisać w bazie danych.
+// Wartość nil oznacza bazę bez limitów.
+type quotaBudget struct {
+	quota     databaseQuota
+	documents int
+	bytes     int64
+}
+
+// newQuotaBudget odczytuje limity i bieżące zużycie bazy danych.
+// Wywołujący powinien trzymać blokadę kolekcji, do której zapisuje.
+func newQuotaBudget(dbName string) (*quotaBudget, error) {
+	quota, err := loadQuota(dbName)
+	if err != nil {
+		return nil, err
+	}
+	if quota.MaxDocuments == 0 && quota.MaxBytes == 0 {
+		return nil, nil
+	}
+
+	documents, size, err := databaseUsage(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaBudget{quota: quota, documents: documents, bytes: size}, nil
+}
+
+// documentSize zwraca rozmiar dokumentu liczony jako długość jego zapisu JSON
+func documentSize(doc models.Document) int64 {
+	data, _ := json.Marshal(doc)
+	return int64(len(data))
+}
+
+// take rezerwuje miejsce na dokumenty lub zwraca błąd przekroczenia limitu.
+// Rozmiar dokumentu jest liczony jako długość jego zapisu JSON.
+func (b *quotaBudget) take(docs ...models.Document) *quotaError {
+	if b == nil {
+		return nil
+	}
+
+	var size int64
+	for _, doc := range docs {
+		size += documentSize(doc)
+	}
+
+	if b.quota.MaxDocuments > 0 && b.documents+len(docs) > b.quota.MaxDocuments {
+		return &quotaError{Code: errorQuotaDocuments, Limit: int64(b.quota.MaxDocuments), Usage: int64(b.documents + len(docs))}
+	}
+	if b.quota.MaxBytes > 0 && b.bytes+size > b.quota.MaxBytes {
+		return &quotaError{Code: errorQuotaBytes, Limit: b.quota.MaxBytes, Usage: b.bytes + size}
+	}
+
+	b.documents += len(docs)
+	b.bytes += size
+	return nil
+}
+
+// replace rezerwuje miejsce na nową wersję dokumentu lub zwraca błąd przekroczenia limitu
+// rozmiaru. Liczba dokumentów się nie zmienia, a zmniejszenie dokumentu zwalnia miejsce.
+func (b *quotaBudget) replace(oldDoc, newDoc models.Document) *quotaError {
+	if b == nil {
+		return nil
+	}
+
+	growth := documentSize(newDoc) - documentSize(oldDoc)
+	if growth > 0 && b.quota.MaxBytes > 0 && b.bytes+growth > b.quota.MaxBytes {
+		return &quotaError{Code: errorQuotaBytes, Limit: b.quota.MaxBytes, Usage: b.bytes + growth}
+	}
+
+	b.bytes += growth
+	return nil
+}
+
+// handleQuotaOperation obsługuje polecenia limitów bazy danych: setQuota, getQuota.
+// setQuota przyjmuje parametry maxDocuments i maxBytes; pominięty parametr nie zmienia limitu, 0 go usuwa.
+func handleQuotaOperation(w http.ResponseWriter, r *http.Request, dbName, command string) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if !utils.FileExists(utils.GetDatabasePath(config.DataDir, dbName)) {
+		http.Error(w, "Baza danych nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	quota, err := loadQuota(dbName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać limitów bazy danych: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	message := fmt.Sprintf("Limity bazy danych '%s'", dbName)
+	if command == "setQuota" {
+		query := r.URL.Query()
+		if value := query.Get("maxDocuments"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				http.Error(w, "Parametr 'maxDocuments' musi być nieujemną liczbą całkowitą", http.StatusBadRequest)
+				return
+			}
+			quota.MaxDocuments = parsed
+		}
+		if value := query.Get("maxBytes"); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed < 0 {
+				http.Error(w, "Parametr 'maxBytes' musi być nieujemną liczbą całkowitą", http.StatusBadRequest)
+				return
+			}
+			quota.MaxBytes = parsed
+		}
+
+		if err := saveQuota(dbName, quota); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można zapisać limitów bazy danych: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "setQuota", dbName, "", map[string]interface{}{
+			"max_documents": quota.MaxDocuments, "max_bytes": quota.MaxBytes,
+		})
+		message = fmt.Sprintf("Ustawiono limity bazy danych '%s'", dbName)
+	}
+
+	documents, size, err := databaseUsage(dbName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać zużycia bazy danych: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": message,
+		"quota":   quota,
+		"usage": map[string]interface{}{
+			"documents": documents,
+			"bytes":     size,
+		},
+	})
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"BaseDB/config"
+)
+
+// Klasy poleceń, dla których limity żądań są ustawiane osobno
+const (
+	classRead  = "read"
+	classWrite = "write"
+	classAdmin = "admin"
+)
+
+// errorRateLimited to kod błędu odpowiedzi 429
+const errorRateLimited = "RATE_LIMITED"
+
+// readCommands to polecenia API odczytujące dane
+var readCommands = map[string]bool{
+	"list": true, "get": true, "read": true, "find": true, "findOne": true, "findMany": true,
+	"count": true, "distinct": true, "exists": true, "getMore": true, "killCursors": true,
+	"export": true, "exportCsv": true, "watch": true,
+	"revisions": true, "revision": true, "diffRevisions": true,
+}
+
+// writeCommands to polecenia API zmieniające dane
+var writeCommands = map[string]bool{
+	"create": true, "delete": true, "rename": true,
+	"insertOne": true, "insertMany": true, "updateOne": true, "updateMany": true,
+	"import": true, "importCsv": true, "convertStorage": true,
+	"setHistory": true, "restoreRevision": true,
+}
+
+// adminCommands to polecenia administracyjne. Każde z nich wymaga klucza z uprawnieniem admin
+// (requireAdmin), a każde polecenie sprawdzające to uprawnienie musi być na tej liście.
+var adminCommands = map[string]bool{
+	"createApiKey": true, "listApiKeys": true, "deleteApiKey": true, "setPermissions": true,
+	"stats": true, "audit": true, "rotateKey": true, "backup": true, "restoreBackup": true,
+	"listTrash": true, "restoreTrash": true, "purge": true, "setEncryptedFields": true,
+	"addWebhook": true, "listWebhooks": true, "deleteWebhook": true,
+	"webhookDeliveries": true, "deadLetters": true, "redeliver": true,
+	"setQuota": true, "getQuota": true, "profile": true,
+}
+
+// commandClass zwraca klasę żądania: REST według metody HTTP, API według polecenia
+func commandClass(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/dbs") {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return classRead
+		}
+		return classWrite
+	}
+
+	command := r.URL.Query().Get("command")
+	switch {
+	case adminCommands[command]:
+		return classAdmin
+	case writeCommands[command]:
+		return classWrite
+	}
+	return classRead
+}
+
+// classRate zwraca dozwoloną liczbę żądań na sekundę dla klasy poleceń
+func classRate(class string) int {
+	switch class {
+	case classWrite:
+		return config.RateLimitWrite
+	case classAdmin:
+		return config.RateLimitAdmin
+	}
+	return config.RateLimitRead
+}
+
+// tokenBucket to kubełek żetonów uzupełniany w stałym tempie
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter przechowuje kubełki żetonów klientów dla każdej klasy poleceń
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+var limiter = &rateLimiter{buckets: map[string]*tokenBucket{}}
+
+// allow pobiera żeton z kubełka klienta. Jeśli kubełek jest pusty, zwraca czas
+// do pojawienia się kolejnego żetonu.
+func (rl *rateLimiter) allow(client, class string, now time.Time) (bool, time.Duration) {
+	rate := float64(classRate(class))
+	if rate <= 0 {
+		return true, 0
+	}
+	burst := math.Max(rate*float64(config.RateLimitBurstSeconds), 1)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.sweep(now)
+
+	key := class + "|" + client
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		rl.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+}
+
+// sweep co minutę usuwa kubełki klientów, którzy od dawna nie wysyłali żądań.
+// Taki kubełek zostałby i tak uzupełniony do pełna, więc jego usunięcie niczego nie zmienia.
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	idle := time.Duration(config.RateLimitBurstSeconds+1) * time.Second
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.last) > idle {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// rateLimitClient zwraca identyfikator klienta: id klucza API lub, bez klucza, adres IP
+func rateLimitClient(r *http.Request) string {
+	if key, err := authenticate(r); err == nil && key != nil {
+		return "key:" + key.ID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// RateLimit opakowuje handler API, ograniczając liczbę żądań klienta w każdej klasie poleceń
+func RateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		class := commandClass(r)
+		allowed, wait := limiter.allow(rateLimitClient(r), class, time.Now())
+		if !allowed {
+			seconds := int(math.Ceil(wait.Seconds()))
+			if seconds < 1 {
+				seconds = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+				"status":      "error",
+				"code":        errorRateLimited,
+				"error":       fmt.Sprintf("Przekroczono limit żądań klasy '%s', spróbuj ponownie za %d s", class, seconds),
+				"class":       class,
+				"retry_after": seconds,
+			})
+			return
+		}
+		next(w, r)
+	}
+}
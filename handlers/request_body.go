@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"BaseDB/config"
+)
+
+// Kody błędów odrzuconego ciała żądania
+const (
+	errorBodyTooLarge     = "BODY_TOO_LARGE"
+	errorDocumentTooLarge = "DOCUMENT_TOO_LARGE"
+	errorNestingTooDeep   = "NESTING_TOO_DEEP"
+	errorArrayTooLong     = "ARRAY_TOO_LONG"
+	errorTrailingData     = "TRAILING_DATA"
+)
+
+// bodyError opisuje ciało żądania odrzucone z powodu limitów lub danych po wartości JSON
+type bodyError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+// Error zwraca opis błędu
+func (e *bodyError) Error() string {
+	return e.Message
+}
+
+// decodeJSONBody odczytuje ciało żądania ograniczone do config.MaxBodyBytes, sprawdza limity
+// rozmiaru dokumentu, zagnieżdżenia i długości tablic, a następnie dekoduje je do v.
+// Ciało musi zawierać dokładnie jedną wartość JSON.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(config.MaxBodyBytes)))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &bodyError{
+				Status:  http.StatusRequestEntityTooLarge,
+				Code:    errorBodyTooLarge,
+				Message: fmt.Sprintf("Ciało żądania przekracza %d B", config.MaxBodyBytes),
+			}
+		}
+		return err
+	}
+
+	if err := checkJSONLimits(body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// writeBodyError odpowiada błędem dekodowania ciała żądania. Przekroczenie limitów
+// jest zgłaszane z kodem błędu, pozostałe błędy jako nieprawidłowy JSON z podanym opisem.
+func writeBodyError(w http.ResponseWriter, err error, message string) {
+	var limitErr *bodyError
+	if errors.As(err, &limitErr) {
+		writeJSON(w, limitErr.Status, map[string]interface{}{
+			"status": "error",
+			"code":   limitErr.Code,
+			"error":  limitErr.Message,
+		})
+		return
+	}
+	http.Error(w, fmt.Sprintf("%s: %v", message, err), http.StatusBadRequest)
+}
+
+// checkJSONLimits przegląda tokeny JSON bez budowania wartości i sprawdza głębokość
+// zagnieżdżenia, długość tablic oraz rozmiar dokumentów. Dokumentem jest obiekt
+// najwyższego poziomu lub obiekt będący elementem tablicy najwyższego poziomu.
+// Błędy składni są pomijane - zgłosi je dekodowanie wartości.
+func checkJSONLimits(data []byte) error {
+	type frame struct {
+		array    bool
+		length   int
+		document bool
+		start    int64
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	var stack []frame
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+
+		if n := len(stack); n > 0 && stack[n-1].array && token != json.Delim(']') {
+			stack[n-1].length++
+			if stack[n-1].length > config.MaxArrayLength {
+				return &bodyError{
+					Status:  http.StatusRequestEntityTooLarge,
+					Code:    errorArrayTooLong,
+					Message: fmt.Sprintf("Tablica JSON przekracza %d elementów", config.MaxArrayLength),
+				}
+			}
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			if len(stack) >= config.MaxNestingDepth {
+				return &bodyError{
+					Status:  http.StatusRequestEntityTooLarge,
+					Code:    errorNestingTooDeep,
+					Message: fmt.Sprintf("Zagnieżdżenie JSON przekracza %d poziomów", config.MaxNestingDepth),
+				}
+			}
+			document := token == json.Delim('{') && (len(stack) == 0 || (len(stack) == 1 && stack[0].array))
+			stack = append(stack, frame{array: token == json.Delim('['), document: document, start: start})
+
+		case json.Delim('}'), json.Delim(']'):
+			closed := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if closed.document && decoder.InputOffset()-closed.start > int64(config.MaxDocumentBytes) {
+				return &bodyError{
+					Status:  http.StatusRequestEntityTooLarge,
+					Code:    errorDocumentTooLarge,
+					Message: fmt.Sprintf("Dokument przekracza %d B", config.MaxDocumentBytes),
+				}
+			}
+		}
+
+		if len(stack) == 0 {
+			break
+		}
+	}
+
+	// Po wartości najwyższego poziomu dozwolone są tylko białe znaki
+	if _, err := decoder.Token(); err != io.EOF {
+		return &bodyError{
+			Status:  http.StatusBadRequest,
+			Code:    errorTrailingData,
+			Message: "Nieprawidłowy format JSON: dane po zakończeniu wartości JSON",
+		}
+	}
+	return nil
+}
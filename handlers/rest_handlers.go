@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// HandleREST obsługuje zasobowe API:
+//
+//	GET                 /dbs
+//	PUT, DELETE         /dbs/{db}
+//	GET, POST           /dbs/{db}/collections/{c}/documents
+//	GET, PATCH, PUT, DELETE /dbs/{db}/collections/{c}/documents/{id}
+func HandleREST(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIKey(w, r) {
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/dbs"), "/")
+	var segments []string
+	if path != "" {
+		segments = strings.Split(path, "/")
+	}
+
+	switch {
+	case len(segments) == 0:
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		listDatabases(w, r)
+	case len(segments) == 1:
+		handleDatabaseResource(w, r, segments[0])
+	case len(segments) == 4 && segments[1] == "collections" && segments[3] == "documents":
+		handleDocumentsResource(w, r, segments[0], segments[2])
+	case len(segments) == 5 && segments[1] == "collections" && segments[3] == "documents":
+		handleDocumentResource(w, r, segments[0], segments[2], segments[4])
+	default:
+		http.Error(w, "Nieprawidłowa ścieżka", http.StatusNotFound)
+	}
+}
+
+// handleDatabaseResource obsługuje /dbs/{db}
+func handleDatabaseResource(w http.ResponseWriter, r *http.Request, dbName string) {
+	dbPath := utils.GetDatabasePath(config.DataDir, dbName)
+
+	switch r.Method {
+	case http.MethodPut:
+		status := http.StatusCreated
+		if utils.FileExists(dbPath) {
+			status = http.StatusOK
+		}
+
+		if err := utils.EnsureDirectoryExists(dbPath); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można utworzyć bazy danych: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Location", "/dbs/"+dbName)
+		writeJSON(w, status, map[string]string{
+			"status":   "success",
+			"database": dbName,
+		})
+
+	case http.MethodDelete:
+		if !utils.FileExists(dbPath) {
+			http.Error(w, "Baza danych nie istnieje", http.StatusNotFound)
+			return
+		}
+
+		entry, err := moveDatabaseToTrash(dbName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Nie można usunąć bazy danych: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "deleteDatabase", dbName, "", map[string]interface{}{"trash_id": entry.ID})
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// handleDocumentsResource obsługuje /dbs/{db}/collections/{c}/documents
+func handleDocumentsResource(w http.ResponseWriter, r *http.Request, dbName, collName string) {
+	jsonFilePath := utils.GetCollectionPath(config.DataDir, dbName, collName)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		// Parametry URL działają jak w findMany
+		findManyDocuments(w, r, jsonFilePath, dbName, collName)
+		return
+	}
+
+	var newData models.Document
+	if err := decodeJSONBody(w, r, &newData); err != nil {
+		writeBodyError(w, err, "Nieprawidłowy format JSON")
+		return
+	}
+	if newData == nil {
+		http.Error(w, "Oczekiwano obiektu JSON", http.StatusBadRequest)
+		return
+	}
+
+	// Dodaj metadane (id, created_at, updated_at, _version)
+	newData = models.AddMetadata(newData)
+
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	data, err := readDocuments(jsonFilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	budget, err := newQuotaBudget(dbName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać limitów bazy danych: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := budget.take(newData); err != nil {
+		writeQuotaError(w, err)
+		return
+	}
+
+	data = append(data, newData)
+
+	if err := writeDocuments(jsonFilePath, data); err != nil {
+		http.Error(w, fmt.Sprintf("Nie można zapisać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+	publishChanges(insertEvent(dbName, collName, newData))
+
+	revealed, err := revealDocument(r, jsonFilePath, newData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", documentLocation(dbName, collName, documentID(newData)))
+	w.Header().Set("ETag", models.ETag(newData))
+	writeJSON(w, http.StatusCreated, revealed)
+}
+
+// handleDocumentResource obsługuje /dbs/{db}/collections/{c}/documents/{id}
+func handleDocumentResource(w http.ResponseWriter, r *http.Request, dbName, collName, docID string) {
+	jsonFilePath := utils.GetCollectionPath(config.DataDir, dbName, collName)
+
+	switch r.Method {
+	case http.MethodGet, http.MethodPatch, http.MethodPut, http.MethodDelete:
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPatch, http.MethodPut, http.MethodDelete)
+		return
+	}
+
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	var update models.Document
+	if r.Method == http.MethodPatch || r.Method == http.MethodPut {
+		if err := decodeJSONBody(w, r, &update); err != nil {
+			writeBodyError(w, err, "Nieprawidłowy format JSON")
+			return
+		}
+	}
+
+	lock := collectionLock(jsonFilePath)
+	if r.Method == http.MethodGet {
+		lock.RLock()
+		defer lock.RUnlock()
+	} else {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	data, err := readDocuments(jsonFilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Indeks id skraca tylko wyszukiwanie, kolekcja jest wczytywana w całości (zob. idIndex)
+	position := lookupIDIndex(jsonFilePath, data, docID)
+	if position < 0 {
+		http.Error(w, fmt.Sprintf("Nie znaleziono dokumentu o id: %s", docID), http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		revealed, err := revealDocument(r, jsonFilePath, data[position])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if checkIfNoneMatch(w, r, data[position]) {
+			writeJSON(w, http.StatusOK, revealed)
+		}
+		return
+	}
+
+	// Sprawdź oczekiwaną wersję (If-Match lub expectedVersion)
+	if !checkIfMatch(w, r, data[position]) {
+		return
+	}
+
+	previous := data[position]
+	var updated models.Document
+	switch r.Method {
+	case http.MethodPatch:
+		updated = mergeDocument(previous, update)
+	case http.MethodPut:
+		updated = replaceDocument(previous, update)
+	}
+
+	// Sprawdź limit rozmiaru bazy danych dla nowej wersji dokumentu
+	if updated != nil {
+		budget, err := newQuotaBudget(dbName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Nie można odczytać limitów bazy danych: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := budget.replace(previous, updated); err != nil {
+			writeQuotaError(w, err)
+			return
+		}
+	}
+
+	// Zachowaj poprzednią wersję w historii
+	if err := recordRevisions(dbName, collName, r.Method == http.MethodDelete, previous); err != nil {
+		http.Error(w, fmt.Sprintf("Nie można zapisać historii: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		data = append(data[:position], data[position+1:]...)
+	} else {
+		data[position] = updated
+	}
+
+	if err := writeDocuments(jsonFilePath, data); err != nil {
+		http.Error(w, fmt.Sprintf("Nie można zapisać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		publishChanges(deleteEvent(dbName, collName, previous))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	publishChanges(updateEvent(dbName, collName, previous, data[position]))
+
+	revealed, err := revealDocument(r, jsonFilePath, data[position])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", models.ETag(data[position]))
+	writeJSON(w, http.StatusOK, revealed)
+}
+
+// documentLocation zwraca adres zasobu dokumentu
+func documentLocation(dbName, collName, documentID string) string {
+	return fmt.Sprintf("/dbs/%s/collections/%s/documents/%s", dbName, collName, documentID)
+}
+
+// writeJSON zapisuje odpowiedź JSON z podanym kodem statusu
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// methodNotAllowed odpowiada kodem 405 wraz z listą dozwolonych metod
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, "Niedozwolona metoda", http.StatusMethodNotAllowed)
+}
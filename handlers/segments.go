@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// Układy plików kolekcji
+const (
+	layoutSingle    = "single"    // cała kolekcja jako jedna tablica JSON
+	layoutSegmented = "segmented" // manifest i katalog segmentów o ograniczonym rozmiarze
+)
+
+// segmentedFormat oznacza manifest kolekcji segmentowanej zapisany w pliku kolekcji
+const segmentedFormat = "basedb-segmented/1"
+
+// collectionManifest opisuje segmenty kolekcji segmentowanej w kolejności dokumentów
+type collectionManifest struct {
+	Format      string        `json:"format"`
+	Encoding    string        `json:"encoding,omitempty"`
+	Segments    []segmentInfo `json:"segments"`
+	NextSegment int           `json:"next_segment"`
+}
+
+// encoding zwraca kodowanie segmentów; starsze manifesty nie zapisują go i używają JSON
+func (m *collectionManifest) encoding() string {
+	if m.Encoding == "" {
+		return encodingJSON
+	}
+	return m.Encoding
+}
+
+// segmentInfo opisuje pojedynczy plik segmentu
+type segmentInfo struct {
+	File   string `json:"file"`
+	Count  int    `json:"count"`
+	Bytes  int    `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// encodedDocument to dokument zserializowany do zapisu w segmencie
+type encodedDocument struct {
+	id   string
+	data []byte
+}
+
+// segmentAssignments zapamiętuje, w którym segmencie leży dokument o danym id.
+// Dzięki temu zapis przepisuje tylko segmenty, których dokumenty się zmieniły.
+// Chroniona przez idIndexesMu.
+var segmentAssignments = map[string]map[string]string{}
+
+// segmentsDir zwraca katalog segmentów kolekcji
+func segmentsDir(jsonFilePath string) string {
+	collName := strings.TrimSuffix(filepath.Base(jsonFilePath), ".json")
+	return utils.GetCollectionSegmentsPath(filepath.Dir(jsonFilePath), "", collName)
+}
+
+// newCollectionManifest tworzy pusty manifest, kontynuując numerację segmentów poprzedniego
+func newCollectionManifest(previous *collectionManifest, encoding string) *collectionManifest {
+	manifest := &collectionManifest{Format: segmentedFormat, Encoding: encoding, Segments: []segmentInfo{}}
+	if previous != nil {
+		manifest.NextSegment = previous.NextSegment
+	}
+	return manifest
+}
+
+// readManifest odczytuje manifest kolekcji segmentowanej.
+// Zwraca nil, jeśli kolekcja jest zapisana jako pojedyncza tablica JSON.
+func readManifest(jsonFilePath string) (*collectionManifest, error) {
+	file, err := openDataFile(jsonFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	// Manifest jest obiektem JSON, kolekcja jednoplikowa - tablicą
+	reader := bufio.NewReader(file)
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b == ' ' || b == '\t' || b == '\n' || b == '\r' {
+			continue
+		}
+		if b != '{' {
+			return nil, nil
+		}
+		reader.UnreadByte()
+		break
+	}
+
+	var manifest collectionManifest
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Format != segmentedFormat {
+		return nil, fmt.Errorf("nieznany format kolekcji: %s", manifest.Format)
+	}
+	return &manifest, nil
+}
+
+// writeManifest atomowo zapisuje manifest w miejscu pliku kolekcji
+func writeManifest(jsonFilePath string, manifest *collectionManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeDataFile(jsonFilePath, data)
+}
+
+// writeFileAtomic zapisuje plik przez plik tymczasowy i zmianę nazwy
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	if err := file.Chmod(0644); err == nil {
+		_, err = file.Write(data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// readSegmentedDocuments odczytuje dokumenty ze wszystkich segmentów i zapamiętuje ich przydział do segmentów
+func readSegmentedDocuments(jsonFilePath string, manifest *collectionManifest) ([]models.Document, error) {
+	data := []models.Document{}
+	assignments := map[string]string{}
+
+	for _, segment := range manifest.Segments {
+		err := streamSegment(jsonFilePath, manifest, segment, func(doc models.Document) error {
+			if id := documentID(doc); id != "" {
+				assignments[id] = segment.File
+			}
+			data = append(data, doc)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	idIndexesMu.Lock()
+	segmentAssignments[jsonFilePath] = assignments
+	idIndexesMu.Unlock()
+	return data, nil
+}
+
+// streamSegment odczytuje kolejne dokumenty z jednego segmentu kolekcji.
+// Zawartość segmentu jest sprawdzana z sumą kontrolną z manifestu, zanim którykolwiek
+// dokument trafi do fn, więc uszkodzony lub obcięty segment nie zwraca części dokumentów.
+func streamSegment(jsonFilePath string, manifest *collectionManifest, segment segmentInfo, fn func(doc models.Document) error) error {
+	path := filepath.Join(segmentsDir(jsonFilePath), segment.File)
+
+	content, err := readDataFile(path)
+	if err == nil {
+		err = verifySegment(segment, content)
+	}
+	if err == nil {
+		count := 0
+		counted := func(doc models.Document) error {
+			count++
+			return fn(doc)
+		}
+		if manifest.encoding() == encodingBinary {
+			err = streamBinarySegment(bytes.NewReader(content), counted)
+		} else {
+			err = streamArray(bytes.NewReader(content), segment.File, counted)
+		}
+		if err == nil && count != segment.Count {
+			err = fmt.Errorf("segment zawiera %d dokumentów, a manifest podaje %d", count, segment.Count)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("segment %s: %v", segment.File, err)
+	}
+	return nil
+}
+
+// verifySegment sprawdza rozmiar i sumę kontrolną zawartości segmentu z wpisem manifestu
+func verifySegment(segment segmentInfo, content []byte) error {
+	if len(content) != segment.Bytes {
+		return fmt.Errorf("segment ma %d B, a manifest podaje %d B (plik uszkodzony lub obcięty)", len(content), segment.Bytes)
+	}
+	if sum := checksum(content); sum != segment.SHA256 {
+		return fmt.Errorf("suma kontrolna %s nie zgadza się z manifestem (%s), plik jest uszkodzony", sum, segment.SHA256)
+	}
+	return nil
+}
+
+// writeSegmentedDocuments zapisuje dokumenty kolekcji segmentowanej. Dokumenty trafiają do segmentów,
+// z których zostały odczytane, nowe dokumenty są dopisywane do ostatniego segmentu. Na dysk trafiają
+// tylko segmenty, których zawartość się zmieniła; pozostałe są przepisywane do nowego manifestu bez zmian.
+func writeSegmentedDocuments(jsonFilePath string, manifest *collectionManifest, data []models.Document) error {
+	idIndexesMu.Lock()
+	assignments := segmentAssignments[jsonFilePath]
+	idIndexesMu.Unlock()
+
+	known := map[string]bool{}
+	for _, segment := range manifest.Segments {
+		known[segment.File] = true
+	}
+
+	groups := map[string][]encodedDocument{}
+	var appended []encodedDocument
+	for _, doc := range data {
+		encoded, err := encodeDocument(doc, manifest.encoding())
+		if err != nil {
+			return err
+		}
+		entry := encodedDocument{id: documentID(doc), data: encoded}
+		if file, ok := assignments[entry.id]; ok && known[file] {
+			groups[file] = append(groups[file], entry)
+		} else {
+			appended = append(appended, entry)
+		}
+	}
+
+	if err := utils.EnsureDirectoryExists(segmentsDir(jsonFilePath)); err != nil {
+		return err
+	}
+
+	next := newCollectionManifest(manifest, manifest.Encoding)
+	newAssignments := map[string]string{}
+	fail := func(err error) error {
+		removeUnusedSegments(jsonFilePath, next, manifest)
+		return err
+	}
+
+	for i, segment := range manifest.Segments {
+		docs := groups[segment.File]
+
+		// Istniejące segmenty mogą urosnąć do podwójnego rozmiaru, zanim zostaną podzielone,
+		// żeby drobne aktualizacje nie przepisywały sąsiednich segmentów
+		threshold := 2 * config.SegmentMaxBytes
+		if i == len(manifest.Segments)-1 {
+			docs = append(docs, appended...)
+			appended = nil
+			threshold = config.SegmentMaxBytes
+		}
+		if len(docs) == 0 {
+			continue
+		}
+
+		chunks := splitSegment(docs, threshold)
+		for _, chunk := range chunks {
+			content, err := encodeSegment(chunk, next.encoding())
+			if err != nil {
+				return fail(err)
+			}
+			info := segment
+			if len(chunks) > 1 || checksum(content) != segment.SHA256 {
+				if info, err = writeSegmentFile(jsonFilePath, next, content, len(chunk)); err != nil {
+					return fail(err)
+				}
+			}
+			next.Segments = append(next.Segments, info)
+			assignSegment(newAssignments, chunk, info.File)
+		}
+	}
+
+	// Kolekcja bez segmentów: wszystkie dokumenty są nowe
+	for _, chunk := range splitSegment(appended, config.SegmentMaxBytes) {
+		content, err := encodeSegment(chunk, next.encoding())
+		if err != nil {
+			return fail(err)
+		}
+		info, err := writeSegmentFile(jsonFilePath, next, content, len(chunk))
+		if err != nil {
+			return fail(err)
+		}
+		next.Segments = append(next.Segments, info)
+		assignSegment(newAssignments, chunk, info.File)
+	}
+
+	if err := writeManifest(jsonFilePath, next); err != nil {
+		return fail(err)
+	}
+	removeUnusedSegments(jsonFilePath, manifest, next)
+
+	idIndexesMu.Lock()
+	segmentAssignments[jsonFilePath] = newAssignments
+	idIndexesMu.Unlock()
+	return nil
+}
+
+// splitSegment dzieli dokumenty na segmenty nie większe niż config.SegmentMaxBytes,
+// jeśli ich łączny rozmiar przekracza próg
+func splitSegment(docs []encodedDocument, threshold int) [][]encodedDocument {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, doc := range docs {
+		total += len(doc.data)
+	}
+	if total <= threshold {
+		return [][]encodedDocument{docs}
+	}
+
+	var chunks [][]encodedDocument
+	start, size := 0, 0
+	for i, doc := range docs {
+		if i > start && size+len(doc.data) > config.SegmentMaxBytes {
+			chunks = append(chunks, docs[start:i])
+			start, size = i, 0
+		}
+		size += len(doc.data)
+	}
+	return append(chunks, docs[start:])
+}
+
+// assignSegment zapamiętuje przydział dokumentów do segmentu
+func assignSegment(assignments map[string]string, docs []encodedDocument, file string) {
+	for _, doc := range docs {
+		if doc.id != "" {
+			assignments[doc.id] = file
+		}
+	}
+}
+
+// encodeSegment składa zserializowane dokumenty w zawartość pliku segmentu o podanym kodowaniu
+func encodeSegment(docs []encodedDocument, encoding string) ([]byte, error) {
+	if encoding == encodingBinary {
+		return encodeBinarySegment(docs)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, doc := range docs {
+		if i == 0 {
+			buf.WriteString("\n  ")
+		} else {
+			buf.WriteString(",\n  ")
+		}
+		buf.Write(doc.data)
+	}
+	if len(docs) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]")
+	return buf.Bytes(), nil
+}
+
+// writeSegment zapisuje nowy segment z dokumentów zserializowanych przez documentWriter
+func writeSegment(jsonFilePath string, manifest *collectionManifest, docs [][]byte) (segmentInfo, error) {
+	encoded := make([]encodedDocument, len(docs))
+	for i, data := range docs {
+		encoded[i] = encodedDocument{data: data}
+	}
+	content, err := encodeSegment(encoded, manifest.encoding())
+	if err != nil {
+		return segmentInfo{}, err
+	}
+	return writeSegmentFile(jsonFilePath, manifest, content, len(docs))
+}
+
+// writeSegmentFile zapisuje zawartość segmentu do nowego pliku o kolejnym numerze z manifestu
+func writeSegmentFile(jsonFilePath string, manifest *collectionManifest, content []byte, count int) (segmentInfo, error) {
+	info := segmentInfo{
+		File:   fmt.Sprintf("%06d%s", manifest.NextSegment, segmentExtension(manifest.encoding())),
+		Count:  count,
+		Bytes:  len(content),
+		SHA256: checksum(content),
+	}
+	manifest.NextSegment++
+
+	path := filepath.Join(segmentsDir(jsonFilePath), info.File)
+	if err := writeDataFile(path, content); err != nil {
+		os.Remove(path)
+		return info, err
+	}
+	return info, nil
+}
+
+// checksum zwraca sumę SHA-256 zawartości w postaci szesnastkowej
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// removeUnusedSegments usuwa pliki segmentów z manifestu previous, których nie ma w manifeście current.
+// Jeśli kolekcja nie jest już segmentowana, usuwa też pusty katalog segmentów.
+func removeUnusedSegments(jsonFilePath string, previous, current *collectionManifest) {
+	if previous == nil {
+		return
+	}
+
+	used := map[string]bool{}
+	if current != nil {
+		for _, segment := range current.Segments {
+			used[segment.File] = true
+		}
+	}
+
+	dir := segmentsDir(jsonFilePath)
+	for _, segment := range previous.Segments {
+		if !used[segment.File] {
+			os.Remove(filepath.Join(dir, segment.File))
+		}
+	}
+	if current == nil {
+		os.Remove(dir)
+		os.Remove(filepath.Dir(dir))
+	}
+}
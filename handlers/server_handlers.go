@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleServerOperation obsługuje polecenia dotyczące całego serwera
+func handleServerOperation(w http.ResponseWriter, r *http.Request, command string) {
+	switch command {
+	case "listTrash", "restoreTrash", "purge":
+		handleTrashOperation(w, r, command)
+	case "restoreBackup":
+		if !requireAdmin(w, r) {
+			return
+		}
+		restoreBackup(w, r, "")
+	case "backup":
+		if !requireAdmin(w, r) {
+			return
+		}
+		databases, err := listDatabaseNames()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Błąd odczytu katalogu: %v", err), http.StatusInternalServerError)
+			return
+		}
+		backupDatabases(w, r, databases)
+	case "createApiKey", "listApiKeys", "deleteApiKey", "setPermissions":
+		handleAuthOperation(w, r, command)
+	case "stats":
+		serverStats(w, r)
+	case "audit":
+		queryAudit(w, r)
+	case "rotateKey":
+		if !requireAdmin(w, r) {
+			return
+		}
+		databases, err := listDatabaseNames()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Błąd odczytu katalogu: %v", err), http.StatusInternalServerError)
+			return
+		}
+		rotateKeys(w, r, databases)
+	default:
+		http.Error(w, "Nieznana operacja serwera", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"BaseDB/models"
+)
+
+// sortKey to pole sortowania z kierunkiem
+type sortKey struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"-"`
+}
+
+// sortSpec to lista pól sortowania w kolejności ważności
+type sortSpec []sortKey
+
+// String zwraca specyfikację sortowania w postaci parametru sort, np. "age:desc,name:asc"
+func (s sortSpec) String() string {
+	parts := make([]string, len(s))
+	for i, key := range s {
+		order := "asc"
+		if key.Desc {
+			order = "desc"
+		}
+		parts[i] = key.Field + ":" + order
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseSortOrder zamienia kierunek sortowania (asc, desc, 1, -1) na wartość Desc
+func parseSortOrder(order, field string) (bool, error) {
+	switch strings.ToLower(order) {
+	case "", "asc", "1":
+		return false, nil
+	case "desc", "-1":
+		return true, nil
+	}
+	return false, fmt.Errorf("Nieprawidłowy kierunek sortowania '%s' dla pola '%s' (dozwolone: asc, desc)", order, field)
+}
+
+// parseSortSpec odczytuje specyfikację sortowania: napis "age:desc,name:asc", tablicę JSON
+// (w napisie lub w ciele zapytania) z elementami "age:desc" lub {"field": "age", "order": "desc"}.
+// Pola bez kierunku są sortowane w kierunku defaultOrder (parametr order).
+func parseSortSpec(value interface{}, defaultOrder string) (sortSpec, error) {
+	if text, ok := value.(string); ok && strings.HasPrefix(strings.TrimSpace(text), "[") {
+		var items []interface{}
+		if err := json.Unmarshal([]byte(text), &items); err != nil {
+			return nil, fmt.Errorf("Nieprawidłowa specyfikacja sortowania: %v", err)
+		}
+		value = items
+	}
+
+	var items []interface{}
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				items = append(items, part)
+			}
+		}
+	case []interface{}:
+		items = v
+	default:
+		return nil, fmt.Errorf("Specyfikacja sortowania musi być napisem lub tablicą")
+	}
+
+	spec := make(sortSpec, 0, len(items))
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		var field, order string
+		switch v := item.(type) {
+		case string:
+			field, order = v, defaultOrder
+			if separator := strings.LastIndex(v, ":"); separator >= 0 {
+				field, order = v[:separator], v[separator+1:]
+			}
+		case map[string]interface{}:
+			field, _ = v["field"].(string)
+			order, _ = v["order"].(string)
+			if order == "" {
+				order = defaultOrder
+			}
+		default:
+			return nil, fmt.Errorf("Nieprawidłowy element specyfikacji sortowania: %v", item)
+		}
+
+		field = strings.TrimSpace(field)
+		if field == "" {
+			return nil, fmt.Errorf("Pole sortowania nie może być puste")
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("Pole sortowania '%s' występuje więcej niż raz", field)
+		}
+		seen[field] = true
+
+		desc, err := parseSortOrder(strings.TrimSpace(order), field)
+		if err != nil {
+			return nil, err
+		}
+		spec = append(spec, sortKey{Field: field, Desc: desc})
+	}
+	return spec, nil
+}
+
+// takeBodyQueryOptions przenosi z ciała zapytania find klucze $sort i $collation
+// do opcji zapytania. Wartości z ciała zastępują parametry URL.
+func takeBodyQueryOptions(query map[string]interface{}, options *queryOptions) error {
+	if value, ok := query["$sort"]; ok {
+		delete(query, "$sort")
+		spec, err := parseSortSpec(value, "")
+		if err != nil {
+			return err
+		}
+		options.Sort = spec
+	}
+	if value, ok := query["$collation"]; ok {
+		delete(query, "$collation")
+		c, err := parseCollation(value)
+		if err != nil {
+			return err
+		}
+		options.Collation = c
+	}
+	if options.Keyset {
+		if _, err := parseKeysetAfter(options.After, options.Sort); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Porządek typów wartości przy sortowaniu
+const (
+	sortRankNull = iota // null i brak pola
+	sortRankNumber
+	sortRankString
+	sortRankObject
+	sortRankArray
+	sortRankBool
+	sortRankDate // napisy w formacie daty oraz napisy w polach czasowych (created_at itp.)
+)
+
+// sortValue to wartość pola sortowania z ustalonym typem
+type sortValue struct {
+	value interface{}
+	rank  int
+	time  time.Time
+}
+
+// newSortValue ustala typ wartości pola sortowania. Napis jest datą, jeśli wygląda jak data
+// albo pole jest polem czasowym i napis daje się odczytać jako czas.
+func newSortValue(value interface{}, field string) sortValue {
+	switch v := value.(type) {
+	case nil:
+		return sortValue{rank: sortRankNull}
+	case float64, float32, int, int64:
+		number, _ := toFloat64(v)
+		return sortValue{value: number, rank: sortRankNumber}
+	case string:
+		if isTimeValue(v) || isTimeField(field) {
+			if t, ok := parseTime(v); ok {
+				return sortValue{value: v, rank: sortRankDate, time: t}
+			}
+		}
+		return sortValue{value: v, rank: sortRankString}
+	case bool:
+		return sortValue{value: v, rank: sortRankBool}
+	case []interface{}:
+		return sortValue{value: v, rank: sortRankArray}
+	case models.Document:
+		return sortValue{value: map[string]interface{}(v), rank: sortRankObject}
+	case map[string]interface{}:
+		return sortValue{value: v, rank: sortRankObject}
+	}
+	return sortValue{value: fmt.Sprintf("%v", value), rank: sortRankString}
+}
+
+// compareSortValues porównuje rosnąco dwie wartości pola sortowania.
+// Wartości różnych typów są uporządkowane: null < liczby < napisy < obiekty < tablice < bool < daty.
+func compareSortValues(a, b sortValue, c *collation) int {
+	if a.rank != b.rank {
+		return compareInts(a.rank, b.rank)
+	}
+
+	switch a.rank {
+	case sortRankNumber:
+		x, y := a.value.(float64), b.value.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case sortRankString:
+		return c.compare(a.value.(string), b.value.(string))
+	case sortRankDate:
+		return a.time.Compare(b.time)
+	case sortRankBool:
+		// false przed true
+		x, y := a.value.(bool), b.value.(bool)
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	case sortRankArray:
+		x, y := a.value.([]interface{}), b.value.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if r := compareSortValues(newSortValue(x[i], ""), newSortValue(y[i], ""), c); r != 0 {
+				return r
+			}
+		}
+		return compareInts(len(x), len(y))
+	case sortRankObject:
+		// Obiekty są porównywane pole po polu w kolejności nazw pól
+		x, y := a.value.(map[string]interface{}), b.value.(map[string]interface{})
+		keysX, keysY := sortedKeys(x), sortedKeys(y)
+		for i := 0; i < len(keysX) && i < len(keysY); i++ {
+			if r := strings.Compare(keysX[i], keysY[i]); r != 0 {
+				return r
+			}
+			if r := compareSortValues(newSortValue(x[keysX[i]], keysX[i]), newSortValue(y[keysY[i]], keysY[i]), c); r != 0 {
+				return r
+			}
+		}
+		return compareInts(len(keysX), len(keysY))
+	}
+	return 0
+}
+
+// sortedKeys zwraca posortowane nazwy pól obiektu
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// documentSortValues zwraca wartości pól sortowania dokumentu. Pola mogą być ścieżkami
+// z kropkami, a brakujące pole jest traktowane jak null.
+func documentSortValues(doc models.Document, spec sortSpec) []sortValue {
+	values := make([]sortValue, len(spec))
+	for i, key := range spec {
+		value, _ := lookupPath(doc, key.Field)
+		values[i] = newSortValue(value, key.Field)
+	}
+	return values
+}
+
+// compareSortKeys porównuje wartości pól sortowania dwóch dokumentów według specyfikacji
+func compareSortKeys(a, b []sortValue, spec sortSpec, c *collation) int {
+	for i, key := range spec {
+		r := compareSortValues(a[i], b[i], c)
+		if key.Desc {
+			r = -r
+		}
+		if r != 0 {
+			return r
+		}
+	}
+	return 0
+}
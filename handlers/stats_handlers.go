@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+var (
+	// serverStarted to czas uruchomienia serwera
+	serverStarted = time.Now()
+
+	// storageReady jest ustawiane po wczytaniu kluczy i uruchomieniu procesów w tle
+	storageReady atomic.Bool
+)
+
+// MarkReady oznacza, że serwer zakończył inicjalizację i może obsługiwać żądania
+func MarkReady() {
+	storageReady.Store(true)
+}
+
+// requestStats zlicza obsłużone żądania według polecenia i klasy kodu odpowiedzi
+type requestStats struct {
+	mu        sync.Mutex
+	total     int64
+	byCommand map[string]int64
+	byStatus  map[string]int64
+}
+
+var requests = &requestStats{byCommand: map[string]int64{}, byStatus: map[string]int64{}}
+
+// record zapisuje obsłużone żądanie
+func (rs *requestStats) record(command string, status int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.total++
+	rs.byCommand[command]++
+	rs.byStatus[fmt.Sprintf("%dxx", status/100)]++
+}
+
+// snapshot zwraca kopię liczników
+func (rs *requestStats) snapshot() map[string]interface{} {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	byCommand := make(map[string]int64, len(rs.byCommand))
+	for k, v := range rs.byCommand {
+		byCommand[k] = v
+	}
+	byStatus := make(map[string]int64, len(rs.byStatus))
+	for k, v := range rs.byStatus {
+		byStatus[k] = v
+	}
+	return map[string]interface{}{
+		"total":      rs.total,
+		"by_command": byCommand,
+		"by_status":  byStatus,
+	}
+}
+
+// statusRecorder zapamiętuje kod odpowiedzi i liczbę wysłanych bajtów
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader zapamiętuje kod odpowiedzi
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// Write zlicza wysłane bajty
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// Flush przekazuje opróżnienie bufora, potrzebne przy strumieniowaniu odpowiedzi
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap zwraca opakowany ResponseWriter (dla http.ResponseController)
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// unknownCommand opisuje w statystykach nieznane polecenia i metody HTTP,
+// aby dowolne wartości podane przez klienta nie tworzyły nowych serii metryk
+const unknownCommand = "unknown"
+
+// requestCommand zwraca nazwę polecenia żądania używaną w statystykach.
+// Żądania REST i żądania API bez polecenia są opisywane metodą HTTP.
+func requestCommand(r *http.Request) string {
+	method := r.Method
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		method = unknownCommand
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/dbs") {
+		return "rest:" + method
+	}
+	if command := r.URL.Query().Get("command"); command != "" {
+		if readCommands[command] || writeCommands[command] || adminCommands[command] {
+			return command
+		}
+		return unknownCommand
+	}
+	return method
+}
+
+// requestTarget zwraca bazę i kolekcję, których dotyczy żądanie API lub REST
+func requestTarget(r *http.Request) (string, string) {
+	var segments []string
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/database/"):
+		segments = strings.Split(strings.TrimPrefix(r.URL.Path, "/api/database/"), "/")
+	case strings.HasPrefix(r.URL.Path, "/dbs/"):
+		// /dbs/{db}/collections/{c}/...
+		segments = strings.Split(strings.TrimPrefix(r.URL.Path, "/dbs/"), "/")
+		if len(segments) > 2 {
+			segments = []string{segments[0], segments[2]}
+		} else {
+			segments = segments[:1]
+		}
+	}
+
+	var dbName, collName string
+	if len(segments) > 0 {
+		dbName = segments[0]
+	}
+	if len(segments) > 1 {
+		collName = segments[1]
+	}
+	return dbName, collName
+}
+
+// Instrument opakowuje handler API, zliczając obsłużone żądania, mierząc czas ich obsługi
+// i zapisując każde żądanie w logu dostępu
+func Instrument(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		duration := time.Since(start)
+
+		command := requestCommand(r)
+		requests.record(command, recorder.status)
+		metrics.observeRequest(command, recorder.status, duration)
+
+		dbName, collName := requestTarget(r)
+		slog.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"db", dbName,
+			"collection", collName,
+			"command", command,
+			"status", recorder.status,
+			"duration_ms", float64(duration.Microseconds())/1000,
+			"bytes", recorder.bytes,
+			"user", requestUser(r),
+			"remote_addr", r.RemoteAddr,
+		)
+	}
+}
+
+// HandleHealth odpowiada, że proces działa
+func HandleHealth(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "ok",
+		"uptime_seconds": int64(time.Since(serverStarted).Seconds()),
+	})
+}
+
+// HandleReady sprawdza, czy serwer może obsługiwać żądania: katalog danych jest zapisywalny,
+// a klucze szyfrowania i procesy w tle zostały zainicjalizowane
+func HandleReady(w http.ResponseWriter, _ *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	if storageReady.Load() {
+		checks["storage"] = "ok"
+	} else {
+		checks["storage"] = "inicjalizacja w toku"
+		ready = false
+	}
+
+	if file, err := os.CreateTemp(config.DataDir, ".readyz-"); err != nil {
+		checks["data_dir"] = fmt.Sprintf("katalog danych nie jest zapisywalny: %v", err)
+		ready = false
+	} else {
+		file.Close()
+		os.Remove(file.Name())
+		checks["data_dir"] = "ok"
+	}
+
+	if (config.MasterKey != "" || config.MasterKeyFile != "") && !encryptionEnabled() {
+		checks["encryption"] = "klucz główny nie został wczytany"
+		ready = false
+	} else {
+		checks["encryption"] = "ok"
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// collectionStats opisuje rozmiar i stan kolekcji
+type collectionStats struct {
+	Name         string `json:"name"`
+	Documents    int    `json:"documents"`
+	Layout       string `json:"layout"`
+	DataBytes    int64  `json:"data_bytes"`
+	SideBytes    int64  `json:"side_bytes"`
+	IndexEntries int    `json:"index_entries"`
+	IndexBytes   int64  `json:"index_bytes"`
+	LastWrite    string `json:"last_write,omitempty"`
+}
+
+// databaseStats opisuje rozmiar i stan bazy danych
+type databaseStats struct {
+	Name        string            `json:"name"`
+	Documents   int               `json:"documents"`
+	Bytes       int64             `json:"bytes"`
+	LastWrite   string            `json:"last_write,omitempty"`
+	Collections []collectionStats `json:"collections"`
+}
+
+// serverStats zwraca statystyki wszystkich baz danych lub jednej bazy (parametr 'database')
+func serverStats(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	dbNames, err := listDatabaseNames()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Błąd odczytu katalogu: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if only := r.URL.Query().Get("database"); only != "" {
+		if !utils.FileExists(utils.GetDatabasePath(config.DataDir, only)) {
+			http.Error(w, "Baza danych nie istnieje", http.StatusNotFound)
+			return
+		}
+		dbNames = []string{only}
+	}
+
+	databases := []databaseStats{}
+	totalDocuments := 0
+	var totalBytes int64
+	for _, dbName := range dbNames {
+		stats, err := statDatabase(dbName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Błąd odczytu statystyk bazy '%s': %v", dbName, err), http.StatusInternalServerError)
+			return
+		}
+		databases = append(databases, stats)
+		totalDocuments += stats.Documents
+		totalBytes += stats.Bytes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "success",
+		"started_at":     serverStarted.UTC().Format(time.RFC3339),
+		"uptime_seconds": int64(time.Since(serverStarted).Seconds()),
+		"documents":      totalDocuments,
+		"bytes":          totalBytes,
+		"databases":      databases,
+		"requests":       requests.snapshot(),
+	})
+}
+
+// statDatabase zbiera statystyki bazy danych i jej kolekcji
+func statDatabase(dbName string) (databaseStats, error) {
+	dbPath := utils.GetDatabasePath(config.DataDir, dbName)
+	stats := databaseStats{Name: dbName, Collections: []collectionStats{}}
+
+	size, lastWrite, err := pathUsage(dbPath)
+	if err != nil {
+		return stats, err
+	}
+	stats.Bytes = size
+	stats.LastWrite = formatWriteTime(lastWrite)
+
+	collections, err := utils.ListJSONFiles(dbPath)
+	if err != nil {
+		return stats, err
+	}
+	sort.Strings(collections)
+
+	for _, collName := range collections {
+		coll, err := statCollection(dbName, collName)
+		if err != nil {
+			return stats, fmt.Errorf("kolekcja '%s': %v", collName, err)
+		}
+		stats.Collections = append(stats.Collections, coll)
+		stats.Documents += coll.Documents
+	}
+	return stats, nil
+}
+
+// statCollection zbiera statystyki kolekcji
+func statCollection(dbName, collName string) (collectionStats, error) {
+	jsonFilePath := utils.GetCollectionPath(config.DataDir, dbName, collName)
+	stats := collectionStats{Name: collName, Layout: layoutSingle}
+
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	manifest, err := readManifest(jsonFilePath)
+	if err != nil {
+		return stats, err
+	}
+	if manifest != nil {
+		stats.Layout = layoutSegmented
+	}
+	if stats.Documents, err = countDocuments(jsonFilePath); err != nil {
+		return stats, err
+	}
+
+	dataBytes, lastWrite, err := pathUsage(jsonFilePath)
+	if err != nil {
+		return stats, err
+	}
+	sideFiles := collectionSideFiles(dbName, collName)
+	for _, path := range sideFiles {
+		size, modTime, err := pathUsage(path)
+		if err != nil {
+			return stats, err
+		}
+
+		// Segmenty są danymi kolekcji, pozostałe pliki - ustawieniami, historią i dziennikami
+		if path == utils.GetCollectionSegmentsPath(config.DataDir, dbName, collName) {
+			dataBytes += size
+			if modTime.After(lastWrite) {
+				lastWrite = modTime
+			}
+		} else {
+			stats.SideBytes += size
+		}
+	}
+	stats.DataBytes = dataBytes
+	stats.LastWrite = formatWriteTime(lastWrite)
+
+	// Indeks id jest budowany w pamięci przy pierwszym wyszukaniu po id.
+	// Rozmiar to przybliżenie: klucze oraz pozycja i narzut mapy na wpis.
+	idIndexesMu.Lock()
+	if index := idIndexes[jsonFilePath]; index != nil {
+		stats.IndexEntries = len(index.positions)
+		for id := range index.positions {
+			stats.IndexBytes += int64(len(id)) + 24
+		}
+	}
+	idIndexesMu.Unlock()
+
+	return stats, nil
+}
+
+// countDocuments zwraca liczbę dokumentów kolekcji. Liczba dokumentów kolekcji segmentowanej
+// pochodzi z manifestu, kolekcja jednoplikowa jest odczytywana strumieniowo.
+func countDocuments(jsonFilePath string) (int, error) {
+	manifest, err := readManifest(jsonFilePath)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	if manifest != nil {
+		for _, segment := range manifest.Segments {
+			count += segment.Count
+		}
+		return count, nil
+	}
+	err = streamDocuments(jsonFilePath, func(models.Document) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// pathUsage zwraca łączny rozmiar pliku lub katalogu i czas ostatniej zmiany
+func pathUsage(root string) (int64, time.Time, error) {
+	var size int64
+	var lastWrite time.Time
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+			if info.ModTime().After(lastWrite) {
+				lastWrite = info.ModTime()
+			}
+		}
+		return nil
+	})
+	return size, lastWrite, err
+}
+
+// formatWriteTime formatuje czas ostatniego zapisu lub zwraca pusty napis
+func formatWriteTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// idIndex przechowuje mapowanie id dokumentu na jego pozycję w pliku kolekcji.
+//
+// Indeks trzyma tylko pozycje, a nie zdekodowane dokumenty: odczyt po id nadal wczytuje
+// i dekoduje całą kolekcję przez readDocuments, a indeks oszczędza jedynie przeszukiwanie
+// dokumentów w pamięci. Indeks jest unieważniany jawnie przez każdą ścieżkę, która zmienia
+// pliki kolekcji (dropIDIndex, dropIDIndexesWithPrefix, moveIDIndex); zmiany plików
+// wprowadzone poza serwerem nie są wykrywane do czasu jego ponownego uruchomienia.
+type idIndex struct {
+	positions map[string]int
+}
+
+var (
+	idIndexesMu sync.Mutex
+	idIndexes   = map[string]*idIndex{}
+)
+
+// readDocuments odczytuje wszystkie dokumenty kolekcji niezależnie od układu plików
+func readDocuments(jsonFilePath string) ([]models.Document, error) {
+	manifest, err := readManifest(jsonFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		return readSegmentedDocuments(jsonFilePath, manifest)
+	}
+
+	var data []models.Document
+	if err := readJSONDataFile(jsonFilePath, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeDocuments zapisuje dokumenty kolekcji i odświeża indeks id
+func writeDocuments(jsonFilePath string, data []models.Document) error {
+	if data == nil {
+		data = []models.Document{}
+	}
+
+	// Pola szyfrowane kolekcji trafiają na dysk tylko w postaci zaszyfrowanej
+	if err := sealDocuments(jsonFilePath, data); err != nil {
+		return err
+	}
+
+	manifest, err := readManifest(jsonFilePath)
+	if err == nil {
+		if manifest != nil {
+			err = writeSegmentedDocuments(jsonFilePath, manifest, data)
+		} else {
+			err = writeJSONDataFile(jsonFilePath, data)
+		}
+	}
+	if err != nil {
+		dropIDIndex(jsonFilePath)
+		return err
+	}
+
+	buildIDIndex(jsonFilePath, data)
+	return nil
+}
+
+// streamDocuments odczytuje dokumenty kolekcji jeden po drugim, bez wczytywania całej kolekcji do pamięci.
+// Kolekcje segmentowane są czytane segment po segmencie.
+func streamDocuments(jsonFilePath string, fn func(doc models.Document) error) error {
+	manifest, err := readManifest(jsonFilePath)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return streamArrayFile(jsonFilePath, fn)
+	}
+
+	for _, segment := range manifest.Segments {
+		if err := streamSegment(jsonFilePath, manifest, segment, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamArrayFile odczytuje kolejne dokumenty z pliku zawierającego tablicę JSON
+func streamArrayFile(path string, fn func(doc models.Document) error) error {
+	file, err := openDataFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	return streamArray(file, filepath.Base(path), fn)
+}
+
+// streamArray odczytuje kolejne dokumenty z tablicy JSON; name opisuje źródło w komunikatach błędów
+func streamArray(r io.Reader, name string, fn func(doc models.Document) error) error {
+	decoder := json.NewDecoder(r)
+
+	// Pusty plik traktujemy jak pustą kolekcję
+	token, err := decoder.Token()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("plik %s nie zawiera tablicy JSON", name)
+	}
+
+	for decoder.More() {
+		var doc models.Document
+		if err := decoder.Decode(&doc); err != nil {
+			return err
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+
+	_, err = decoder.Token()
+	return err
+}
+
+// documentWriter zapisuje kolekcję strumieniowo od nowa. Nowa zawartość zastępuje
+// dotychczasową dopiero po zatwierdzeniu, więc przerwany zapis nie psuje kolekcji.
+type documentWriter struct {
+	jsonFilePath string
+	layout       string
+	oldManifest  *collectionManifest
+	fields       *fieldCipher
+	count        int
+
+	// Układ jednoplikowy: plik tymczasowy z tablicą JSON, szyfrowany w razie potrzeby
+	file *os.File
+	enc  io.WriteCloser
+	buf  *bufio.Writer
+
+	// Układ segmentowany: bieżący segment i nowy manifest
+	manifest *collectionManifest
+	chunk    [][]byte
+	size     int
+}
+
+// newDocumentWriter rozpoczyna strumieniowy zapis kolekcji w jej obecnym układzie
+func newDocumentWriter(jsonFilePath string) (*documentWriter, error) {
+	manifest, err := readManifest(jsonFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest != nil {
+		return newDocumentWriterWithLayout(jsonFilePath, layoutSegmented, manifest.encoding())
+	}
+	return newDocumentWriterWithLayout(jsonFilePath, layoutSingle, encodingJSON)
+}
+
+// newDocumentWriterWithLayout rozpoczyna strumieniowy zapis kolekcji w podanym układzie i kodowaniu.
+// Kodowanie binarne jest dostępne tylko w układzie segmentowanym.
+func newDocumentWriterWithLayout(jsonFilePath, layout, encoding string) (*documentWriter, error) {
+	oldManifest, err := readManifest(jsonFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := fieldCipherFor(jsonFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	dw := &documentWriter{jsonFilePath: jsonFilePath, layout: layout, oldManifest: oldManifest, fields: fields}
+
+	if layout == layoutSegmented {
+		dw.manifest = newCollectionManifest(oldManifest, encoding)
+		return dw, utils.EnsureDirectoryExists(segmentsDir(jsonFilePath))
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(jsonFilePath), "."+filepath.Base(jsonFilePath)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+
+	dw.file = file
+	if err := file.Chmod(0644); err != nil {
+		dw.Abort()
+		return nil, err
+	}
+	if dw.enc, err = newDataWriter(file, jsonFilePath); err != nil {
+		dw.Abort()
+		return nil, err
+	}
+	dw.buf = bufio.NewWriter(dw.enc)
+	if _, err := dw.buf.WriteString("["); err != nil {
+		dw.Abort()
+		return nil, err
+	}
+	return dw, nil
+}
+
+// Write dopisuje dokument do kolekcji
+func (dw *documentWriter) Write(doc models.Document) error {
+	if dw.fields != nil {
+		if err := dw.fields.sealDocument(doc); err != nil {
+			return err
+		}
+	}
+
+	if dw.layout == layoutSegmented {
+		data, err := encodeDocument(doc, dw.manifest.encoding())
+		if err != nil {
+			return err
+		}
+		if len(dw.chunk) > 0 && dw.size+len(data) > config.SegmentMaxBytes {
+			if err := dw.flushSegment(); err != nil {
+				return err
+			}
+		}
+		dw.chunk = append(dw.chunk, data)
+		dw.size += len(data)
+		dw.count++
+		return nil
+	}
+
+	data, err := json.MarshalIndent(doc, "  ", "  ")
+	if err != nil {
+		return err
+	}
+
+	separator := ",\n  "
+	if dw.count == 0 {
+		separator = "\n  "
+	}
+	if _, err := dw.buf.WriteString(separator); err != nil {
+		return err
+	}
+	if _, err := dw.buf.Write(data); err != nil {
+		return err
+	}
+
+	dw.count++
+	return nil
+}
+
+// flushSegment zapisuje bieżący segment do nowego pliku
+func (dw *documentWriter) flushSegment() error {
+	if len(dw.chunk) == 0 {
+		return nil
+	}
+
+	segment, err := writeSegment(dw.jsonFilePath, dw.manifest, dw.chunk)
+	if err != nil {
+		return err
+	}
+	dw.manifest.Segments = append(dw.manifest.Segments, segment)
+	dw.chunk = nil
+	dw.size = 0
+	return nil
+}
+
+// Commit kończy zapis i podmienia zawartość kolekcji
+func (dw *documentWriter) Commit() error {
+	if dw.layout == layoutSegmented {
+		if err := dw.flushSegment(); err != nil {
+			dw.Abort()
+			return err
+		}
+		if err := writeManifest(dw.jsonFilePath, dw.manifest); err != nil {
+			dw.Abort()
+			return err
+		}
+	} else {
+		closing := "\n]"
+		if dw.count == 0 {
+			closing = "]"
+		}
+		if _, err := dw.buf.WriteString(closing); err != nil {
+			dw.Abort()
+			return err
+		}
+		if err := dw.buf.Flush(); err != nil {
+			dw.Abort()
+			return err
+		}
+		if err := dw.enc.Close(); err != nil {
+			dw.Abort()
+			return err
+		}
+		if err := dw.file.Close(); err != nil {
+			os.Remove(dw.file.Name())
+			return err
+		}
+		if err := os.Rename(dw.file.Name(), dw.jsonFilePath); err != nil {
+			os.Remove(dw.file.Name())
+			return err
+		}
+	}
+
+	// Usuń segmenty poprzedniej wersji kolekcji
+	removeUnusedSegments(dw.jsonFilePath, dw.oldManifest, dw.manifest)
+
+	// Pozycje dokumentów mogły się zmienić, indeks zostanie zbudowany przy następnym odczycie
+	dropIDIndex(dw.jsonFilePath)
+	return nil
+}
+
+// Abort przerywa zapis i usuwa pliki tymczasowe
+func (dw *documentWriter) Abort() {
+	if dw.layout == layoutSegmented {
+		removeUnusedSegments(dw.jsonFilePath, dw.manifest, dw.oldManifest)
+		return
+	}
+	dw.file.Close()
+	os.Remove(dw.file.Name())
+}
+
+// documentID zwraca id dokumentu jako string
+func documentID(doc models.Document) string {
+	id, ok := doc["id"]
+	if !ok || id == nil {
+		return ""
+	}
+	if s, ok := id.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", id)
+}
+
+// buildIDIndex buduje indeks id na podstawie dokumentów zapisanych w pliku
+func buildIDIndex(jsonFilePath string, data []models.Document) *idIndex {
+	index := &idIndex{positions: make(map[string]int, len(data))}
+	for i, doc := range data {
+		if id := documentID(doc); id != "" {
+			if _, exists := index.positions[id]; !exists {
+				index.positions[id] = i
+			}
+		}
+	}
+
+	idIndexesMu.Lock()
+	idIndexes[jsonFilePath] = index
+	idIndexesMu.Unlock()
+	return index
+}
+
+// dropIDIndex usuwa indeks id kolekcji
+func dropIDIndex(jsonFilePath string) {
+	idIndexesMu.Lock()
+	delete(idIndexes, jsonFilePath)
+	delete(segmentAssignments, jsonFilePath)
+	idIndexesMu.Unlock()
+}
+
+// dropIDIndexesWithPrefix usuwa indeksy wszystkich kolekcji w danym katalogu
+func dropIDIndexesWithPrefix(dirPath string) {
+	prefix := strings.TrimSuffix(dirPath, string(os.PathSeparator)) + string(os.PathSeparator)
+	idIndexesMu.Lock()
+	for path := range idIndexes {
+		if strings.HasPrefix(path, prefix) {
+			delete(idIndexes, path)
+		}
+	}
+	for path := range segmentAssignments {
+		if strings.HasPrefix(path, prefix) {
+			delete(segmentAssignments, path)
+		}
+	}
+	idIndexesMu.Unlock()
+}
+
+// moveIDIndex przenosi indeks id po zmianie nazwy kolekcji
+func moveIDIndex(oldPath, newPath string) {
+	idIndexesMu.Lock()
+	if index, ok := idIndexes[oldPath]; ok {
+		idIndexes[newPath] = index
+		delete(idIndexes, oldPath)
+	}
+	delete(segmentAssignments, oldPath)
+	delete(segmentAssignments, newPath)
+	idIndexesMu.Unlock()
+}
+
+// lookupIDIndex zwraca pozycję dokumentu o podanym id w data, budując indeks przy pierwszym wyszukaniu.
+// data musi pochodzić z readDocuments wywołanego pod blokadą kolekcji.
+func lookupIDIndex(jsonFilePath string, data []models.Document, id string) int {
+	idIndexesMu.Lock()
+	index := idIndexes[jsonFilePath]
+	idIndexesMu.Unlock()
+
+	if index == nil {
+		index = buildIDIndex(jsonFilePath, data)
+	}
+
+	position, ok := index.positions[id]
+	if ok && position < len(data) && documentID(data[position]) == id {
+		return position
+	}
+
+	// Indeks nie zgadza się z danymi, zbuduj go od nowa
+	if ok {
+		index = buildIDIndex(jsonFilePath, data)
+		if position, ok = index.positions[id]; ok {
+			return position
+		}
+	}
+	return -1
+}
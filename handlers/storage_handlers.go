@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// convertStorage zmienia układ plików lub kodowanie kolekcji.
+// Parametry:
+//
+//	layout=single|segmented   jedna tablica JSON lub manifest z segmentami o ograniczonym rozmiarze
+//	encoding=json|binary      segmenty JSON lub skompresowane rekordy binarne (tylko układ segmentowany)
+//
+// Pominięty parametr zachowuje obecne ustawienie kolekcji; encoding=binary wymusza układ segmentowany.
+func convertStorage(w http.ResponseWriter, r *http.Request, jsonFilePath string) {
+	query := r.URL.Query()
+	layout := query.Get("layout")
+	encoding := query.Get("encoding")
+
+	if layout != "" && layout != layoutSingle && layout != layoutSegmented {
+		http.Error(w, "Parametr 'layout' musi mieć wartość 'single' lub 'segmented'", http.StatusBadRequest)
+		return
+	}
+	if encoding != "" && encoding != encodingJSON && encoding != encodingBinary {
+		http.Error(w, "Parametr 'encoding' musi mieć wartość 'json' lub 'binary'", http.StatusBadRequest)
+		return
+	}
+	if layout == "" && encoding == "" {
+		http.Error(w, "Podaj parametr 'layout' lub 'encoding'", http.StatusBadRequest)
+		return
+	}
+	if layout == layoutSingle && encoding == encodingBinary {
+		http.Error(w, "Kodowanie binarne wymaga układu 'segmented'", http.StatusBadRequest)
+		return
+	}
+
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	manifest, err := readManifest(jsonFilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać pliku JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	currentLayout, currentEncoding := layoutSingle, encodingJSON
+	if manifest != nil {
+		currentLayout, currentEncoding = layoutSegmented, manifest.encoding()
+	}
+	if layout == "" {
+		layout = currentLayout
+		if encoding == encodingBinary {
+			layout = layoutSegmented
+		}
+	}
+	if encoding == "" {
+		encoding = encodingJSON
+		if layout == layoutSegmented && currentLayout == layoutSegmented {
+			encoding = currentEncoding
+		}
+	}
+
+	message := fmt.Sprintf("Kolekcja ma już układ '%s' i kodowanie '%s'", layout, encoding)
+	if layout != currentLayout || encoding != currentEncoding {
+		writer, err := newDocumentWriterWithLayout(jsonFilePath, layout, encoding)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Nie można przygotować konwersji: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := streamDocuments(jsonFilePath, writer.Write); err != nil {
+			writer.Abort()
+			http.Error(w, fmt.Sprintf("Błąd konwersji kolekcji: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := writer.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można zapisać pliku JSON: %v", err), http.StatusInternalServerError)
+			return
+		}
+		message = fmt.Sprintf("Zmieniono układ kolekcji na '%s' i kodowanie na '%s'", layout, encoding)
+	}
+
+	response := map[string]interface{}{
+		"status":   "success",
+		"message":  message,
+		"layout":   layout,
+		"encoding": encoding,
+	}
+	if manifest, err = readManifest(jsonFilePath); err == nil && manifest != nil {
+		count := 0
+		for _, segment := range manifest.Segments {
+			count += segment.Count
+		}
+		response["documents"] = count
+		response["segments"] = manifest.Segments
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeDocumentArray wysyła dokumenty kolekcji jako jedną tablicę JSON, czytając je strumieniowo
+func writeDocumentArray(w io.Writer, jsonFilePath string, revealer *fieldRevealer) error {
+	buf := bufio.NewWriter(w)
+	buf.WriteString("[")
+	count := 0
+	err := streamDocuments(jsonFilePath, func(doc models.Document) error {
+		doc, err := revealer.reveal(doc)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(doc, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			buf.WriteString("\n  ")
+		} else {
+			buf.WriteString(",\n  ")
+		}
+		count++
+		_, err = buf.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]")
+	return buf.Flush()
+}
@@ -0,0 +1,427 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// maxReportedImportErrors ogranicza liczbę błędów zwracanych w podsumowaniu importu
+const maxReportedImportErrors = 100
+
+// importError opisuje wiersz, którego nie udało się zaimportować
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importSummary zlicza wynik importu
+type importSummary struct {
+	Processed int           `json:"processed"`
+	Inserted  int           `json:"inserted"`
+	Skipped   int           `json:"skipped"`
+	Errors    []importError `json:"errors"`
+}
+
+// addError zapisuje błąd wiersza w podsumowaniu
+func (s *importSummary) addError(line int, err error) {
+	s.Skipped++
+	if len(s.Errors) < maxReportedImportErrors {
+		s.Errors = append(s.Errors, importError{Line: line, Error: err.Error()})
+	}
+}
+
+// exportCollection wysyła dokumenty kolekcji jako NDJSON, po jednym dokumencie w wierszu
+func exportCollection(w http.ResponseWriter, r *http.Request, jsonFilePath string) {
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	lock := collectionLock(jsonFilePath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	revealer := newFieldRevealer(r, jsonFilePath)
+	count := 0
+
+	err := streamDocuments(jsonFilePath, func(doc models.Document) error {
+		doc, err := revealer.reveal(doc)
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(doc); err != nil {
+			return err
+		}
+		count++
+		if flusher != nil && count%1000 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	// Nagłówki zostały już wysłane, więc błąd można zgłosić tylko w treści
+	if err != nil {
+		encoder.Encode(map[string]string{
+			"status": "error",
+			"error":  fmt.Sprintf("Przerwano eksport: %v", err),
+		})
+	}
+}
+
+// importCollection dodaje do kolekcji dokumenty przesłane jako NDJSON.
+// Parametry:
+//
+//	ids=keep|regenerate     zachowaj id z pliku (domyślnie) lub nadaj nowe
+//	onError=skip|abort      pomiń błędne wiersze (domyślnie) lub przerwij import
+//	progress=N              co N wierszy wysyłaj postęp (odpowiedź w formacie NDJSON)
+func importCollection(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName string) {
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje. Użyj 'createCollection' aby ją utworzyć", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Wymagana metoda POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	regenerateIDs := query.Get("ids") == "regenerate"
+	abortOnError := query.Get("onError") == "abort"
+	progressEvery := 0
+	if progress := query.Get("progress"); progress != "" {
+		parsed, err := strconv.Atoi(progress)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Parametr 'progress' musi być dodatnią liczbą całkowitą", http.StatusBadRequest)
+			return
+		}
+		progressEvery = parsed
+	}
+
+	// Dane są najpierw odkładane do pliku tymczasowego, a kolekcja jest blokowana dopiero
+	// na czas scalania, aby wolne przesyłanie nie wstrzymywało innych zapisów
+	stage, err := newImportStage(jsonFilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można przygotować importu: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer stage.remove()
+
+	summary := importSummary{Errors: []importError{}}
+	var encoder *json.Encoder
+	if progressEvery > 0 {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder = json.NewEncoder(w)
+	}
+	flusher, _ := w.(http.Flusher)
+
+	scanner := newImportScanner(r.Body)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		summary.Processed++
+
+		doc, err := parseImportLine(raw)
+		if err == nil {
+			err = stage.add(line, doc)
+		}
+		if err != nil {
+			summary.addError(line, err)
+			if abortOnError {
+				reportImportFailure(w, encoder, &summary, http.StatusBadRequest, fmt.Errorf("Import przerwany w wierszu %d: %v", line, err))
+				return
+			}
+		}
+
+		if encoder != nil && summary.Processed%progressEvery == 0 {
+			encoder.Encode(map[string]interface{}{"progress": summary})
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			reportImportFailure(w, encoder, &summary, http.StatusRequestEntityTooLarge, importRecordTooLong(line+1))
+			return
+		}
+		reportImportFailure(w, encoder, &summary, http.StatusBadRequest, fmt.Errorf("Błąd odczytu danych: %v", err))
+		return
+	}
+
+	if status, err := mergeImport(jsonFilePath, dbName, stage, &summary, regenerateIDs, abortOnError); err != nil {
+		reportImportFailure(w, encoder, &summary, status, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Zaimportowano %d dokumentów", summary.Inserted),
+		"summary": summary,
+	}
+
+	if encoder == nil {
+		w.Header().Set("Content-Type", "application/json")
+		encoder = json.NewEncoder(w)
+	}
+	encoder.Encode(response)
+}
+
+// newImportScanner zwraca skaner wierszy NDJSON, który nie buforuje wiersza dłuższego
+// niż config.MaxDocumentBytes, tylko kończy odczyt błędem bufio.ErrTooLong
+func newImportScanner(r io.Reader) *bufio.Scanner {
+	// Bufor mieści też znak nowego wiersza kończący najdłuższy dopuszczalny dokument
+	maxLine := config.MaxDocumentBytes + 1
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(64<<10, maxLine)), maxLine)
+	return scanner
+}
+
+// importRecordTooLong zwraca błąd wiersza importu przekraczającego config.MaxDocumentBytes
+func importRecordTooLong(line int) error {
+	return &bodyError{
+		Status:  http.StatusRequestEntityTooLarge,
+		Code:    errorDocumentTooLarge,
+		Message: fmt.Sprintf("Wiersz %d przekracza %d B", line, config.MaxDocumentBytes),
+	}
+}
+
+// beginImport rozpoczyna zapis kolekcji, przepisując istniejące dokumenty
+// i zapamiętując tylko ich id. Wywołujący musi trzymać blokadę kolekcji.
+func beginImport(jsonFilePath string) (*documentWriter, map[string]bool, error) {
+	writer, err := newDocumentWriter(jsonFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	existingIDs := map[string]bool{}
+	err = streamDocuments(jsonFilePath, func(doc models.Document) error {
+		if id := documentID(doc); id != "" {
+			existingIDs[id] = true
+		}
+		return writer.Write(doc)
+	})
+	if err != nil {
+		writer.Abort()
+		return nil, nil, err
+	}
+
+	return writer, existingIDs, nil
+}
+
+// parseImportLine dekoduje jeden wiersz NDJSON
+func parseImportLine(raw []byte) (models.Document, error) {
+	if err := checkJSONLimits(raw); err != nil {
+		return nil, err
+	}
+
+	var doc models.Document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("nieprawidłowy JSON: %v", err)
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("oczekiwano obiektu JSON")
+	}
+	return doc, nil
+}
+
+// stagedDocument to dokument importu odłożony do pliku tymczasowego wraz z numerem wiersza
+type stagedDocument struct {
+	Line     int             `json:"line"`
+	Document models.Document `json:"document"`
+}
+
+// importStage to plik tymczasowy z dokumentami odczytanymi z ciała żądania importu.
+// Leży w katalogu bazy i jest szyfrowany jej kluczem danych, jak sama kolekcja.
+type importStage struct {
+	jsonFilePath string
+	file         *os.File
+	buffer       *bufio.Writer
+	writer       io.WriteCloser
+	encoder      *json.Encoder
+}
+
+// newImportStage tworzy plik tymczasowy importu do kolekcji
+func newImportStage(jsonFilePath string) (*importStage, error) {
+	file, err := os.CreateTemp(filepath.Dir(jsonFilePath), "."+filepath.Base(jsonFilePath)+".import-")
+	if err != nil {
+		return nil, err
+	}
+
+	stage := &importStage{jsonFilePath: jsonFilePath, file: file, buffer: bufio.NewWriter(file)}
+	if stage.writer, err = newDataWriter(stage.buffer, jsonFilePath); err != nil {
+		stage.remove()
+		return nil, err
+	}
+	stage.encoder = json.NewEncoder(stage.writer)
+	return stage, nil
+}
+
+// add odkłada dokument z podanego wiersza do pliku tymczasowego
+func (s *importStage) add(line int, doc models.Document) error {
+	return s.encoder.Encode(stagedDocument{Line: line, Document: doc})
+}
+
+// each kończy zapis pliku tymczasowego i przekazuje odłożone dokumenty w kolejności wierszy
+func (s *importStage) each(fn func(line int, doc models.Document) error) error {
+	if err := s.writer.Close(); err != nil {
+		return err
+	}
+	if err := s.buffer.Flush(); err != nil {
+		return err
+	}
+
+	reader, err := openDataFile(s.file.Name())
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	decoder := json.NewDecoder(reader)
+	for {
+		var staged stagedDocument
+		if err := decoder.Decode(&staged); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(staged.Line, staged.Document); err != nil {
+			return err
+		}
+	}
+}
+
+// remove usuwa plik tymczasowy importu
+func (s *importStage) remove() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// mergeImport dopisuje odłożone dokumenty do kolekcji, blokując ją tylko na czas scalania
+// i zamiany pliku. Zwraca status HTTP i błąd, jeśli import trzeba przerwać.
+func mergeImport(jsonFilePath, dbName string, stage *importStage, summary *importSummary, regenerateIDs, abortOnError bool) (int, error) {
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Błędy scalania dołączają do błędów odczytu, więc podsumowanie porządkuje je według wierszy
+	defer func() {
+		sort.SliceStable(summary.Errors, func(i, j int) bool {
+			return summary.Errors[i].Line < summary.Errors[j].Line
+		})
+	}()
+
+	// Kolekcja mogła zostać usunięta w trakcie przesyłania danych
+	if !utils.FileExists(jsonFilePath) {
+		return http.StatusNotFound, fmt.Errorf("Kolekcja nie istnieje")
+	}
+
+	budget, err := newQuotaBudget(dbName)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Nie można odczytać limitów bazy danych: %v", err)
+	}
+
+	writer, existingIDs, err := beginImport(jsonFilePath)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Nie można przygotować importu: %v", err)
+	}
+
+	batch := newChangeBatch(jsonFilePath)
+	var status int
+	var abortErr error
+	err = stage.each(func(line int, doc models.Document) error {
+		if err := importDocument(writer, batch, budget, doc, regenerateIDs, existingIDs); err != nil {
+			summary.addError(line, err)
+			if quotaErr, ok := err.(*quotaError); ok {
+				// Przekroczenie limitu przerywa import niezależnie od onError
+				status, abortErr = http.StatusInsufficientStorage, quotaErr
+			} else if abortOnError {
+				status, abortErr = http.StatusBadRequest, fmt.Errorf("Import przerwany w wierszu %d: %v", line, err)
+			}
+			return abortErr
+		}
+		summary.Inserted++
+		return nil
+	})
+	if abortErr != nil {
+		writer.Abort()
+		return status, abortErr
+	}
+	if err != nil {
+		writer.Abort()
+		return http.StatusInternalServerError, fmt.Errorf("Nie można odczytać pliku tymczasowego importu: %v", err)
+	}
+
+	if err := writer.Commit(); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Nie można zapisać pliku JSON: %v", err)
+	}
+	batch.publish()
+	return http.StatusOK, nil
+}
+
+// importDocument dodaje metadane do dokumentu i dopisuje go do kolekcji, odrzucając powtórzone id
+// i dokumenty przekraczające limity bazy danych.
+// Zdarzenie dodania trafia do batch i jest publikowane po zatwierdzeniu importu.
+func importDocument(writer *documentWriter, batch *changeBatch, budget *quotaBudget, doc models.Document, regenerateIDs bool, existingIDs map[string]bool) error {
+	if regenerateIDs {
+		delete(doc, "id")
+	}
+	doc = models.AddImportMetadata(doc)
+
+	id := documentID(doc)
+	if existingIDs[id] {
+		return fmt.Errorf("dokument o id '%s' już istnieje", id)
+	}
+	if err := budget.take(doc); err != nil {
+		return err
+	}
+	if err := writer.Write(doc); err != nil {
+		return err
+	}
+	existingIDs[id] = true
+	batch.insert(doc)
+	return nil
+}
+
+// reportImportFailure zgłasza nieudany import. Jeśli postęp był już wysyłany,
+// błąd trafia do strumienia NDJSON, bo nagłówki zostały już wysłane.
+func reportImportFailure(w http.ResponseWriter, encoder *json.Encoder, summary *importSummary, status int, err error) {
+	if encoder == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		encoder = json.NewEncoder(w)
+	}
+	response := map[string]interface{}{
+		"status":  "error",
+		"error":   err.Error(),
+		"summary": summary,
+	}
+	var limitErr *bodyError
+	if quotaErr, ok := err.(*quotaError); ok {
+		response["code"] = quotaErr.Code
+	} else if errors.As(err, &limitErr) {
+		response["code"] = limitErr.Code
+	}
+	encoder.Encode(response)
+}
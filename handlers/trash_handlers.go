@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+// trashEntry opisuje element przeniesiony do kosza
+type trashEntry struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"` // "database" lub "collection"
+	Database   string `json:"database"`
+	Collection string `json:"collection,omitempty"`
+	DeletedAt  string `json:"deleted_at"`
+}
+
+const (
+	trashManifestFile = "trash.json"
+	trashDataDir      = "data"
+)
+
+// trashMu chroni istniejące elementy kosza przed równoczesnym przywracaniem, usuwaniem
+// i ponownym szyfrowaniem przy rotacji kluczy
+var trashMu sync.Mutex
+
+// moveDatabaseToTrash przenosi katalog bazy danych do kosza, blokując na ten czas
+// zapis do wszystkich jej kolekcji
+func moveDatabaseToTrash(dbName string) (trashEntry, error) {
+	entry := newTrashEntry("database", dbName, "")
+	entryPath := filepath.Join(config.TrashDir, entry.ID)
+
+	unlock, err := lockDatabaseForWrite(dbName)
+	if err != nil {
+		return entry, err
+	}
+	defer unlock()
+
+	if err := createTrashEntry(entryPath, entry); err != nil {
+		return entry, err
+	}
+
+	dbPath := utils.GetDatabasePath(config.DataDir, dbName)
+	if err := os.Rename(dbPath, filepath.Join(entryPath, trashDataDir)); err != nil {
+		os.RemoveAll(entryPath)
+		return entry, err
+	}
+	dropIDIndexesWithPrefix(dbPath)
+	publishChanges(changeEvent{Type: changeDrop, Database: dbName})
+
+	return entry, nil
+}
+
+// moveCollectionToTrash przenosi plik kolekcji wraz z plikami pomocniczymi do kosza,
+// razem z kopią pęku kluczy bazy. Wywołujący musi trzymać blokadę kolekcji.
+func moveCollectionToTrash(dbName, collName string) (trashEntry, error) {
+	entry := newTrashEntry("collection", dbName, collName)
+	entryPath := filepath.Join(config.TrashDir, entry.ID)
+	trashedDB := filepath.Join(entryPath, trashDataDir)
+
+	jsonFilePath := utils.GetCollectionPath(config.DataDir, dbName, collName)
+	sources := append([]string{jsonFilePath}, collectionSideFiles(dbName, collName)...)
+	targets := append([]string{utils.GetCollectionPath(trashedDB, "", collName)}, collectionSideFilesIn(trashedDB, collName)...)
+
+	if err := createTrashEntry(entryPath, entry); err != nil {
+		return entry, err
+	}
+
+	// Kopia pęku kluczy pozwala przywrócić kolekcję także po rotacji kluczy bazy
+	if err := snapshotKeyRing(utils.GetDatabasePath(config.DataDir, dbName), trashedDB); err != nil {
+		os.RemoveAll(entryPath)
+		return entry, err
+	}
+
+	// Po błędzie przeniesione już pliki wracają na miejsce, a element kosza jest usuwany
+	var moved []int
+	for i, source := range sources {
+		if !utils.FileExists(source) {
+			continue
+		}
+		err := utils.EnsureDirectoryExists(filepath.Dir(targets[i]))
+		if err == nil {
+			err = os.Rename(source, targets[i])
+		}
+		if err != nil {
+			for j := len(moved) - 1; j >= 0; j-- {
+				os.Rename(targets[moved[j]], sources[moved[j]])
+			}
+			os.RemoveAll(entryPath)
+			return entry, err
+		}
+		moved = append(moved, i)
+	}
+	dropIDIndex(jsonFilePath)
+	publishChanges(changeEvent{Type: changeDrop, Database: dbName, Collection: collName})
+
+	return entry, nil
+}
+
+// createTrashEntry tworzy katalog elementu kosza i zapisuje jego opis przed przeniesieniem
+// danych, aby przeniesione pliki zawsze były widoczne w koszu
+func createTrashEntry(entryPath string, entry trashEntry) error {
+	if err := utils.EnsureDirectoryExists(entryPath); err != nil {
+		return err
+	}
+	if err := utils.WriteJSONFile(filepath.Join(entryPath, trashManifestFile), entry); err != nil {
+		os.RemoveAll(entryPath)
+		return err
+	}
+	return nil
+}
+
+// newTrashEntry tworzy opis nowego elementu kosza
+func newTrashEntry(entryType, dbName, collName string) trashEntry {
+	return trashEntry{
+		ID:         uuid.New().String(),
+		Type:       entryType,
+		Database:   dbName,
+		Collection: collName,
+		DeletedAt:  models.GetCurrentTimestamp(),
+	}
+}
+
+// listTrashEntries zwraca elementy kosza posortowane od najnowszych
+func listTrashEntries() ([]trashEntry, error) {
+	entries := []trashEntry{}
+
+	dirs, err := os.ReadDir(config.TrashDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		var entry trashEntry
+		if err := utils.ReadJSONFile(filepath.Join(config.TrashDir, dir.Name(), trashManifestFile), &entry); err != nil || entry.ID == "" {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt > entries[j].DeletedAt
+	})
+	return entries, nil
+}
+
+// findTrashEntry wyszukuje element kosza po id
+func findTrashEntry(id string) (trashEntry, bool) {
+	var entry trashEntry
+	if id == "" || filepath.Base(id) != id {
+		return entry, false
+	}
+	err := utils.ReadJSONFile(filepath.Join(config.TrashDir, id, trashManifestFile), &entry)
+	return entry, err == nil && entry.ID == id
+}
+
+// handleTrashOperation obsługuje polecenia kosza: listTrash, restoreTrash, purge
+func handleTrashOperation(w http.ResponseWriter, r *http.Request, command string) {
+	query := r.URL.Query()
+
+	switch command {
+	case "listTrash":
+		if !requireAdmin(w, r) {
+			return
+		}
+		entries, err := listTrashEntries()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Błąd odczytu kosza: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":         "success",
+			"count":          len(entries),
+			"retention_days": config.TrashRetentionDays,
+			"trash":          entries,
+		})
+
+	case "restoreTrash":
+		if !requireAdmin(w, r) || !requireTrashMethod(w, r) {
+			return
+		}
+		if newName := query.Get("newName"); newName != "" && !utils.IsValidName(newName) {
+			http.Error(w, fmt.Sprintf("Nieprawidłowa nazwa '%s'", newName), http.StatusBadRequest)
+			return
+		}
+		trashMu.Lock()
+		defer trashMu.Unlock()
+
+		entry, ok := findTrashEntry(query.Get("id"))
+		if !ok {
+			http.Error(w, "Nie znaleziono elementu w koszu", http.StatusNotFound)
+			return
+		}
+
+		restoredName, status, err := restoreTrashEntry(entry, query.Get("newName"))
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		recordAudit(r, "restoreTrash", entry.Database, entry.Collection, map[string]interface{}{
+			"trash_id": entry.ID, "type": entry.Type, "restored_as": restoredName,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "success",
+			"message": fmt.Sprintf("Przywrócono '%s' z kosza", restoredName),
+		})
+
+	case "purge":
+		if !requireAdmin(w, r) || !requireTrashMethod(w, r) {
+			return
+		}
+		var purged []string
+		switch {
+		case query.Get("id") != "":
+			trashMu.Lock()
+			defer trashMu.Unlock()
+
+			entry, ok := findTrashEntry(query.Get("id"))
+			if !ok {
+				http.Error(w, "Nie znaleziono elementu w koszu", http.StatusNotFound)
+				return
+			}
+			if err := os.RemoveAll(filepath.Join(config.TrashDir, entry.ID)); err != nil {
+				http.Error(w, fmt.Sprintf("Nie można opróżnić kosza: %v", err), http.StatusInternalServerError)
+				return
+			}
+			purged = append(purged, entry.ID)
+		default:
+			// Bez id: elementy starsze niż olderThanDays albo, tylko jawnie przez all=true, wszystko
+			days := 0
+			if olderThan := query.Get("olderThanDays"); olderThan != "" {
+				parsed, err := strconv.Atoi(olderThan)
+				if err != nil || parsed < 1 {
+					http.Error(w, "Parametr 'olderThanDays' musi być dodatnią liczbą całkowitą; aby opróżnić cały kosz, podaj 'all=true'", http.StatusBadRequest)
+					return
+				}
+				days = parsed
+			} else if query.Get("all") != "true" {
+				http.Error(w, "Podaj parametr 'id', 'olderThanDays' lub 'all=true'", http.StatusBadRequest)
+				return
+			}
+
+			var err error
+			if purged, err = purgeTrash(time.Duration(days) * 24 * time.Hour); err != nil {
+				http.Error(w, fmt.Sprintf("Nie można opróżnić kosza: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		recordAudit(r, "purgeTrash", "", "", map[string]interface{}{"purged": purged})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":       "success",
+			"purged_count": len(purged),
+			"purged":       purged,
+		})
+	}
+}
+
+// requireTrashMethod sprawdza, czy polecenie zmieniające kosz wysłano metodą POST lub DELETE
+func requireTrashMethod(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "Wymagana metoda POST lub DELETE", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// restoreTrashEntry przywraca bazę danych lub kolekcję z kosza pod oryginalną lub nową nazwą
+func restoreTrashEntry(entry trashEntry, newName string) (string, int, error) {
+	entryPath := filepath.Join(config.TrashDir, entry.ID)
+	trashedDB := filepath.Join(entryPath, trashDataDir)
+
+	switch entry.Type {
+	case "database":
+		dbName := entry.Database
+		if newName != "" {
+			dbName = newName
+		}
+
+		dbPath := utils.GetDatabasePath(config.DataDir, dbName)
+		if utils.FileExists(dbPath) {
+			return "", http.StatusConflict, fmt.Errorf("Baza danych '%s' już istnieje", dbName)
+		}
+		if err := os.Rename(trashedDB, dbPath); err != nil {
+			return "", http.StatusInternalServerError, fmt.Errorf("Nie można przywrócić bazy danych: %v", err)
+		}
+		dropIDIndexesWithPrefix(dbPath)
+		os.RemoveAll(entryPath)
+		return dbName, http.StatusOK, nil
+
+	case "collection":
+		collName := entry.Collection
+		if newName != "" {
+			collName = newName
+		}
+
+		dbPath := utils.GetDatabasePath(config.DataDir, entry.Database)
+		jsonFilePath := utils.GetCollectionPath(config.DataDir, entry.Database, collName)
+
+		lock := collectionLock(jsonFilePath)
+		lock.Lock()
+		defer lock.Unlock()
+
+		if utils.FileExists(jsonFilePath) {
+			return "", http.StatusConflict, fmt.Errorf("Kolekcja '%s' już istnieje w bazie '%s'", collName, entry.Database)
+		}
+		if err := utils.EnsureDirectoryExists(dbPath); err != nil {
+			return "", http.StatusInternalServerError, fmt.Errorf("Nie można utworzyć katalogu bazy danych: %v", err)
+		}
+		// Klucze, którymi zaszyfrowano kolekcję, mogły zostać usunięte z pęku bazy przy rotacji
+		if err := mergeKeyRing(dbPath, trashedDB); err != nil {
+			return "", http.StatusInternalServerError, fmt.Errorf("Nie można przywrócić kluczy kolekcji: %v", err)
+		}
+
+		sources := append([]string{utils.GetCollectionPath(trashedDB, "", entry.Collection)}, collectionSideFilesIn(trashedDB, entry.Collection)...)
+		targets := append([]string{jsonFilePath}, collectionSideFiles(entry.Database, collName)...)
+		for i, source := range sources {
+			if !utils.FileExists(source) {
+				continue
+			}
+			if err := utils.EnsureDirectoryExists(filepath.Dir(targets[i])); err != nil {
+				return "", http.StatusInternalServerError, fmt.Errorf("Nie można przywrócić kolekcji: %v", err)
+			}
+			if err := os.Rename(source, targets[i]); err != nil {
+				return "", http.StatusInternalServerError, fmt.Errorf("Nie można przywrócić kolekcji: %v", err)
+			}
+		}
+		dropIDIndex(jsonFilePath)
+		os.RemoveAll(entryPath)
+		return entry.Database + "/" + collName, http.StatusOK, nil
+	}
+
+	return "", http.StatusInternalServerError, fmt.Errorf("Nieznany typ elementu kosza: %s", entry.Type)
+}
+
+// purgeTrash trwale usuwa elementy kosza starsze niż podany wiek
+func purgeTrash(olderThan time.Duration) ([]string, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	entries, err := listTrashEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []string{}
+	cutoff := time.Now().UTC().Add(-olderThan)
+	for _, entry := range entries {
+		deletedAt, err := time.Parse(time.RFC3339, entry.DeletedAt)
+		if err == nil && deletedAt.After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(config.TrashDir, entry.ID)); err != nil {
+			return purged, err
+		}
+		purged = append(purged, entry.ID)
+	}
+	return purged, nil
+}
+
+// rotateTrashKeys szyfruje kluczem danych keyID bazy dbName elementy kosza pochodzące z tej bazy
+// i zostawia w ich kopiach pęku kluczy tylko ten klucz, aby klucze wycofane przy rotacji
+// nie przetrwały w koszu. Elementy bez kopii pęku (zapisane bez szyfrowania) są pomijane.
+func rotateTrashKeys(dbName, dbPath, keyID string) (int, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	entries, err := listTrashEntries()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, entry := range entries {
+		trashedDB := filepath.Join(config.TrashDir, entry.ID, trashDataDir)
+		if entry.Database != dbName || !utils.FileExists(filepath.Join(trashedDB, keyRingFile)) {
+			continue
+		}
+
+		err := adoptDataKey(trashedDB, dbPath, keyID)
+		if err == nil {
+			var reencrypted int
+			reencrypted, err = reencryptPaths([]string{trashedDB}, keyID)
+			count += reencrypted
+		}
+		if err == nil {
+			err = retainDataKey(trashedDB, keyID)
+		}
+		forgetKeyRing(trashedDB)
+		if err != nil {
+			return count, fmt.Errorf("element kosza %s: %v", entry.ID, err)
+		}
+	}
+	return count, nil
+}
+
+// StartTrashPurger uruchamia okresowe usuwanie elementów kosza starszych niż config.TrashRetentionDays
+func StartTrashPurger() {
+	if config.TrashRetentionDays <= 0 {
+		return
+	}
+
+	retention := time.Duration(config.TrashRetentionDays) * 24 * time.Hour
+	go func() {
+		for {
+			if purged, err := purgeTrash(retention); err != nil {
+				slog.Error("błąd automatycznego opróżniania kosza", "error", err)
+			} else if len(purged) > 0 {
+				slog.Info("usunięto elementy z kosza", "count", len(purged))
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
@@ -0,0 +1,727 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"BaseDB/config"
+	"BaseDB/models"
+	"BaseDB/utils"
+)
+
+const (
+	// webhookQueueSize to liczba wysyłek oczekujących na wolnego wysyłającego
+	webhookQueueSize = 1024
+
+	// webhookTimeout to maksymalny czas oczekiwania na odpowiedź odbiorcy
+	webhookTimeout = 10 * time.Second
+
+	// webhookMaxRetryDelay ogranicza opóźnienie między kolejnymi próbami
+	webhookMaxRetryDelay = 10 * time.Minute
+
+	// webhookLogBatchSize to liczba oczekujących wpisów dziennika kolekcji, po której
+	// są zapisywane od razu, bez czekania na kolejny zapis okresowy
+	webhookLogBatchSize = 100
+)
+
+// Stany wywołań webhooków w dzienniku
+const (
+	deliveryDelivered = "delivered"
+	deliveryRetrying  = "retrying"
+	deliveryFailed    = "failed"
+)
+
+// webhookEventTypes to typy zdarzeń, które można subskrybować
+var webhookEventTypes = map[string]bool{
+	changeInsert:     true,
+	changeUpdate:     true,
+	changeDelete:     true,
+	changeRename:     true,
+	changeDrop:       true,
+	changeInvalidate: true,
+}
+
+// webhook opisuje subskrypcję zdarzeń kolekcji wysyłanych na podany adres
+type webhook struct {
+	ID        string                 `json:"id"`
+	URL       string                 `json:"url"`
+	Events    []string               `json:"events,omitempty"` // puste - wszystkie zdarzenia
+	Filter    map[string]interface{} `json:"filter,omitempty"`
+	Secret    string                 `json:"secret,omitempty"`
+	CreatedAt string                 `json:"created_at"`
+}
+
+// accepts sprawdza, czy webhook subskrybuje zdarzenia danego typu
+func (wh *webhook) accepts(eventType string) bool {
+	if len(wh.Events) == 0 {
+		return true
+	}
+	for _, t := range wh.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookDelivery to wpis dziennika opisujący jedną próbę wysłania zdarzenia
+type webhookDelivery struct {
+	ID         string `json:"id"`
+	WebhookID  string `json:"webhook_id"`
+	Event      string `json:"event"`
+	Token      string `json:"token"`
+	DocumentID string `json:"document_id,omitempty"`
+	Attempt    int    `json:"attempt"`
+	Status     string `json:"status"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// deadLetter to zdarzenie, którego nie udało się wysłać mimo wszystkich prób
+type deadLetter struct {
+	ID        string      `json:"id"`
+	WebhookID string      `json:"webhook_id"`
+	URL       string      `json:"url"`
+	Event     changeEvent `json:"event"`
+	Attempts  int         `json:"attempts"`
+	LastError string      `json:"last_error"`
+	FailedAt  string      `json:"failed_at"`
+}
+
+// webhookFile to zawartość pliku webhooków kolekcji
+type webhookFile struct {
+	Webhooks    []webhook         `json:"webhooks"`
+	Deliveries  []webhookDelivery `json:"deliveries"`
+	DeadLetters []deadLetter      `json:"dead_letters"`
+}
+
+// webhookJob to zdarzenie oczekujące na wysłanie do jednego webhooka
+type webhookJob struct {
+	dbName     string
+	collName   string
+	hook       webhook
+	event      changeEvent
+	deliveryID string
+	attempt    int
+}
+
+// webhookLogKey wskazuje kolekcję, do której dziennika trafiają wpisy
+type webhookLogKey struct {
+	dbName   string
+	collName string
+}
+
+// pendingWebhookLog to wpisy dziennika kolekcji oczekujące na zapis do pliku webhooków
+type pendingWebhookLog struct {
+	deliveries  []webhookDelivery
+	deadLetters []deadLetter
+}
+
+// cachedWebhooks to webhooki kolekcji zapamiętane razem ze stanem pliku
+type cachedWebhooks struct {
+	webhooks []webhook
+	modTime  time.Time
+	size     int64
+}
+
+var (
+	webhooksMu    sync.Mutex
+	webhookCache  = map[string]*cachedWebhooks{}
+	webhookQueue  = make(chan webhookJob, webhookQueueSize)
+	webhookClient = &http.Client{Timeout: webhookTimeout}
+
+	webhookLogMu       sync.Mutex
+	pendingWebhookLogs = map[webhookLogKey]*pendingWebhookLog{}
+)
+
+// readWebhookFile odczytuje webhooki kolekcji, dziennik wywołań i nieudane zdarzenia
+func readWebhookFile(dbName, collName string) (webhookFile, error) {
+	var file webhookFile
+	err := readJSONDataFile(utils.GetCollectionWebhooksPath(config.DataDir, dbName, collName), &file)
+	return file, err
+}
+
+// writeWebhookFile zapisuje plik webhooków kolekcji, zachowując tylko ostatnie wpisy dziennika
+// i ostatnie nieudane zdarzenia. Wywołujący musi trzymać blokadę kolekcji.
+func writeWebhookFile(dbName, collName string, file webhookFile) error {
+	path := utils.GetCollectionWebhooksPath(config.DataDir, dbName, collName)
+	if err := utils.EnsureDirectoryExists(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if limit := config.WebhookLogSize; limit >= 0 && len(file.Deliveries) > limit {
+		file.Deliveries = file.Deliveries[len(file.Deliveries)-limit:]
+	}
+	if limit := config.WebhookDeadLetterSize; limit >= 0 && len(file.DeadLetters) > limit {
+		file.DeadLetters = file.DeadLetters[len(file.DeadLetters)-limit:]
+	}
+	if file.Webhooks == nil {
+		file.Webhooks = []webhook{}
+	}
+	if file.Deliveries == nil {
+		file.Deliveries = []webhookDelivery{}
+	}
+	if file.DeadLetters == nil {
+		file.DeadLetters = []deadLetter{}
+	}
+
+	if err := writeJSONDataFile(path, file); err != nil {
+		return err
+	}
+
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	cacheWebhooks(path, file.Webhooks)
+	return nil
+}
+
+// cacheWebhooks zapamiętuje webhooki kolekcji. Wywołujący musi trzymać webhooksMu.
+func cacheWebhooks(path string, webhooks []webhook) {
+	info, err := os.Stat(path)
+	if err != nil {
+		delete(webhookCache, path)
+		return
+	}
+	webhookCache[path] = &cachedWebhooks{webhooks: webhooks, modTime: info.ModTime(), size: info.Size()}
+}
+
+// collectionWebhooks zwraca webhooki kolekcji, której dotyczy zdarzenie.
+// Po usunięciu lub zmianie nazwy kolekcji jej plik jest już przeniesiony, więc zdarzenie
+// trafia do webhooków zapamiętanych wcześniej.
+func collectionWebhooks(event changeEvent) []webhook {
+	path := utils.GetCollectionWebhooksPath(config.DataDir, event.Database, event.Collection)
+
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		cached := webhookCache[path]
+		delete(webhookCache, path)
+		if cached == nil {
+			return nil
+		}
+		switch event.Type {
+		case changeRename:
+			newPath := utils.GetCollectionWebhooksPath(config.DataDir, event.Database, event.NewName)
+			cacheWebhooks(newPath, cached.webhooks)
+			return cached.webhooks
+		case changeDrop:
+			return cached.webhooks
+		}
+		return nil
+	}
+
+	if cached := webhookCache[path]; cached != nil && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.webhooks
+	}
+
+	file, err := readWebhookFile(event.Database, event.Collection)
+	if err != nil {
+		slog.Error("nie można odczytać webhooków kolekcji", "database", event.Database, "collection", event.Collection, "error", err)
+		return nil
+	}
+	webhookCache[path] = &cachedWebhooks{webhooks: file.Webhooks, modTime: info.ModTime(), size: info.Size()}
+	return file.Webhooks
+}
+
+// dispatchWebhooks kolejkuje wysłanie zdarzeń do webhooków kolekcji, których dotyczą.
+// Zdarzenia baz danych nie są wysyłane, bo webhooki należą do kolekcji.
+func dispatchWebhooks(events []changeEvent) {
+	filters := map[string]map[string]interface{}{}
+	for _, event := range events {
+		if event.Collection == "" {
+			continue
+		}
+
+		for _, hook := range collectionWebhooks(event) {
+			if !hook.accepts(event.Type) {
+				continue
+			}
+
+			if hook.Filter != nil && event.Document != nil {
+				sealed, ok := filters[hook.ID]
+				if !ok {
+					sealed = make(map[string]interface{}, len(hook.Filter))
+					for field, condition := range hook.Filter {
+						sealed[field] = condition
+					}
+					if err := sealQueryFor(utils.GetCollectionPath(config.DataDir, event.Database, event.Collection), sealed); err != nil {
+						sealed = nil
+					}
+					filters[hook.ID] = sealed
+				}
+				if sealed == nil || !matchesQuery(event.Document, sealed) {
+					continue
+				}
+			}
+
+			enqueueWebhook(webhookJob{
+				dbName:     event.Database,
+				collName:   event.Collection,
+				hook:       hook,
+				event:      event,
+				deliveryID: uuid.New().String(),
+				attempt:    1,
+			})
+		}
+	}
+}
+
+// enqueueWebhook dodaje wysyłkę do kolejki. Gdy kolejka jest pełna, zdarzenie od razu trafia
+// na listę nieudanych, bo wywołujący może trzymać blokadę kolekcji i nie powinien czekać.
+func enqueueWebhook(job webhookJob) {
+	select {
+	case webhookQueue <- job:
+	default:
+		go recordWebhookDelivery(job, webhookDelivery{
+			ID:         job.deliveryID,
+			WebhookID:  job.hook.ID,
+			Event:      job.event.Type,
+			Token:      job.event.Token,
+			DocumentID: job.event.DocumentID,
+			Attempt:    job.attempt,
+			Status:     deliveryFailed,
+			Error:      "kolejka webhooków jest pełna",
+			Timestamp:  models.GetCurrentTimestamp(),
+		})
+	}
+}
+
+// StartWebhookWorkers wczytuje webhooki wszystkich kolekcji i uruchamia wysyłanie zdarzeń
+func StartWebhookWorkers() {
+	paths, _ := filepath.Glob(filepath.Join(config.DataDir, "*", ".webhooks", "*.json"))
+	for _, path := range paths {
+		dbName := filepath.Base(filepath.Dir(filepath.Dir(path)))
+		collName := filepath.Base(path[:len(path)-len(".json")])
+		file, err := readWebhookFile(dbName, collName)
+		if err != nil {
+			slog.Error("nie można odczytać webhooków kolekcji", "database", dbName, "collection", collName, "error", err)
+			continue
+		}
+		webhooksMu.Lock()
+		cacheWebhooks(path, file.Webhooks)
+		webhooksMu.Unlock()
+	}
+
+	for i := 0; i < config.WebhookWorkers; i++ {
+		go func() {
+			for job := range webhookQueue {
+				deliverWebhook(job)
+			}
+		}()
+	}
+
+	interval := time.Duration(config.WebhookLogFlushMs) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+	go func() {
+		for range time.Tick(interval) {
+			flushWebhookLogs()
+		}
+	}()
+}
+
+// deliverWebhook wysyła zdarzenie do odbiorcy. Nieudana próba jest ponawiana z wykładniczo
+// rosnącym opóźnieniem, a po wyczerpaniu prób zdarzenie trafia na listę nieudanych.
+func deliverWebhook(job webhookJob) {
+	delivery := webhookDelivery{
+		ID:         job.deliveryID,
+		WebhookID:  job.hook.ID,
+		Event:      job.event.Type,
+		Token:      job.event.Token,
+		DocumentID: job.event.DocumentID,
+		Attempt:    job.attempt,
+	}
+
+	start := time.Now()
+	statusCode, err := postWebhook(job)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	delivery.StatusCode = statusCode
+	delivery.Timestamp = models.GetCurrentTimestamp()
+
+	switch {
+	case err == nil:
+		delivery.Status = deliveryDelivered
+	case job.attempt < config.WebhookMaxAttempts:
+		delivery.Status = deliveryRetrying
+		delivery.Error = err.Error()
+
+		delay := time.Duration(config.WebhookRetryDelayMs) * time.Millisecond << (job.attempt - 1)
+		if delay > webhookMaxRetryDelay || delay <= 0 {
+			delay = webhookMaxRetryDelay
+		}
+		retry := job
+		retry.attempt++
+		time.AfterFunc(delay, func() { enqueueWebhook(retry) })
+	default:
+		delivery.Status = deliveryFailed
+		delivery.Error = err.Error()
+	}
+
+	recordWebhookDelivery(job, delivery)
+}
+
+// postWebhook wysyła zdarzenie podpisane HMAC-SHA256 sekretu webhooka.
+// Podpis obejmuje znacznik czasu i treść: "<X-BaseDB-Timestamp>.<treść>".
+func postWebhook(job webhookJob) (int, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":         job.deliveryID,
+		"webhook_id": job.hook.ID,
+		"attempt":    job.attempt,
+		"event":      job.event,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, job.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(job.hook.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "BaseDB-Webhook/1")
+	req.Header.Set("X-BaseDB-Event", job.event.Type)
+	req.Header.Set("X-BaseDB-Delivery", job.deliveryID)
+	req.Header.Set("X-BaseDB-Timestamp", timestamp)
+	req.Header.Set("X-BaseDB-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("odbiorca odpowiedział kodem %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordWebhookDelivery dodaje próbę wysłania do dziennika kolekcji, a nieudane zdarzenie
+// do listy nieudanych. Wpisy czekają w pamięci i są zapisywane do pliku partiami.
+func recordWebhookDelivery(job webhookJob, delivery webhookDelivery) {
+	key := webhookLogKey{dbName: job.dbName, collName: job.collName}
+
+	webhookLogMu.Lock()
+	pending := pendingWebhookLogs[key]
+	if pending == nil {
+		pending = &pendingWebhookLog{}
+		pendingWebhookLogs[key] = pending
+	}
+	pending.deliveries = append(pending.deliveries, delivery)
+	if delivery.Status == deliveryFailed {
+		pending.deadLetters = append(pending.deadLetters, deadLetter{
+			ID:        job.deliveryID,
+			WebhookID: job.hook.ID,
+			URL:       job.hook.URL,
+			Event:     job.event,
+			Attempts:  job.attempt,
+			LastError: delivery.Error,
+			FailedAt:  delivery.Timestamp,
+		})
+	}
+	full := len(pending.deliveries) >= webhookLogBatchSize
+	webhookLogMu.Unlock()
+
+	if full {
+		flushWebhookLog(job.dbName, job.collName)
+	}
+}
+
+// flushWebhookLogs zapisuje oczekujące wpisy dziennika wszystkich kolekcji
+func flushWebhookLogs() {
+	webhookLogMu.Lock()
+	keys := make([]webhookLogKey, 0, len(pendingWebhookLogs))
+	for key := range pendingWebhookLogs {
+		keys = append(keys, key)
+	}
+	webhookLogMu.Unlock()
+
+	for _, key := range keys {
+		flushWebhookLog(key.dbName, key.collName)
+	}
+}
+
+// flushWebhookLog zapisuje oczekujące wpisy dziennika kolekcji
+func flushWebhookLog(dbName, collName string) {
+	lock := collectionLock(utils.GetCollectionPath(config.DataDir, dbName, collName))
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := flushWebhookLogLocked(dbName, collName); err != nil {
+		slog.Error("nie można zapisać dziennika webhooków kolekcji", "database", dbName, "collection", collName, "error", err)
+	}
+}
+
+// flushWebhookLogLocked dopisuje oczekujące wpisy dziennika do pliku webhooków kolekcji
+// jednym zapisem. Wpisy usuniętej lub przemianowanej kolekcji są pomijane.
+// Wywołujący musi trzymać blokadę kolekcji.
+func flushWebhookLogLocked(dbName, collName string) error {
+	key := webhookLogKey{dbName: dbName, collName: collName}
+	webhookLogMu.Lock()
+	pending := pendingWebhookLogs[key]
+	delete(pendingWebhookLogs, key)
+	webhookLogMu.Unlock()
+
+	if pending == nil || !utils.FileExists(utils.GetCollectionPath(config.DataDir, dbName, collName)) {
+		return nil
+	}
+
+	file, err := readWebhookFile(dbName, collName)
+	if err != nil {
+		return err
+	}
+	file.Deliveries = append(file.Deliveries, pending.deliveries...)
+	file.DeadLetters = append(file.DeadLetters, pending.deadLetters...)
+	return writeWebhookFile(dbName, collName, file)
+}
+
+// handleWebhookOperation obsługuje polecenia webhooków kolekcji: addWebhook, listWebhooks,
+// deleteWebhook, webhookDeliveries, deadLetters, redeliver
+func handleWebhookOperation(w http.ResponseWriter, r *http.Request, jsonFilePath, dbName, collName, command string) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if !utils.FileExists(jsonFilePath) {
+		http.Error(w, "Kolekcja nie istnieje", http.StatusNotFound)
+		return
+	}
+
+	var hook webhook
+	if command == "addWebhook" {
+		if r.Method != "POST" {
+			http.Error(w, "Wymagana metoda POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := decodeJSONBody(w, r, &hook); err != nil {
+			writeBodyError(w, err, "Nieprawidłowy format JSON")
+			return
+		}
+		if !validateWebhook(w, &hook) {
+			return
+		}
+	}
+
+	query := r.URL.Query()
+	lock := collectionLock(jsonFilePath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Oczekujące wpisy dziennika są zapisywane przed odczytem, aby odpowiedź była aktualna
+	if err := flushWebhookLogLocked(dbName, collName); err != nil {
+		http.Error(w, fmt.Sprintf("Nie można zapisać dziennika webhooków: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	file, err := readWebhookFile(dbName, collName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Nie można odczytać webhooków: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	switch command {
+	case "addWebhook":
+		file.Webhooks = append(file.Webhooks, hook)
+		if err := writeWebhookFile(dbName, collName, file); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można zapisać webhooków: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(r, "addWebhook", dbName, collName, map[string]interface{}{
+			"webhook_id": hook.ID, "url": hook.URL, "events": hook.Events,
+		})
+
+		// Sekret jest zwracany tylko przy tworzeniu webhooka
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": fmt.Sprintf("Dodano webhook do kolekcji '%s'", collName),
+			"webhook": hook,
+		})
+
+	case "listWebhooks":
+		webhooks := make([]webhook, len(file.Webhooks))
+		for i, hook := range file.Webhooks {
+			hook.Secret = ""
+			webhooks[i] = hook
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "success",
+			"count":    len(webhooks),
+			"webhooks": webhooks,
+		})
+
+	case "deleteWebhook":
+		index := -1
+		for i := range file.Webhooks {
+			if file.Webhooks[i].ID == query.Get("id") {
+				index = i
+			}
+		}
+		if index < 0 {
+			http.Error(w, "Nie znaleziono webhooka", http.StatusNotFound)
+			return
+		}
+
+		file.Webhooks = append(file.Webhooks[:index], file.Webhooks[index+1:]...)
+		if err := writeWebhookFile(dbName, collName, file); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można zapisać webhooków: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(r, "deleteWebhook", dbName, collName, map[string]interface{}{"webhook_id": query.Get("id")})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "success",
+			"message": "Webhook został usunięty",
+		})
+
+	case "webhookDeliveries":
+		// Najnowsze wpisy na początku, opcjonalnie tylko jednego webhooka lub w danym stanie
+		deliveries := []webhookDelivery{}
+		for i := len(file.Deliveries) - 1; i >= 0; i-- {
+			delivery := file.Deliveries[i]
+			if id := query.Get("webhook"); id != "" && delivery.WebhookID != id {
+				continue
+			}
+			if status := query.Get("status"); status != "" && delivery.Status != status {
+				continue
+			}
+			deliveries = append(deliveries, delivery)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":     "success",
+			"count":      len(deliveries),
+			"deliveries": deliveries,
+		})
+
+	case "deadLetters":
+		letters := []deadLetter{}
+		for _, letter := range file.DeadLetters {
+			if id := query.Get("webhook"); id == "" || letter.WebhookID == id {
+				letters = append(letters, letter)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":       "success",
+			"count":        len(letters),
+			"dead_letters": letters,
+		})
+
+	case "redeliver":
+		// Ponowne wysłanie nieudanego zdarzenia, z nową serią prób
+		index := -1
+		for i := range file.DeadLetters {
+			if file.DeadLetters[i].ID == query.Get("id") {
+				index = i
+			}
+		}
+		if index < 0 {
+			http.Error(w, "Nie znaleziono nieudanego zdarzenia", http.StatusNotFound)
+			return
+		}
+		letter := file.DeadLetters[index]
+
+		var target *webhook
+		for i := range file.Webhooks {
+			if file.Webhooks[i].ID == letter.WebhookID {
+				target = &file.Webhooks[i]
+			}
+		}
+		if target == nil {
+			http.Error(w, "Webhook tego zdarzenia został usunięty", http.StatusNotFound)
+			return
+		}
+
+		file.DeadLetters = append(file.DeadLetters[:index], file.DeadLetters[index+1:]...)
+		if err := writeWebhookFile(dbName, collName, file); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można zapisać webhooków: %v", err), http.StatusInternalServerError)
+			return
+		}
+		enqueueWebhook(webhookJob{
+			dbName:     dbName,
+			collName:   collName,
+			hook:       *target,
+			event:      letter.Event,
+			deliveryID: letter.ID,
+			attempt:    1,
+		})
+		recordAudit(r, "redeliverWebhook", dbName, collName, map[string]interface{}{
+			"webhook_id": letter.WebhookID, "delivery_id": letter.ID,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "success",
+			"message": "Zdarzenie zostanie wysłane ponownie",
+		})
+	}
+}
+
+// validateWebhook sprawdza nowy webhook i uzupełnia jego id, sekret i datę utworzenia.
+// W razie błędu zapisuje odpowiedź i zwraca false.
+func validateWebhook(w http.ResponseWriter, hook *webhook) bool {
+	target, err := url.Parse(hook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "Pole 'url' musi być adresem http lub https", http.StatusBadRequest)
+		return false
+	}
+
+	for _, eventType := range hook.Events {
+		if !webhookEventTypes[eventType] {
+			http.Error(w, fmt.Sprintf("Nieznany typ zdarzenia '%s'", eventType), http.StatusBadRequest)
+			return false
+		}
+	}
+
+	if hook.Filter != nil && !validateOperators(w, hook.Filter) {
+		return false
+	}
+
+	if hook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			http.Error(w, fmt.Sprintf("Nie można wygenerować sekretu: %v", err), http.StatusInternalServerError)
+			return false
+		}
+		hook.Secret = hex.EncodeToString(secret)
+	}
+
+	hook.ID = uuid.New().String()
+	hook.CreatedAt = models.GetCurrentTimestamp()
+	return true
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"BaseDB/config"
+	"BaseDB/utils"
+)
+
+// setupWebhookCollection tworzy pustą kolekcję w katalogu tymczasowym i ustawia
+// parametry ponawiania webhooków na czas testu
+func setupWebhookCollection(t *testing.T, maxAttempts, retryDelayMs int) (string, string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts, delay, deadLetters := config.WebhookMaxAttempts, config.WebhookRetryDelayMs, config.WebhookDeadLetterSize
+	config.WebhookMaxAttempts, config.WebhookRetryDelayMs = maxAttempts, retryDelayMs
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		config.WebhookMaxAttempts, config.WebhookRetryDelayMs, config.WebhookDeadLetterSize = attempts, delay, deadLetters
+	})
+
+	dbName, collName := "db", "orders"
+	if err := utils.EnsureDirectoryExists(utils.GetDatabasePath(config.DataDir, dbName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(utils.GetCollectionPath(config.DataDir, dbName, collName), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dbName, collName
+}
+
+// testWebhookJob zwraca pierwszą próbę wysłania zdarzenia insert do podanego adresu
+func testWebhookJob(dbName, collName, url string) webhookJob {
+	return webhookJob{
+		dbName:   dbName,
+		collName: collName,
+		hook:     webhook{ID: "hook-1", URL: url, Secret: "sekret"},
+		event: changeEvent{
+			Type:       changeInsert,
+			Token:      "1",
+			Database:   dbName,
+			Collection: collName,
+			DocumentID: "doc-1",
+		},
+		deliveryID: "delivery-1",
+		attempt:    1,
+	}
+}
+
+// failingReceiver to odbiorca odpowiadający zawsze kodem 500
+func failingReceiver(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// flushedWebhookFile zapisuje oczekujące wpisy dziennika i odczytuje plik webhooków kolekcji
+func flushedWebhookFile(t *testing.T, dbName, collName string) webhookFile {
+	t.Helper()
+	flushWebhookLog(dbName, collName)
+	file, err := readWebhookFile(dbName, collName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestPostWebhookSignature(t *testing.T) {
+	var mu sync.Mutex
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	job := testWebhookJob("db", "orders", server.URL)
+	status, err := postWebhook(job)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("postWebhook = %d, %v; oczekiwano 200 bez błędu", status, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	mac := hmac.New(sha256.New, []byte(job.hook.Secret))
+	mac.Write([]byte(header.Get("X-BaseDB-Timestamp") + "."))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); header.Get("X-BaseDB-Signature") != want {
+		t.Errorf("podpis %q, oczekiwano %q", header.Get("X-BaseDB-Signature"), want)
+	}
+	if header.Get("X-BaseDB-Event") != changeInsert {
+		t.Errorf("X-BaseDB-Event = %q, oczekiwano %q", header.Get("X-BaseDB-Event"), changeInsert)
+	}
+	if header.Get("X-BaseDB-Delivery") != job.deliveryID {
+		t.Errorf("X-BaseDB-Delivery = %q, oczekiwano %q", header.Get("X-BaseDB-Delivery"), job.deliveryID)
+	}
+}
+
+func TestDeliverWebhookRetriesWithBackoff(t *testing.T) {
+	dbName, collName := setupWebhookCollection(t, 3, 20)
+	server := failingReceiver(t)
+
+	job := testWebhookJob(dbName, collName, server.URL)
+	for _, wantDelay := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		start := time.Now()
+		deliverWebhook(job)
+
+		select {
+		case retry := <-webhookQueue:
+			if elapsed := time.Since(start); elapsed < wantDelay {
+				t.Errorf("ponowna próba %d po %v, oczekiwano co najmniej %v", retry.attempt, elapsed, wantDelay)
+			}
+			if retry.attempt != job.attempt+1 || retry.deliveryID != job.deliveryID {
+				t.Fatalf("ponowna próba %d (%s), oczekiwano %d (%s)", retry.attempt, retry.deliveryID, job.attempt+1, job.deliveryID)
+			}
+			job = retry
+		case <-time.After(time.Second):
+			t.Fatalf("brak ponownej próby po próbie %d", job.attempt)
+		}
+	}
+
+	file := flushedWebhookFile(t, dbName, collName)
+	if len(file.Deliveries) != 2 {
+		t.Fatalf("dziennik ma %d wpisów, oczekiwano 2", len(file.Deliveries))
+	}
+	for i, delivery := range file.Deliveries {
+		if delivery.Status != deliveryRetrying || delivery.Attempt != i+1 || delivery.StatusCode != http.StatusInternalServerError {
+			t.Errorf("wpis %d: stan %q, próba %d, kod %d", i, delivery.Status, delivery.Attempt, delivery.StatusCode)
+		}
+	}
+	if len(file.DeadLetters) != 0 {
+		t.Errorf("lista nieudanych ma %d zdarzeń przed wyczerpaniem prób", len(file.DeadLetters))
+	}
+}
+
+func TestDeliverWebhookDeadLetter(t *testing.T) {
+	dbName, collName := setupWebhookCollection(t, 2, 10)
+	server := failingReceiver(t)
+
+	job := testWebhookJob(dbName, collName, server.URL)
+	job.attempt = 2
+	deliverWebhook(job)
+
+	select {
+	case retry := <-webhookQueue:
+		t.Fatalf("nieoczekiwana ponowna próba %d po wyczerpaniu prób", retry.attempt)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	file := flushedWebhookFile(t, dbName, collName)
+	if len(file.Deliveries) != 1 || file.Deliveries[0].Status != deliveryFailed {
+		t.Fatalf("dziennik %+v, oczekiwano jednego wpisu w stanie %q", file.Deliveries, deliveryFailed)
+	}
+	if len(file.DeadLetters) != 1 {
+		t.Fatalf("lista nieudanych ma %d zdarzeń, oczekiwano 1", len(file.DeadLetters))
+	}
+	letter := file.DeadLetters[0]
+	if letter.ID != job.deliveryID || letter.Attempts != 2 || letter.LastError == "" || letter.Event.DocumentID != "doc-1" {
+		t.Errorf("nieudane zdarzenie %+v", letter)
+	}
+}
+
+func TestDeadLettersAreCapped(t *testing.T) {
+	dbName, collName := setupWebhookCollection(t, 1, 10)
+	config.WebhookDeadLetterSize = 2
+	server := failingReceiver(t)
+
+	for _, id := range []string{"d1", "d2", "d3"} {
+		job := testWebhookJob(dbName, collName, server.URL)
+		job.deliveryID = id
+		deliverWebhook(job)
+	}
+
+	file := flushedWebhookFile(t, dbName, collName)
+	if len(file.DeadLetters) != 2 || file.DeadLetters[0].ID != "d2" || file.DeadLetters[1].ID != "d3" {
+		t.Errorf("lista nieudanych %+v, oczekiwano ostatnich zdarzeń d2 i d3", file.DeadLetters)
+	}
+}
@@ -2,8 +2,8 @@
 package main
 
 import (
-	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 
@@ -12,13 +12,33 @@ import (
 )
 
 func main() {
+	// Logi serwera, w tym log dostępu, są zapisywane jako JSON
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
 	// Upewnij się, że katalog danych istnieje
 	os.MkdirAll(config.DataDir, 0755)
 
+	// Wczytaj klucz główny szyfrowania danych
+	if err := handlers.InitEncryption(); err != nil {
+		log.Fatalf("Nie można włączyć szyfrowania: %v", err)
+	}
+
+	// Wysyłanie zdarzeń zmian do webhooków
+	handlers.StartWebhookWorkers()
+
+	// Okresowo usuwaj stare elementy z kosza
+	handlers.StartTrashPurger()
+
 	// Definicja tras
-	http.HandleFunc("/api/database/", handlers.HandleAPI)
+	http.HandleFunc("/api/database/", handlers.Instrument(handlers.RateLimit(handlers.HandleAPI)))
+	http.HandleFunc("/dbs", handlers.Instrument(handlers.RateLimit(handlers.HandleREST)))
+	http.HandleFunc("/dbs/", handlers.Instrument(handlers.RateLimit(handlers.HandleREST)))
+	http.HandleFunc("/healthz", handlers.HandleHealth)
+	http.HandleFunc("/readyz", handlers.HandleReady)
+	http.HandleFunc("/metrics", handlers.HandleMetrics)
+	handlers.MarkReady()
 
 	// Uruchomienie serwera
-	fmt.Printf("Serwer uruchomiony na http://localhost:%s\n", config.Port)
+	slog.Info("Serwer uruchomiony", "address", "http://localhost:"+config.Port)
 	log.Fatal(http.ListenAndServe(":"+config.Port, nil))
 }
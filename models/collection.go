@@ -0,0 +1,29 @@
+package models
+
+// CollectionOptions przechowuje ustawienia kolekcji
+type CollectionOptions struct {
+	// History włącza zapisywanie poprzednich wersji dokumentów
+	History bool `json:"history"`
+	// MaxRevisions ogranicza liczbę przechowywanych wersji dokumentu (0 - bez limitu)
+	MaxRevisions int `json:"max_revisions,omitempty"`
+	// EncryptedFields to pola dokumentów szyfrowane przed zapisem
+	EncryptedFields []EncryptedField `json:"encrypted_fields,omitempty"`
+}
+
+// EncryptedField opisuje pole dokumentu szyfrowane przed zapisem
+type EncryptedField struct {
+	// Path to ścieżka pola, dla pól zagnieżdżonych z kropkami (np. "dane.pesel")
+	Path string `json:"path"`
+	// Deterministic sprawia, że ta sama wartość daje ten sam szyfrogram,
+	// co pozwala wyszukiwać dokumenty po równości kosztem ujawnienia powtórzeń
+	Deterministic bool `json:"deterministic,omitempty"`
+}
+
+// Revision reprezentuje zapisaną wersję dokumentu
+type Revision struct {
+	Version    int64    `json:"version"`
+	Timestamp  string   `json:"timestamp"`
+	ArchivedAt string   `json:"archived_at"`
+	Deleted    bool     `json:"deleted,omitempty"`
+	Document   Document `json:"document"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,7 +10,11 @@ import (
 // Document reprezentuje dokument w kolekcji
 type Document map[string]interface{}
 
-// AddMetadata dodaje metadane do dokumentu (id, created_at, updated_at)
+// VersionField to nazwa pola z numerem wersji dokumentu
+const VersionField = "_version"
+
+// AddMetadata dodaje metadane do dokumentu (id, created_at, updated_at, _version).
+// Nowy dokument zawsze zaczyna od wersji 1, niezależnie od wersji podanej przez klienta.
 func AddMetadata(doc Document) Document {
 	// Jeśli dokument już ma id, zachowaj je
 	if _, exists := doc["id"]; !exists {
@@ -26,9 +31,50 @@ func AddMetadata(doc Document) Document {
 	// Zawsze aktualizuj updated_at
 	doc["updated_at"] = now
 
+	doc[VersionField] = int64(1)
+
+	return doc
+}
+
+// AddImportMetadata dodaje metadane do dokumentu importowanego z eksportu lub kopii,
+// zachowując jego wersję, jeśli jest poprawna
+func AddImportMetadata(doc Document) Document {
+	version := GetVersion(doc)
+	doc = AddMetadata(doc)
+	if version > 1 {
+		doc[VersionField] = version
+	}
+	return doc
+}
+
+// GetVersion zwraca numer wersji dokumentu (0 dla dokumentów bez wersji)
+func GetVersion(doc Document) int64 {
+	switch v := doc[VersionField].(type) {
+	case float64:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+	}
+	return 0
+}
+
+// BumpVersion ustawia w dokumencie wersję następną po wersji poprzedniego dokumentu
+func BumpVersion(doc, previous Document) Document {
+	doc[VersionField] = GetVersion(previous) + 1
 	return doc
 }
 
+// ETag zwraca znacznik ETag odpowiadający wersji dokumentu
+func ETag(doc Document) string {
+	return strconv.Quote(strconv.FormatInt(GetVersion(doc), 10))
+}
+
 // GetCurrentTimestamp zwraca aktualny czas w formacie RFC3339
 func GetCurrentTimestamp() string {
 	return time.Now().UTC().Format(time.RFC3339)
@@ -72,6 +72,13 @@ func ListJSONFiles(dirPath string) ([]string, error) {
 	return files, nil
 }
 
+// IsValidName sprawdza, czy nazwa bazy danych lub kolekcji może posłużyć jako nazwa pliku
+// w katalogu danych: nie może być pusta, zawierać separatorów ścieżki ani zaczynać się
+// od kropki, zarezerwowanej dla plików pomocniczych
+func IsValidName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\\x00")
+}
+
 // GetDatabasePath zwraca pełną ścieżkę do katalogu bazy danych
 func GetDatabasePath(baseDir, dbName string) string {
 	return filepath.Join(baseDir, dbName)
@@ -81,3 +88,23 @@ func GetDatabasePath(baseDir, dbName string) string {
 func GetCollectionPath(baseDir, dbName, collName string) string {
 	return filepath.Join(baseDir, dbName, collName+".json")
 }
+
+// GetCollectionMetaPath zwraca ścieżkę do pliku z ustawieniami kolekcji
+func GetCollectionMetaPath(baseDir, dbName, collName string) string {
+	return filepath.Join(baseDir, dbName, ".meta", collName+".json")
+}
+
+// GetCollectionHistoryPath zwraca ścieżkę do pliku z historią zmian kolekcji
+func GetCollectionHistoryPath(baseDir, dbName, collName string) string {
+	return filepath.Join(baseDir, dbName, ".history", collName+".json")
+}
+
+// GetCollectionSegmentsPath zwraca ścieżkę do katalogu z segmentami kolekcji
+func GetCollectionSegmentsPath(baseDir, dbName, collName string) string {
+	return filepath.Join(baseDir, dbName, ".segments", collName)
+}
+
+// GetCollectionWebhooksPath zwraca ścieżkę do pliku z webhookami kolekcji i dziennikiem ich wywołań
+func GetCollectionWebhooksPath(baseDir, dbName, collName string) string {
+	return filepath.Join(baseDir, dbName, ".webhooks", collName+".json")
+}